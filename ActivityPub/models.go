@@ -4,13 +4,13 @@ import (
 	"crypto/rsa"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"time"
+	"strconv"
+	"strings"
 
-	cache "github.com/patrickmn/go-cache"
 	uuid "github.com/satori/go.uuid"
+	actorcache "github.com/yukimochi/Activity-Relay/ActorCache"
 	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
 )
 
@@ -21,6 +21,33 @@ type PublicKey struct {
 	PublicKeyPem string `json:"publicKeyPem,omitempty"`
 }
 
+// Multikey : FEP-521a verification method, such as Ed25519 key.
+type Multikey struct {
+	ID                 string `json:"id,omitempty"`
+	Type               string `json:"type,omitempty"`
+	Controller         string `json:"controller,omitempty"`
+	PublicKeyMultibase string `json:"publicKeyMultibase,omitempty"`
+}
+
+// Multikeys : List of Multikey. Single object is accepted, and entries which are not object are ignored.
+type Multikeys []Multikey
+
+// UnmarshalJSON : Decode assertionMethod without failing whole actor on unexpected form.
+func (multikeys *Multikeys) UnmarshalJSON(data []byte) error {
+	var entries []json.RawMessage
+	if json.Unmarshal(data, &entries) != nil {
+		entries = []json.RawMessage{data}
+	}
+	*multikeys = nil
+	for _, entry := range entries {
+		var multikey Multikey
+		if json.Unmarshal(entry, &multikey) == nil {
+			*multikeys = append(*multikeys, multikey)
+		}
+	}
+	return nil
+}
+
 //Endpoints : Contains SharedInbox address.
 type Endpoints struct {
 	SharedInbox string `json:"sharedInbox,omitempty"`
@@ -40,19 +67,30 @@ type Actor struct {
 	PreferredUsername string      `json:"preferredUsername,omitempty"`
 	Summary           string      `json:"summary,omitempty"`
 	Inbox             string      `json:"inbox,omitempty"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	Following         string      `json:"following,omitempty"`
 	Endpoints         *Endpoints  `json:"endpoints,omitempty"`
 	PublicKey         PublicKey   `json:"publicKey,omitempty"`
+	AssertionMethod   Multikeys   `json:"assertionMethod,omitempty"`
 	Icon              Image       `json:"icon,omitempty"`
 	Image             Image       `json:"image,omitempty"`
 }
 
 // GenerateSelfKey : Generate relay Actor from Publickey.
+// When hostname has path such as /tags/art, topic relay Actor is served under the path.
 func (actor *Actor) GenerateSelfKey(hostname *url.URL, publickey *rsa.PublicKey) {
 	actor.Context = []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"}
 	actor.ID = hostname.String() + "/actor"
 	actor.Type = "Service"
 	actor.PreferredUsername = "relay"
+	if topic := strings.Trim(hostname.Path, "/"); topic != "" {
+		actor.PreferredUsername = strings.Replace(topic, "/", "_", -1)
+	}
 	actor.Inbox = hostname.String() + "/inbox"
+	actor.Outbox = hostname.String() + "/actor/outbox"
+	actor.Followers = hostname.String() + "/actor/followers"
+	actor.Following = hostname.String() + "/actor/following"
 	actor.PublicKey = PublicKey{
 		hostname.String() + "/actor#main-key",
 		hostname.String() + "/actor",
@@ -60,39 +98,36 @@ func (actor *Actor) GenerateSelfKey(hostname *url.URL, publickey *rsa.PublicKey)
 	}
 }
 
-// RetrieveRemoteActor : Retrieve Actor from remote instance.
-func (actor *Actor) RetrieveRemoteActor(url string, uaString string, cache *cache.Cache) error {
-	var err error
-	cacheData, found := cache.Get(url)
-	if found {
-		err = json.Unmarshal(cacheData.([]byte), &actor)
-		if err != nil {
-			cache.Delete(url)
-		} else {
-			return nil
-		}
-	}
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Accept", "application/activity+json")
-	req.Header.Set("User-Agent", uaString)
-	client := new(http.Client)
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+// GenerateFollow : Generate Follow activity to this Actor from relay.
+func (actor *Actor) GenerateFollow(host *url.URL) Activity {
+	return Activity{
+		[]string{"https://www.w3.org/ns/activitystreams"},
+		host.String() + "/activities/" + uuid.NewV4().String(),
+		host.String() + "/actor",
+		"Follow",
+		actor.ID,
+		nil,
+		nil,
+		nil,
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != 200 {
-		return errors.New(resp.Status)
-	}
+// RequestSigner : Sign GET request, for instances which require authorized fetch.
+type RequestSigner func(request *http.Request) error
 
-	data, _ := ioutil.ReadAll(resp.Body)
+// RetrieveRemoteActor : Retrieve Actor from remote instance through cache. Request is signed by signer unless it is nil.
+// It returns how actor is served by cache.
+func (actor *Actor) RetrieveRemoteActor(url string, uaString string, cache *actorcache.Cache, signer RequestSigner) (string, error) {
+	data, result, err := cache.Fetch(url, uaString, signer)
+	if err != nil {
+		return result, err
+	}
 	err = json.Unmarshal(data, &actor)
 	if err != nil {
-		return err
+		cache.Delete(url)
+		return result, err
 	}
-	cache.Set(url, data, 5*time.Minute)
-	return nil
+	return result, nil
 }
 
 // Activity : ActivityPub Activity.
@@ -104,6 +139,18 @@ type Activity struct {
 	Object  interface{} `json:"object,omitempty"`
 	To      []string    `json:"to,omitempty"`
 	Cc      []string    `json:"cc,omitempty"`
+	// RelayVia : Relay actors which forwarded this activity, to prevent loop between peer relays
+	RelayVia []string `json:"relayVia,omitempty"`
+}
+
+// RelayedBy : Check activity is already forwarded by relay actor.
+func (activity *Activity) RelayedBy(actorID string) bool {
+	for _, via := range activity.RelayVia {
+		if via == actorID {
+			return true
+		}
+	}
+	return false
 }
 
 // GenerateResponse : Generate activity response.
@@ -116,6 +163,7 @@ func (activity *Activity) GenerateResponse(host *url.URL, responseType string) A
 		&activity,
 		nil,
 		nil,
+		nil,
 	}
 }
 
@@ -128,13 +176,17 @@ func (activity *Activity) GenerateAnnounce(host *url.URL) Activity {
 		"Announce",
 		activity.ID,
 		[]string{host.String() + "/actor/followers"},
+		[]string{"https://www.w3.org/ns/activitystreams#Public"},
 		nil,
 	}
 }
 
 // NestedActivity : Unwrap nested activity.
 func (activity *Activity) NestedActivity() (*Activity, error) {
-	mappedObject := activity.Object.(map[string]interface{})
+	mappedObject, ok := activity.Object.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Can't assart object")
+	}
 	if id, ok := mappedObject["id"].(string); ok {
 		if nestedType, ok := mappedObject["type"].(string); ok {
 			actor, ok := mappedObject["actor"].(string)
@@ -173,6 +225,60 @@ type ActivityObject struct {
 	Cc      []string `json:"cc,omitempty"`
 }
 
+// OrderedCollection : ActivityPub OrderedCollection.
+type OrderedCollection struct {
+	Context    interface{} `json:"@context,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	TotalItems int         `json:"totalItems"`
+	First      string      `json:"first,omitempty"`
+}
+
+// GenerateFromItems : Generate OrderedCollection which points first page.
+func (collection *OrderedCollection) GenerateFromItems(id string, items []string) {
+	collection.Context = "https://www.w3.org/ns/activitystreams"
+	collection.ID = id
+	collection.Type = "OrderedCollection"
+	collection.TotalItems = len(items)
+	collection.First = id + "?page=1"
+}
+
+// OrderedCollectionPage : ActivityPub OrderedCollectionPage.
+type OrderedCollectionPage struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id,omitempty"`
+	Type         string      `json:"type,omitempty"`
+	TotalItems   int         `json:"totalItems"`
+	PartOf       string      `json:"partOf,omitempty"`
+	Prev         string      `json:"prev,omitempty"`
+	Next         string      `json:"next,omitempty"`
+	OrderedItems []string    `json:"orderedItems"`
+}
+
+// GenerateFromItems : Generate OrderedCollectionPage from items. page is 1-origin.
+func (collectionPage *OrderedCollectionPage) GenerateFromItems(id string, items []string, page int, pageSize int) {
+	collectionPage.Context = "https://www.w3.org/ns/activitystreams"
+	collectionPage.ID = id + "?page=" + strconv.Itoa(page)
+	collectionPage.Type = "OrderedCollectionPage"
+	collectionPage.TotalItems = len(items)
+	collectionPage.PartOf = id
+	collectionPage.OrderedItems = []string{}
+
+	start := (page - 1) * pageSize
+	if start < len(items) {
+		end := start + pageSize
+		if end < len(items) {
+			collectionPage.Next = id + "?page=" + strconv.Itoa(page+1)
+		} else {
+			end = len(items)
+		}
+		collectionPage.OrderedItems = items[start:end]
+	}
+	if page > 1 {
+		collectionPage.Prev = id + "?page=" + strconv.Itoa(page-1)
+	}
+}
+
 // Signature : ActivityPub Header Signature.
 type Signature struct {
 	Type           string `json:"type,omitempty"`
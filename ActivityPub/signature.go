@@ -0,0 +1,165 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+const linkedDataSignatureContext = "https://w3id.org/identity/v1"
+
+// preloadedDocumentLoader : JSON-LD document loader which only serves preloaded contexts.
+type preloadedDocumentLoader struct {
+}
+
+// LoadDocument : Load JSON-LD context from preloaded contexts.
+func (loader *preloadedDocumentLoader) LoadDocument(u string) (*ld.RemoteDocument, error) {
+	context, ok := preloadedContexts[strings.TrimSuffix(u, "/")]
+	if !ok {
+		return nil, errors.New("JSON-LD context is not preloaded : " + u)
+	}
+	document, err := ld.DocumentFromReader(strings.NewReader(context))
+	if err != nil {
+		return nil, err
+	}
+	return &ld.RemoteDocument{DocumentURL: u, Document: document}, nil
+}
+
+// CanonicalizeDocument : Canonicalize JSON-LD document by URDNA2015.
+func CanonicalizeDocument(document map[string]interface{}) (string, error) {
+	processor := ld.NewJsonLdProcessor()
+	options := ld.NewJsonLdOptions("")
+	options.Format = "application/n-quads"
+	options.Algorithm = "URDNA2015"
+	options.DocumentLoader = new(preloadedDocumentLoader)
+
+	normalized, err := processor.Normalize(document, options)
+	if err != nil {
+		return "", err
+	}
+	canonical, ok := normalized.(string)
+	if !ok {
+		return "", errors.New("Failed canonicalize document")
+	}
+	return canonical, nil
+}
+
+func hashDocument(document map[string]interface{}) (string, error) {
+	canonical, err := CanonicalizeDocument(document)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(canonical))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// linkedDataSignatureHash : Hash to be signed. (options hash + document hash)
+func linkedDataSignatureHash(document map[string]interface{}, signature map[string]interface{}) ([]byte, error) {
+	options := map[string]interface{}{
+		"@context": linkedDataSignatureContext,
+	}
+	for key, value := range signature {
+		if key != "type" && key != "id" && key != "signatureValue" {
+			options[key] = value
+		}
+	}
+	unsigned := map[string]interface{}{}
+	for key, value := range document {
+		if key != "signature" {
+			unsigned[key] = value
+		}
+	}
+
+	optionsHash, err := hashDocument(options)
+	if err != nil {
+		return nil, err
+	}
+	documentHash, err := hashDocument(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(optionsHash + documentHash))
+	return hash[:], nil
+}
+
+// LinkedDataSignature : Extract Linked Data Signature from JSON document.
+func LinkedDataSignature(body []byte) (*Signature, error) {
+	var signedDocument struct {
+		Signature *Signature `json:"signature,omitempty"`
+	}
+	err := json.Unmarshal(body, &signedDocument)
+	if err != nil {
+		return nil, err
+	}
+	if signedDocument.Signature == nil {
+		return nil, errors.New("Linked Data Signature is not present")
+	}
+	return signedDocument.Signature, nil
+}
+
+// VerifyLinkedDataSignature : Verify RsaSignature2017 Linked Data Signature of JSON document.
+func VerifyLinkedDataSignature(body []byte, publicKey *rsa.PublicKey) error {
+	var document map[string]interface{}
+	err := json.Unmarshal(body, &document)
+	if err != nil {
+		return err
+	}
+	signature, ok := document["signature"].(map[string]interface{})
+	if !ok {
+		return errors.New("Linked Data Signature is not present")
+	}
+	if signature["type"] != "RsaSignature2017" {
+		return errors.New("Linked Data Signature type is not supported")
+	}
+	signatureValue, ok := signature["signatureValue"].(string)
+	if !ok {
+		return errors.New("Linked Data Signature has no signatureValue")
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signatureValue)
+	if err != nil {
+		return err
+	}
+
+	hash, err := linkedDataSignatureHash(document, signature)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash, signatureBytes)
+}
+
+// SignLinkedData : Append RsaSignature2017 Linked Data Signature to JSON document.
+func SignLinkedData(body []byte, creator string, privateKey *rsa.PrivateKey) ([]byte, error) {
+	var document map[string]interface{}
+	err := json.Unmarshal(body, &document)
+	if err != nil {
+		return nil, err
+	}
+	delete(document, "signature")
+	signature := map[string]interface{}{
+		"type":    "RsaSignature2017",
+		"creator": creator,
+		"created": time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+
+	hash, err := linkedDataSignatureHash(document, signature)
+	if err != nil {
+		return nil, err
+	}
+	signatureBytes, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash)
+	if err != nil {
+		return nil, err
+	}
+	signature["signatureValue"] = base64.StdEncoding.EncodeToString(signatureBytes)
+	document["signature"] = signature
+
+	return json.Marshal(document)
+}
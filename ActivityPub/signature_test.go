@@ -0,0 +1,105 @@
+package activitypub
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+)
+
+var linkedDataDocument = `{"@context":["https://www.w3.org/ns/activitystreams","https://w3id.org/security/v1"],"id":"https://example.com/users/alice/statuses/1/activity","type":"Create","actor":"https://example.com/users/alice","to":["https://www.w3.org/ns/activitystreams#Public"],"object":{"id":"https://example.com/users/alice/statuses/1","type":"Note","content":"Activity-Relay"}}`
+
+func TestCanonicalizeDocument(t *testing.T) {
+	var document map[string]interface{}
+	json.Unmarshal([]byte(linkedDataDocument), &document)
+
+	canonical, err := CanonicalizeDocument(document)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	valid := `<https://example.com/users/alice/statuses/1/activity> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <https://www.w3.org/ns/activitystreams#Create> .
+<https://example.com/users/alice/statuses/1/activity> <https://www.w3.org/ns/activitystreams#actor> <https://example.com/users/alice> .
+<https://example.com/users/alice/statuses/1/activity> <https://www.w3.org/ns/activitystreams#object> <https://example.com/users/alice/statuses/1> .
+<https://example.com/users/alice/statuses/1/activity> <https://www.w3.org/ns/activitystreams#to> <https://www.w3.org/ns/activitystreams#Public> .
+<https://example.com/users/alice/statuses/1> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <https://www.w3.org/ns/activitystreams#Note> .
+<https://example.com/users/alice/statuses/1> <https://www.w3.org/ns/activitystreams#content> "Activity-Relay" .
+`
+	if canonical != valid {
+		t.Fatalf("Failed - Canonicalized document is not valid.\n" + canonical)
+	}
+}
+
+func TestCanonicalizeDocumentUnknownContext(t *testing.T) {
+	document := map[string]interface{}{
+		"@context": "https://example.com/context",
+		"id":       "https://example.com/",
+	}
+
+	_, err := CanonicalizeDocument(document)
+	if err == nil {
+		t.Fatalf("Failed - Remote context fetched.")
+	}
+}
+
+func TestSignAndVerifyLinkedData(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	signed, err := SignLinkedData([]byte(linkedDataDocument), "https://example.com/users/alice#main-key", privateKey)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	signature, err := LinkedDataSignature(signed)
+	if err != nil || signature.Type != "RsaSignature2017" || signature.Creator != "https://example.com/users/alice#main-key" {
+		t.Fatalf("Failed - Signature is not appended.")
+	}
+
+	err = VerifyLinkedDataSignature(signed, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+
+	var document map[string]interface{}
+	json.Unmarshal(signed, &document)
+	document["actor"] = "https://example.com/users/mallory"
+	tampered, _ := json.Marshal(document)
+	err = VerifyLinkedDataSignature(tampered, &privateKey.PublicKey)
+	if err == nil {
+		t.Fatalf("Failed - Tampered document verified.")
+	}
+}
+
+// TestVerifyMastodonLinkedDataSignature : Verify activities recorded from Mastodon, signed by its RsaSignature2017 implementation.
+// They are verified only when preloaded contexts and URDNA2015 output match those of Mastodon.
+func TestVerifyMastodonLinkedDataSignature(t *testing.T) {
+	actorData, _ := ioutil.ReadFile("../misc/person.json")
+	var actor Actor
+	json.Unmarshal(actorData, &actor)
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		t.Fatalf("Failed - Public key of recorded actor is not decoded.")
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+
+	for _, name := range []string{"create.json", "announce.json", "undo.json"} {
+		body, _ := ioutil.ReadFile("../misc/" + name)
+		err = VerifyLinkedDataSignature(body, publicKey.(*rsa.PublicKey))
+		if err != nil {
+			t.Fatalf("Failed - Signature of %s is not verified : %s", name, err.Error())
+		}
+	}
+
+	body, _ := ioutil.ReadFile("../misc/create.json")
+	var document map[string]interface{}
+	json.Unmarshal(body, &document)
+	document["object"].(map[string]interface{})["content"] = "<p>Tampered</p>"
+	tampered, _ := json.Marshal(document)
+	if VerifyLinkedDataSignature(tampered, publicKey.(*rsa.PublicKey)) == nil {
+		t.Fatalf("Failed - Tampered activity verified.")
+	}
+}
@@ -0,0 +1,315 @@
+package actorcache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+)
+
+const (
+	actorKeyPrefix        = "relay:actor:"
+	revalidationKeyPrefix = "relay:actor_revalidation:"
+)
+
+const (
+	// Hit : Actor is served from fresh cache entry
+	Hit = "hit"
+	// NegativeHit : Failure of fetching actor is served from cache entry
+	NegativeHit = "negative_hit"
+	// Revalidated : Stale cache entry is confirmed by conditional GET
+	Revalidated = "revalidated"
+	// Miss : Actor is fetched from remote instance
+	Miss = "miss"
+)
+
+// Entry : Cached actor document, or failure of fetching it
+type Entry struct {
+	Data []byte `json:"data,omitempty"`
+	// Status : HTTP status of failure such as 404 or 410. 0 for actor document.
+	Status       int       `json:"status,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// Fresh : Check entry can be served without asking remote instance
+func (entry *Entry) Fresh() bool {
+	return time.Now().Before(entry.Expires)
+}
+
+// Negative : Check entry records failure of fetching actor
+func (entry *Entry) Negative() bool {
+	return entry.Status != 0
+}
+
+// Err : Error of negative entry, formatted like status of response
+func (entry *Entry) Err() error {
+	return errors.New(strconv.Itoa(entry.Status) + " " + http.StatusText(entry.Status))
+}
+
+type lruItem struct {
+	url   string
+	entry *Entry
+}
+
+// Cache : Two-tier actor cache, in-process LRU backed by Redis which is shared by server replicas
+type Cache struct {
+	// PositiveTTL : Lifetime of actor document. Shorter max-age of Cache-Control is honored.
+	PositiveTTL time.Duration
+	// NegativeTTL : Lifetime of 404 and 410 responses
+	NegativeTTL time.Duration
+	// Client : Client fetching actor, which refuses internal addresses by default
+	Client *http.Client
+
+	mutex       sync.Mutex
+	size        int
+	items       map[string]*list.Element
+	order       *list.List
+	redisClient *redis.Client
+
+	// revalidations : Claims of revalidation held in process, used when Redis tier is disabled
+	revalidations map[string]time.Time
+}
+
+// New : Create actor cache holding size entries in process. Redis tier is disabled when redisClient is nil.
+func New(size int, redisClient *redis.Client, positiveTTL time.Duration, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		PositiveTTL: positiveTTL,
+		NegativeTTL: negativeTTL,
+		Client:      httpclient.New(httpclient.DefaultOptions),
+		size:        size,
+		items:       map[string]*list.Element{},
+		order:       list.New(),
+		redisClient: redisClient,
+
+		revalidations: map[string]time.Time{},
+	}
+}
+
+// Get : Select entry from process, then from Redis. Stale entry is returned too for revalidation.
+func (cache *Cache) Get(url string) (*Entry, bool) {
+	entry, found := cache.getLocal(url)
+	if found && entry.Fresh() {
+		return entry, true
+	}
+	shared, sharedFound := cache.getShared(url)
+	if sharedFound && (!found || shared.Expires.After(entry.Expires)) {
+		cache.setLocal(url, shared)
+		return shared, true
+	}
+	return entry, found
+}
+
+// Set : Store entry into process and Redis
+func (cache *Cache) Set(url string, entry *Entry) {
+	cache.setLocal(url, entry)
+	if cache.redisClient == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// Actor document is kept after expiry, so it can be revalidated by conditional GET.
+	retention := time.Until(entry.Expires)
+	if !entry.Negative() {
+		retention += cache.PositiveTTL
+	}
+	if retention < time.Second {
+		retention = time.Second
+	}
+	cache.redisClient.Set(actorKeyPrefix+url, data, retention)
+}
+
+// Store : Cache actor document for PositiveTTL
+func (cache *Cache) Store(url string, data []byte) {
+	cache.Set(url, &Entry{Data: data, Expires: time.Now().Add(cache.PositiveTTL)})
+}
+
+// Expire : Make entry stale, so next fetch revalidates it. It is used when actor key may be rotated.
+func (cache *Cache) Expire(url string) {
+	entry, found := cache.Get(url)
+	if !found {
+		return
+	}
+	expired := *entry
+	expired.Expires = time.Time{}
+	cache.Set(url, &expired)
+}
+
+// ClaimRevalidation : Claim revalidation of entry which may be outdated, such as actor which key may be rotated.
+// Only one claim succeeds within interval across replicas, so senders can not force fetching actor on every request.
+func (cache *Cache) ClaimRevalidation(url string, interval time.Duration) bool {
+	if cache.redisClient != nil {
+		claimed, err := cache.redisClient.SetNX(revalidationKeyPrefix+url, 1, interval).Result()
+		return err == nil && claimed
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := time.Now()
+	if now.Before(cache.revalidations[url]) {
+		return false
+	}
+	if len(cache.revalidations) >= cache.size {
+		for claimedURL, until := range cache.revalidations {
+			if !now.Before(until) {
+				delete(cache.revalidations, claimedURL)
+			}
+		}
+	}
+	cache.revalidations[url] = now.Add(interval)
+	return true
+}
+
+// Delete : Remove entry from process and Redis
+func (cache *Cache) Delete(url string) {
+	cache.mutex.Lock()
+	if element, ok := cache.items[url]; ok {
+		cache.order.Remove(element)
+		delete(cache.items, url)
+	}
+	cache.mutex.Unlock()
+	if cache.redisClient != nil {
+		cache.redisClient.Del(actorKeyPrefix + url)
+	}
+}
+
+// Fetch : Retrieve actor document through cache. Stale document is revalidated by conditional GET,
+// and 404 and 410 responses are cached for NegativeTTL. It returns how document is served.
+func (cache *Cache) Fetch(url string, uaString string, signer func(*http.Request) error) ([]byte, string, error) {
+	entry, found := cache.Get(url)
+	if found && entry.Fresh() {
+		if entry.Negative() {
+			return nil, NegativeHit, entry.Err()
+		}
+		return entry.Data, Hit, nil
+	}
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Accept", "application/activity+json")
+	req.Header.Set("User-Agent", uaString)
+	revalidate := found && !entry.Negative()
+	if revalidate && entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if revalidate && entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+	if signer != nil {
+		err := signer(req)
+		if err != nil {
+			return nil, Miss, err
+		}
+	}
+	resp, err := cache.Client.Do(req)
+	if err != nil {
+		return nil, Miss, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && revalidate:
+		ttl, store := freshness(resp.Header, cache.PositiveTTL)
+		revalidated := *entry
+		revalidated.Expires = time.Now().Add(ttl)
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			revalidated.ETag = etag
+		}
+		if store {
+			cache.Set(url, &revalidated)
+		}
+		return revalidated.Data, Revalidated, nil
+	case resp.StatusCode == http.StatusOK:
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, Miss, err
+		}
+		ttl, store := freshness(resp.Header, cache.PositiveTTL)
+		if store {
+			cache.Set(url, &Entry{
+				Data:         data,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+				Expires:      time.Now().Add(ttl),
+			})
+		}
+		return data, Miss, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		negative := &Entry{Status: resp.StatusCode, Expires: time.Now().Add(cache.NegativeTTL)}
+		cache.Set(url, negative)
+		return nil, Miss, negative.Err()
+	default:
+		return nil, Miss, errors.New(resp.Status)
+	}
+}
+
+// freshness : Lifetime of response by Cache-Control, capped by ttl. Returns false when response must not be stored.
+func freshness(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			return 0, false
+		case directive == "no-cache":
+			ttl = 0
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && time.Duration(seconds)*time.Second < ttl {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return ttl, true
+}
+
+func (cache *Cache) getLocal(url string) (*Entry, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.items[url]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruItem).entry, true
+}
+
+func (cache *Cache) setLocal(url string, entry *Entry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[url]; ok {
+		element.Value.(*lruItem).entry = entry
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.items[url] = cache.order.PushFront(&lruItem{url: url, entry: entry})
+	for cache.size > 0 && cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.items, oldest.Value.(*lruItem).url)
+	}
+}
+
+func (cache *Cache) getShared(url string) (*Entry, bool) {
+	if cache.redisClient == nil {
+		return nil, false
+	}
+	data, err := cache.redisClient.Get(actorKeyPrefix + url).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	var entry Entry
+	if json.Unmarshal(data, &entry) != nil {
+		return nil, false
+	}
+	return &entry, true
+}
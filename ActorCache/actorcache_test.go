@@ -0,0 +1,238 @@
+package actorcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/spf13/viper"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+)
+
+var redisClient *redis.Client
+
+func TestMain(m *testing.M) {
+	viper.SetConfigName("config")
+	viper.AddConfigPath(".")
+	err := viper.ReadInConfig()
+	if err != nil {
+		fmt.Println("Config file is not exists. Use environment variables.")
+		viper.BindEnv("redis_url")
+	}
+	redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
+	if err != nil {
+		panic(err)
+	}
+	redisClient = redis.NewClient(redisOption)
+
+	code := m.Run()
+	redisClient.FlushAll().Result()
+	os.Exit(code)
+}
+
+// newTestCache : Cache which reaches test servers on loopback
+func newTestCache(redisClient *redis.Client) *Cache {
+	cache := New(10, redisClient, time.Hour, time.Hour)
+	options := httpclient.DefaultOptions
+	options.AllowedNetworks, _ = httpclient.ParseNetworks([]string{"127.0.0.0/8"})
+	cache.Client = httpclient.New(options)
+	return cache
+}
+
+// mockActorServer : Remote instance serving actor with ETag, counting requests by status
+func mockActorServer(status int, cacheControl string) (*httptest.Server, map[int]int) {
+	served := map[int]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if status != http.StatusOK {
+			served[status]++
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			served[http.StatusNotModified]++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		served[http.StatusOK]++
+		w.Write([]byte(`{"id":"` + r.URL.String() + `"}`))
+	}))
+	return server, served
+}
+
+func TestFetchHit(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusOK, "")
+	defer server.Close()
+	cache := newTestCache(nil)
+
+	_, result, err := cache.Fetch(server.URL+"/actor", "", nil)
+	if err != nil || result != Miss {
+		t.Fatalf("Failed - First fetch is not miss")
+	}
+	data, result, err := cache.Fetch(server.URL+"/actor", "", nil)
+	if err != nil || result != Hit || len(data) == 0 {
+		t.Fatalf("Failed - Second fetch is not served from cache")
+	}
+	if served[http.StatusOK] != 1 {
+		t.Fatalf("Failed - Actor is fetched %d times", served[http.StatusOK])
+	}
+}
+
+func TestFetchRevalidate(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusOK, "max-age=0")
+	defer server.Close()
+	cache := newTestCache(nil)
+
+	cache.Fetch(server.URL+"/actor", "", nil)
+	data, result, err := cache.Fetch(server.URL+"/actor", "", nil)
+	if err != nil || result != Revalidated || len(data) == 0 {
+		t.Fatalf("Failed - Stale actor is not revalidated by ETag")
+	}
+	if served[http.StatusOK] != 1 || served[http.StatusNotModified] != 1 {
+		t.Fatalf("Failed - Conditional GET is not sent")
+	}
+}
+
+func TestFetchExpire(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusOK, "")
+	defer server.Close()
+	cache := newTestCache(nil)
+
+	cache.Fetch(server.URL+"/actor", "", nil)
+	cache.Expire(server.URL + "/actor")
+	_, result, _ := cache.Fetch(server.URL+"/actor", "", nil)
+	if result != Revalidated || served[http.StatusNotModified] != 1 {
+		t.Fatalf("Failed - Expired actor is not revalidated")
+	}
+}
+
+func TestFetchNoStore(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusOK, "private, no-store")
+	defer server.Close()
+	cache := newTestCache(nil)
+
+	cache.Fetch(server.URL+"/actor", "", nil)
+	_, result, _ := cache.Fetch(server.URL+"/actor", "", nil)
+	if result != Miss || served[http.StatusOK] != 2 {
+		t.Fatalf("Failed - Actor served with no-store is cached")
+	}
+}
+
+func TestFetchNegative(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusGone, "")
+	defer server.Close()
+	cache := newTestCache(nil)
+
+	_, result, err := cache.Fetch(server.URL+"/actor", "", nil)
+	if err == nil || result != Miss {
+		t.Fatalf("Failed - Gone actor is fetched")
+	}
+	_, result, err = cache.Fetch(server.URL+"/actor", "", nil)
+	if err == nil || err.Error() != "410 Gone" || result != NegativeHit {
+		t.Fatalf("Failed - Gone actor is not negatively cached")
+	}
+	if served[http.StatusGone] != 1 {
+		t.Fatalf("Failed - Gone actor is fetched %d times", served[http.StatusGone])
+	}
+
+	failing, failed := mockActorServer(http.StatusInternalServerError, "")
+	defer failing.Close()
+	cache.Fetch(failing.URL+"/actor", "", nil)
+	cache.Fetch(failing.URL+"/actor", "", nil)
+	if failed[http.StatusInternalServerError] != 2 {
+		t.Fatalf("Failed - Server error is cached")
+	}
+}
+
+func TestFetchInternalAddress(t *testing.T) {
+	server, served := mockActorServer(http.StatusOK, "")
+	defer server.Close()
+	cache := New(10, nil, time.Hour, time.Hour)
+
+	_, _, err := cache.Fetch(server.URL+"/actor", "", nil)
+	if err == nil || served[http.StatusOK] != 0 {
+		t.Fatalf("Failed - Actor is fetched from loopback address")
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	redisClient.FlushAll().Result()
+	server, served := mockActorServer(http.StatusOK, "")
+	defer server.Close()
+	replica := newTestCache(redisClient)
+	otherReplica := newTestCache(redisClient)
+
+	replica.Fetch(server.URL+"/actor", "", nil)
+	_, result, err := otherReplica.Fetch(server.URL+"/actor", "", nil)
+	if err != nil || result != Hit || served[http.StatusOK] != 1 {
+		t.Fatalf("Failed - Actor is not shared through Redis")
+	}
+
+	otherReplica.Delete(server.URL + "/actor")
+	if _, found := newTestCache(redisClient).Get(server.URL + "/actor"); found {
+		t.Fatalf("Failed - Deleted actor remains in Redis")
+	}
+	redisClient.FlushAll().Result()
+}
+
+func TestClaimRevalidation(t *testing.T) {
+	redisClient.FlushAll().Result()
+	for _, cache := range []*Cache{New(10, nil, time.Hour, time.Hour), newTestCache(redisClient)} {
+		if !cache.ClaimRevalidation("https://example.com/users/alice#main-key", time.Hour) {
+			t.Fatalf("Failed - Revalidation is not claimed")
+		}
+		if cache.ClaimRevalidation("https://example.com/users/alice#main-key", time.Hour) {
+			t.Fatalf("Failed - Revalidation is claimed twice within interval")
+		}
+		if !cache.ClaimRevalidation("https://example.com/users/bob#main-key", time.Hour) {
+			t.Fatalf("Failed - Revalidation of other actor is not claimed")
+		}
+	}
+	if newTestCache(redisClient).ClaimRevalidation("https://example.com/users/alice#main-key", time.Hour) {
+		t.Fatalf("Failed - Revalidation is not shared through Redis")
+	}
+	redisClient.FlushAll().Result()
+}
+
+func TestLRUEviction(t *testing.T) {
+	cache := New(2, nil, time.Hour, time.Hour)
+	cache.Store("https://example.com/users/alice", []byte("alice"))
+	cache.Store("https://example.com/users/bob", []byte("bob"))
+	cache.Get("https://example.com/users/alice")
+	cache.Store("https://example.com/users/carol", []byte("carol"))
+
+	if _, found := cache.Get("https://example.com/users/bob"); found {
+		t.Fatalf("Failed - Least recently used actor is not evicted")
+	}
+	if _, found := cache.Get("https://example.com/users/alice"); !found {
+		t.Fatalf("Failed - Recently used actor is evicted")
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "public, max-age=60")
+	if ttl, store := freshness(header, time.Hour); !store || ttl != time.Minute {
+		t.Fatalf("Failed - max-age is not honored")
+	}
+	header.Set("Cache-Control", "max-age=86400")
+	if ttl, _ := freshness(header, time.Hour); ttl != time.Hour {
+		t.Fatalf("Failed - max-age exceeds configured ttl")
+	}
+	header.Set("Cache-Control", "no-cache")
+	if ttl, store := freshness(header, time.Hour); !store || ttl != 0 {
+		t.Fatalf("Failed - no-cache is not honored")
+	}
+}
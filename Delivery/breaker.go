@@ -0,0 +1,128 @@
+package delivery
+
+import (
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// inFlightRetryDelay : Base delay of job deferred by per-host in-flight cap.
+const inFlightRetryDelay = 2 * time.Second
+
+type hostCircuit struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	inFlight int
+}
+
+// hostGate : Per-host circuit breaker and in-flight limiter.
+type hostGate struct {
+	mutex            sync.Mutex
+	hosts            map[string]*hostCircuit
+	maxInFlight      int
+	failureThreshold int
+	openDuration     time.Duration
+}
+
+func newHostGate(maxInFlight int, failureThreshold int, openDuration time.Duration) *hostGate {
+	return &hostGate{
+		hosts:            map[string]*hostCircuit{},
+		maxInFlight:      maxInFlight,
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+	}
+}
+
+func (gate *hostGate) circuit(host string) *hostCircuit {
+	circuit, ok := gate.hosts[host]
+	if !ok {
+		circuit = &hostCircuit{}
+		gate.hosts[host] = circuit
+	}
+	return circuit
+}
+
+// acquire : Reserve delivery slot for host. Returns false with delay when delivery should be deferred.
+func (gate *hostGate) acquire(host string) (bool, time.Duration) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+
+	circuit := gate.circuit(host)
+	switch circuit.state {
+	case breakerOpen:
+		remaining := circuit.openedAt.Add(gate.openDuration).Sub(time.Now())
+		if remaining > 0 {
+			return false, remaining + jitter(gate.openDuration/4)
+		}
+		circuit.state = breakerHalfOpen
+		circuit.inFlight++
+		return true, 0
+	case breakerHalfOpen:
+		// Only one probe is allowed while half-open.
+		return false, gate.openDuration/2 + jitter(gate.openDuration/4)
+	}
+	if gate.maxInFlight > 0 && circuit.inFlight >= gate.maxInFlight {
+		return false, inFlightRetryDelay + jitter(inFlightRetryDelay)
+	}
+	circuit.inFlight++
+	return true, 0
+}
+
+// release : Return delivery slot for host and record delivery result.
+func (gate *hostGate) release(host string, succeeded bool) {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+
+	circuit := gate.circuit(host)
+	if circuit.inFlight > 0 {
+		circuit.inFlight--
+	}
+	if succeeded {
+		circuit.state = breakerClosed
+		circuit.failures = 0
+		return
+	}
+	circuit.failures++
+	if circuit.state == breakerHalfOpen || circuit.failures >= gate.failureThreshold {
+		if circuit.state != breakerOpen {
+			logger.WithField(logger.Domain, host).Warn("Circuit breaker opened")
+		}
+		circuit.state = breakerOpen
+		circuit.openedAt = time.Now()
+	}
+}
+
+// state : Current breaker state of host.
+func (gate *hostGate) state(host string) breakerState {
+	gate.mutex.Lock()
+	defer gate.mutex.Unlock()
+
+	return gate.circuit(host).state
+}
+
+func inboxHost(inboxURL string) string {
+	parsedURL, err := url.Parse(inboxURL)
+	if err != nil {
+		return inboxURL
+	}
+	return parsedURL.Host
+}
+
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
@@ -0,0 +1,147 @@
+package delivery
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+	"github.com/spf13/viper"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+var (
+	version string
+
+	redisClient  *redis.Client
+	httpClient   *http.Client
+	deliveryGate *hostGate
+)
+
+// configKeys : Config keys read by delivery
+var configKeys = []string{
+	"job_retry_max",
+	"job_retry_window",
+	"http_timeout",
+	"http_max_body_size",
+	"http_max_redirects",
+	"http_allowed_networks",
+	"job_concurrency",
+	"host_max_inflight",
+	"breaker_failure_threshold",
+	"breaker_open_duration",
+	"subscriber_unreachable_failures",
+	"subscriber_unreachable_after",
+	"subscriber_drop_after",
+}
+
+// SetDefaults : Set default values of delivery configs
+func SetDefaults() {
+	viper.SetDefault("job_retry_max", 8)
+	viper.SetDefault("job_retry_window", "6h")
+	viper.SetDefault("http_timeout", "5s")
+	viper.SetDefault("http_max_body_size", 1048576)
+	viper.SetDefault("http_max_redirects", 3)
+	viper.SetDefault("job_concurrency", 200)
+	viper.SetDefault("host_max_inflight", 10)
+	viper.SetDefault("breaker_failure_threshold", 5)
+	viper.SetDefault("breaker_open_duration", "1m")
+	viper.SetDefault("subscriber_unreachable_failures", 10)
+	viper.SetDefault("subscriber_unreachable_after", "24h")
+	viper.SetDefault("subscriber_drop_after", "168h")
+}
+
+// BindEnv : Read delivery configs from environment variables
+func BindEnv() {
+	for _, key := range configKeys {
+		viper.BindEnv(key)
+	}
+}
+
+// Setup : Load tenants and HTTP client used by delivery. relayVersion is sent in User-Agent.
+// Relay state is opened by storage_backend. BoltDB file opened by server in same process is shared.
+func Setup(relayVersion string, client *redis.Client, tenantConfigs []state.Tenant) error {
+	version = relayVersion
+	redisClient = client
+	tenants = map[string]*Tenant{}
+	for i, config := range tenantConfigs {
+		tenant, err := newTenant(config, redisClient)
+		if err != nil {
+			return err
+		}
+		tenant.relayState.ListenNotify(nil)
+		tenants[config.Domain] = tenant
+		if i == 0 {
+			defaultTenant = tenant
+		}
+	}
+	var err error
+	httpClient, err = httpclient.FromConfig()
+	if err != nil {
+		return err
+	}
+	deliveryGate = newHostGate(viper.GetInt("host_max_inflight"), viper.GetInt("breaker_failure_threshold"), viper.GetDuration("breaker_open_duration"))
+	rand.Seed(time.Now().UnixNano())
+	return nil
+}
+
+// RegisterTasks : Register delivery tasks into machinery server
+func RegisterTasks(machineryServer *machinery.Server) error {
+	err := machineryServer.RegisterTask("registor", registorActivity)
+	if err != nil {
+		return err
+	}
+	return machineryServer.RegisterTask("relay", relayActivityTask)
+}
+
+// Launch : Process jobs by job_concurrency workers, and drop unreachable subscribers periodically. It blocks until worker stops.
+func Launch(machineryServer *machinery.Server) error {
+	go watchSubscriptions()
+
+	workerID := uuid.NewV4()
+	worker := machineryServer.NewWorker(workerID.String(), viper.GetInt("job_concurrency"))
+	return worker.Launch()
+}
+
+func (tenant *Tenant) relayActivity(args ...string) error {
+	inboxURL := args[0]
+	body := args[1]
+	if tenant.isUnreachableInbox(inboxURL) {
+		return nil
+	}
+	return tenant.deliverActivity(inboxURL, body)
+}
+
+// deliverActivity : Deliver activity and record delivery health, even if subscriber is unreachable.
+func (tenant *Tenant) deliverActivity(inboxURL string, body string) error {
+	keyID, privateKey := tenant.activitySigner([]byte(body))
+	err := sendActivity(inboxURL, keyID, []byte(body), privateKey)
+	tenant.recordDelivery(inboxURL, err)
+	if err != nil {
+		domain, _ := url.Parse(inboxURL)
+		mod, _ := redisClient.HSetNX("relay:statistics:"+domain.Host, "last_error", err.Error()).Result()
+		if mod {
+			redisClient.Expire("relay:statistics:"+domain.Host, time.Duration(time.Minute))
+		}
+	}
+	return err
+}
+
+func registorActivity(ctx context.Context, args ...string) error {
+	inboxURL := args[0]
+	body := args[1]
+	tenant := jobTenant(ctx)
+	if tenant == nil {
+		logger.WithField(logger.Inbox, inboxURL).Warn("Skipping job of unknown tenant")
+		return nil
+	}
+	keyID, privateKey := tenant.activitySigner([]byte(body))
+	err := sendActivity(inboxURL, keyID, []byte(body), privateKey)
+	return err
+}
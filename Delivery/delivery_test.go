@@ -0,0 +1,629 @@
+package delivery
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+	httpsignature "github.com/yukimochi/Activity-Relay/HTTPSignature"
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestMain(m *testing.M) {
+	viper.BindEnv("redis_url")
+	// Remote instances are stood in by test servers on loopback.
+	viper.Set("http_allowed_networks", []string{"127.0.0.0/8"})
+	SetDefaults()
+	redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
+	if err != nil {
+		panic(err)
+	}
+	tenantConfigs, err := state.NormalizeTenants(state.Tenant{
+		Domain:   "relay.yukimochi.example.org",
+		ActorPem: "../misc/testKey.pem",
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	err = Setup("", redis.NewClient(redisOption), tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
+	redisClient.FlushAll().Result()
+
+	// Load Config
+	code := m.Run()
+	os.Exit(code)
+	redisClient.FlushAll().Result()
+}
+
+func TestRelayActivity(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if string(data) != "data" || r.Header.Get("Content-Type") != "application/activity+json" {
+			w.WriteHeader(500)
+			w.Write(nil)
+		} else {
+			w.WriteHeader(202)
+			w.Write(nil)
+		}
+	}))
+	defer s.Close()
+
+	err := defaultTenant.relayActivity(s.URL, "data")
+	if err != nil {
+		t.Fatal("Failed - Data transfar not collect")
+	}
+}
+
+func TestRelayActivityNoHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+	}))
+	defer s.Close()
+
+	err := defaultTenant.relayActivity("http://nohost.example.jp", "data")
+	if err == nil {
+		t.Fatal("Failed - Error not reported.")
+	}
+	domain, _ := url.Parse("http://nohost.example.jp")
+	data, err := redisClient.HGet("relay:statistics:"+domain.Host, "last_error").Result()
+	if data == "" {
+		t.Fatal("Failed - Error not cached.")
+	}
+}
+
+func TestRelayActivityResp500(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	err := defaultTenant.relayActivity(s.URL, "data")
+	if err == nil {
+		t.Fatal("Failed - Error not reported.")
+	}
+	domain, _ := url.Parse(s.URL)
+	data, err := redisClient.HGet("relay:statistics:"+domain.Host, "last_error").Result()
+	if data == "" {
+		t.Fatal("Failed - Error not cached.")
+	}
+}
+
+func TestRegistorActivity(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if string(data) != "data" || r.Header.Get("Content-Type") != "application/activity+json" {
+			w.WriteHeader(500)
+			w.Write(nil)
+		} else {
+			w.WriteHeader(202)
+			w.Write(nil)
+		}
+	}))
+	defer s.Close()
+
+	err := registorActivity(context.Background(), s.URL, "data")
+	if err != nil {
+		t.Fatal("Failed - Data transfar not collect")
+	}
+}
+
+func TestRegistorActivityNoHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+	}))
+	defer s.Close()
+
+	err := registorActivity(context.Background(), "http://nohost.example.jp", "data")
+	if err == nil {
+		t.Fatal("Failed - Error not reported.")
+	}
+}
+
+func TestRegistorActivityResp500(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		w.Write(nil)
+	}))
+	defer s.Close()
+
+	err := registorActivity(context.Background(), s.URL, "data")
+	if err == nil {
+		t.Fatal("Failed - Error not reported.")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts := 1; attempts < 40; attempts++ {
+		delay := retryDelay(attempts)
+		if delay <= 0 || delay > retryMaxDelay {
+			t.Fatalf("Failed - Retry delay is out of range.")
+		}
+	}
+	if retryDelay(1) > retryBaseDelay {
+		t.Fatalf("Failed - First retry delay is too long.")
+	}
+}
+
+func TestRetryRelayJob(t *testing.T) {
+	signature := &tasks.Signature{UUID: "task_retry"}
+
+	err := retryRelayJob(signature, "https://example.com/inbox", "data", errors.New("503"))
+	if _, ok := err.(tasks.ErrRetryTaskLater); !ok {
+		t.Fatalf("Failed - Job not rescheduled.")
+	}
+	if headerInt64(signature.Headers, "relay_attempts") != 1 {
+		t.Fatalf("Failed - Attempts not counted.")
+	}
+
+	signature.Headers["relay_attempts"] = float64(viper.GetInt("job_retry_max"))
+	err = retryRelayJob(signature, "https://example.com/inbox", "data", errors.New("503"))
+	if _, ok := err.(tasks.ErrRetryTaskLater); ok {
+		t.Fatalf("Failed - Job retried over max attempts.")
+	}
+	deadLetter, err := state.SelectDeadLetter(redisClient, "task_retry")
+	if err != nil || deadLetter.Body != "data" || deadLetter.Reason != state.RetryExhaustedReason {
+		t.Fatalf("Failed - Job not moved to dead-letter queue.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestRetryRelayJobWindow(t *testing.T) {
+	signature := &tasks.Signature{UUID: "task_window", Headers: tasks.Headers{
+		"relay_first_attempt": float64(time.Now().Add(-viper.GetDuration("job_retry_window")).Unix()),
+	}}
+
+	err := retryRelayJob(signature, "https://example.com/inbox", "data", errors.New("503"))
+	if _, ok := err.(tasks.ErrRetryTaskLater); ok {
+		t.Fatalf("Failed - Job retried over retry window.")
+	}
+	_, err = state.SelectDeadLetter(redisClient, "task_window")
+	if err != nil {
+		t.Fatalf("Failed - Job not moved to dead-letter queue.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestRecordDelivery(t *testing.T) {
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	defaultTenant.relayState.Load()
+
+	defaultTenant.recordDelivery("https://example.com/inbox", errors.New("503"))
+	if defaultTenant.relayState.SelectHealth("example.com").Failures != 1 {
+		t.Fatalf("Failed - Delivery failure not recorded.")
+	}
+	defaultTenant.recordDelivery("https://example.com/inbox", nil)
+	health := defaultTenant.relayState.SelectHealth("example.com")
+	if health.Failures != 0 || health.LastSuccess.IsZero() {
+		t.Fatalf("Failed - Delivery success not recorded.")
+	}
+
+	redisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestSweepSubscriptions(t *testing.T) {
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "example.org",
+		InboxURL: "https://example.org/inbox",
+	})
+	defaultTenant.relayState.Load()
+	defaultTenant.relayState.RecordDeliveryFailure("example.com", 1, 0)
+	defaultTenant.relayState.RecordDeliveryFailure("example.org", 2, 0)
+	defaultTenant.relayState.Load()
+
+	if !defaultTenant.isUnreachableInbox("https://example.com/inbox") || defaultTenant.isUnreachableInbox("https://example.org/inbox") {
+		t.Fatalf("Failed - Unreachable subscriber not detected.")
+	}
+
+	viper.Set("subscriber_drop_after", 0)
+	defaultTenant.sweepSubscriptions()
+	defaultTenant.relayState.Load()
+
+	if defaultTenant.relayState.SelectSubscription("example.com") != nil {
+		t.Fatalf("Failed - Unreachable subscriber not dropped.")
+	}
+	if defaultTenant.relayState.SelectSubscription("example.org") == nil {
+		t.Fatalf("Failed - Degraded subscriber dropped.")
+	}
+	droppedSubscriptions, _ := defaultTenant.relayState.ListDroppedSubscriptions()
+	if len(droppedSubscriptions) != 1 || droppedSubscriptions[0].Subscription.Domain != "example.com" {
+		t.Fatalf("Failed - Audit record not kept.")
+	}
+
+	viper.Set("subscriber_drop_after", "168h")
+	redisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHostGateInFlight(t *testing.T) {
+	gate := newHostGate(2, 5, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if acquired, _ := gate.acquire("example.com"); !acquired {
+			t.Fatalf("Failed - Slot not acquired under cap.")
+		}
+	}
+	acquired, delay := gate.acquire("example.com")
+	if acquired || delay <= 0 {
+		t.Fatalf("Failed - In-flight cap not enforced.")
+	}
+	if acquired, _ := gate.acquire("example.org"); !acquired {
+		t.Fatalf("Failed - Other host throttled.")
+	}
+	gate.release("example.com", true)
+	if acquired, _ := gate.acquire("example.com"); !acquired {
+		t.Fatalf("Failed - Slot not released.")
+	}
+}
+
+func TestHostGateBreaker(t *testing.T) {
+	gate := newHostGate(0, 2, 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		gate.acquire("example.com")
+		gate.release("example.com", false)
+	}
+	if gate.state("example.com") != breakerOpen {
+		t.Fatalf("Failed - Breaker not opened.")
+	}
+	acquired, delay := gate.acquire("example.com")
+	if acquired || delay <= 0 {
+		t.Fatalf("Failed - Open breaker allows delivery.")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if acquired, _ := gate.acquire("example.com"); !acquired {
+		t.Fatalf("Failed - Half-open breaker not probe.")
+	}
+	if gate.state("example.com") != breakerHalfOpen {
+		t.Fatalf("Failed - Breaker not half-opened.")
+	}
+	if acquired, _ := gate.acquire("example.com"); acquired {
+		t.Fatalf("Failed - Half-open breaker allows multiple probe.")
+	}
+	gate.release("example.com", false)
+	if gate.state("example.com") != breakerOpen {
+		t.Fatalf("Failed - Breaker not reopened by failed probe.")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	gate.acquire("example.com")
+	gate.release("example.com", true)
+	if gate.state("example.com") != breakerClosed {
+		t.Fatalf("Failed - Breaker not closed by succeeded probe.")
+	}
+}
+
+func TestRelayActivityTaskDeferred(t *testing.T) {
+	deliveryGate = newHostGate(1, 5, time.Minute)
+	deliveryGate.acquire("example.com")
+
+	signature := &tasks.Signature{UUID: "task_deferred"}
+	task, _ := tasks.NewWithSignature(relayActivityTask, signature)
+	err := relayActivityTask(task.Context, "https://example.com/inbox", "data")
+	if _, ok := err.(tasks.ErrRetryTaskLater); !ok {
+		t.Fatalf("Failed - Deferred job not requeued.")
+	}
+	if headerInt64(signature.Headers, "relay_first_attempt") == 0 || headerInt64(signature.Headers, "relay_attempts") != 0 {
+		t.Fatalf("Failed - Deferral not recorded apart from attempts.")
+	}
+
+	signature.Headers["relay_first_attempt"] = float64(time.Now().Add(-viper.GetDuration("job_retry_window")).Unix())
+	err = relayActivityTask(task.Context, "https://example.com/inbox", "data")
+	if _, ok := err.(tasks.ErrRetryTaskLater); ok {
+		t.Fatalf("Failed - Job deferred over retry window.")
+	}
+	deadLetter, err := state.SelectDeadLetter(redisClient, "task_deferred")
+	if err != nil || deadLetter.Reason != state.RetryExhaustedReason {
+		t.Fatalf("Failed - Job deferred over retry window not moved to dead-letter queue.")
+	}
+
+	redisClient.FlushAll().Result()
+	deliveryGate = newHostGate(viper.GetInt("host_max_inflight"), viper.GetInt("breaker_failure_threshold"), viper.GetDuration("breaker_open_duration"))
+}
+
+func TestRelayActivityTaskPayload(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if string(data) != "data" {
+			w.WriteHeader(500)
+			w.Write(nil)
+		} else {
+			w.WriteHeader(202)
+			w.Write(nil)
+		}
+	}))
+	defer s.Close()
+
+	payloadKey, _ := state.StorePayload(redisClient, []byte("data"), time.Minute)
+	err := relayActivityTask(context.Background(), s.URL, payloadKey)
+	if err != nil {
+		t.Fatal("Failed - Payload not delivered")
+	}
+
+	redisClient.Del(payloadKey)
+	task, _ := tasks.NewWithSignature(relayActivityTask, &tasks.Signature{UUID: "task_expired"})
+	err = relayActivityTask(task.Context, s.URL, payloadKey)
+	if err == nil {
+		t.Fatal("Failed - Expired payload delivered")
+	}
+	if _, ok := err.(tasks.ErrRetryTaskLater); ok {
+		t.Fatal("Failed - Job of expired payload is retried")
+	}
+	deadLetter, err := state.SelectDeadLetter(redisClient, "task_expired")
+	if err != nil || deadLetter.Reason != state.PayloadExpiredReason || deadLetter.InboxURL != s.URL {
+		t.Fatal("Failed - Job of expired payload is not moved to dead-letter queue")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestSendActivityMetrics(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+		w.Write(nil)
+	}))
+	defer s.Close()
+	host, _ := url.Parse(s.URL)
+
+	before := testutil.ToFloat64(metrics.Deliveries.WithLabelValues(host.Host, "503"))
+	sendActivity(s.URL, defaultTenant.Actor.PublicKey.ID, []byte("data"), defaultTenant.hostPrivatekey)
+	if testutil.ToFloat64(metrics.Deliveries.WithLabelValues(host.Host, "503")) != before+1 {
+		t.Fatalf("Failed - Delivery not counted.")
+	}
+}
+
+func TestSendActivitySignatureNegotiation(t *testing.T) {
+	var schemes []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Signature-Input") == "" {
+			schemes = append(schemes, "draft-cavage")
+			w.WriteHeader(401)
+			return
+		}
+		schemes = append(schemes, "rfc9421")
+		w.WriteHeader(202)
+	}))
+	defer s.Close()
+
+	err := sendActivity(s.URL, defaultTenant.Actor.PublicKey.ID, []byte("data"), defaultTenant.hostPrivatekey)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if strings.Join(schemes, ",") != "draft-cavage,rfc9421" {
+		t.Fatalf("Failed - Schemes are not negotiated : " + strings.Join(schemes, ","))
+	}
+	if state.SelectSignatureScheme(redisClient, s.URL) != "rfc9421" {
+		t.Fatalf("Failed - Accepted scheme is not remembered.")
+	}
+
+	schemes = nil
+	sendActivity(s.URL, defaultTenant.Actor.PublicKey.ID, []byte("data"), defaultTenant.hostPrivatekey)
+	if strings.Join(schemes, ",") != "rfc9421" {
+		t.Fatalf("Failed - Remembered scheme is not tried first : " + strings.Join(schemes, ","))
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestSendActivityForbiddenAddress(t *testing.T) {
+	err := sendActivity("http://169.254.169.254/inbox", defaultTenant.Actor.PublicKey.ID, []byte("data"), defaultTenant.hostPrivatekey)
+	if !errors.Is(err, httpclient.ErrForbiddenAddress) {
+		t.Fatalf("Failed - Deliver activity to link-local address")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestSendActivitySignatureRejected(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(401)
+	}))
+	defer s.Close()
+
+	err := sendActivity(s.URL, defaultTenant.Actor.PublicKey.ID, []byte("data"), defaultTenant.hostPrivatekey)
+	if err == nil {
+		t.Fatalf("Failed - Rejected delivery succeeded.")
+	}
+	if requests != 3 {
+		t.Fatalf("Failed - Not all schemes are tried.")
+	}
+	if state.SelectSignatureScheme(redisClient, s.URL) != "" {
+		t.Fatalf("Failed - Rejected scheme is remembered.")
+	}
+}
+
+func TestActivitySigner(t *testing.T) {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	defaultTenant.relayState.Backend.PutTopic(state.Topic{Name: "tags/art", PrivateKey: keyloader.GeneratePrivateKeyPEMString(privateKey)})
+	defaultTenant.relayState.Load()
+
+	keyID, key := defaultTenant.activitySigner([]byte(`{"type":"Announce","actor":"https://relay.yukimochi.example.org/tags/art/actor"}`))
+	if keyID != "https://relay.yukimochi.example.org/tags/art/actor#main-key" || key.N.Cmp(privateKey.N) != 0 {
+		t.Fatalf("Failed - Topic activity is not signed by topic key.")
+	}
+	keyID, key = defaultTenant.activitySigner([]byte(`{"type":"Create","actor":"https://mastodon.example.com/users/example"}`))
+	if keyID != defaultTenant.Actor.PublicKey.ID || key != defaultTenant.hostPrivatekey {
+		t.Fatalf("Failed - Relayed activity is not signed by relay key.")
+	}
+	keyID, _ = defaultTenant.activitySigner([]byte(`{"type":"Announce","actor":"https://relay.yukimochi.example.org/tags/unknown/actor"}`))
+	if keyID != defaultTenant.Actor.PublicKey.ID {
+		t.Fatalf("Failed - Unknown topic is signed by topic key.")
+	}
+
+	defaultTenant.relayState.Backend.DelTopic("tags/art")
+	defaultTenant.relayState.Load()
+}
+
+func TestRelayActivitySignedByActorKey(t *testing.T) {
+	var verifyErr error
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		verifier, err := httpsignature.NewVerifier(r)
+		if err == nil && verifier.KeyID() != defaultTenant.Actor.PublicKey.ID {
+			// Receiving relay resolves keyId to relay actor, and rejects key which actor does not publish.
+			err = errors.New("keyId is not published by relay actor : " + verifier.KeyID())
+		}
+		if err == nil {
+			var key crypto.PublicKey
+			key, err = httpsignature.ParsePublicKeyPEM(defaultTenant.Actor.PublicKey.PublicKeyPem)
+			if err == nil {
+				err = verifier.Verify(key)
+			}
+		}
+		if err == nil {
+			err = httpsignature.VerifyDigest(r, body)
+		}
+		verifyErr = err
+		w.WriteHeader(202)
+	}))
+	defer s.Close()
+
+	err := defaultTenant.relayActivity(s.URL, `{"type":"Create","actor":"https://mastodon.example.com/users/example"}`)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if verifyErr != nil {
+		t.Fatalf("Failed - Delivery is not verified by relay actor key : " + verifyErr.Error())
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestRegistorActivityTenant(t *testing.T) {
+	tenant, err := newTenant(state.Tenant{
+		Domain:    "relay2.yukimochi.example.org",
+		ActorPem:  "../misc/testKey.pem",
+		Namespace: state.TenantNamespace("relay2.yukimochi.example.org"),
+	}, redisClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants[tenant.hostURL.Host] = tenant
+	defer delete(tenants, tenant.hostURL.Host)
+
+	var keyID string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyID = r.Header.Get("Signature")
+		w.WriteHeader(202)
+	}))
+	defer s.Close()
+
+	task, _ := tasks.NewWithSignature(registorActivity, &tasks.Signature{Headers: tasks.Headers{state.TenantJobHeader: "relay2.yukimochi.example.org"}})
+	err = registorActivity(task.Context, s.URL, "data")
+	if err != nil || !strings.Contains(keyID, `keyId="https://relay2.yukimochi.example.org/actor#main-key"`) {
+		t.Fatalf("Failed - Tenant job is not signed by tenant actor.")
+	}
+
+	if jobTenant(context.Background()) != defaultTenant {
+		t.Fatalf("Failed - Job without tenant is not delivered by default tenant.")
+	}
+	task, _ = tasks.NewWithSignature(registorActivity, &tasks.Signature{Headers: tasks.Headers{state.TenantJobHeader: "unknown.example.org"}})
+	if jobTenant(task.Context) != nil {
+		t.Fatalf("Failed - Job of unknown tenant is selected.")
+	}
+}
+
+func TestRelayActivityTaskProbe(t *testing.T) {
+	delivered := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered++
+		w.WriteHeader(202)
+		w.Write(nil)
+	}))
+	defer s.Close()
+	host, _ := url.Parse(s.URL)
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   host.Host,
+		InboxURL: s.URL,
+	})
+	defaultTenant.relayState.RecordDeliveryFailure(host.Host, 1, 0)
+	defaultTenant.relayState.Load()
+
+	task, _ := tasks.NewWithSignature(relayActivityTask, &tasks.Signature{UUID: "task_unreachable"})
+	relayActivityTask(task.Context, s.URL, "data")
+	if delivered != 0 {
+		t.Fatalf("Failed - Delivered to unreachable subscriber.")
+	}
+
+	task, _ = tasks.NewWithSignature(relayActivityTask, &tasks.Signature{UUID: "task_probe", Headers: tasks.Headers{state.ProbeJobHeader: true}})
+	err := relayActivityTask(task.Context, s.URL, "data")
+	if err != nil || delivered != 1 {
+		t.Fatalf("Failed - Probe is not delivered to unreachable subscriber.")
+	}
+	if defaultTenant.relayState.SelectHealth(host.Host).Status() != state.HealthyStatus {
+		t.Fatalf("Failed - Subscriber is not marked as reachable by probe.")
+	}
+
+	redisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestNewTenantSharesBolt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("storage_backend", "bolt")
+	defer viper.Set("storage_backend", "redis")
+
+	// Server in same process opens BoltDB file first.
+	backend, err := state.OpenBackend("bolt", redisClient, filepath.Join(dir, "relay.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverState := state.NewStateWithBackend(backend, false)
+	serverState.AddSubscription(state.Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+
+	tenant, err := newTenant(state.Tenant{
+		Domain:      "relay.yukimochi.example.org",
+		ActorPem:    "../misc/testKey.pem",
+		StoragePath: filepath.Join(dir, "relay.db"),
+	}, redisClient)
+	if err != nil {
+		t.Fatalf("Failed - BoltDB file opened by server is not shared : " + err.Error())
+	}
+	if tenant.relayState.SelectSubscriptionByInbox("https://example.com/inbox") == nil {
+		t.Fatalf("Failed - Subscription stored by server is not read.")
+	}
+	tenant.recordDelivery("https://example.com/inbox", errors.New("503"))
+	if serverState.SelectHealth("example.com").Failures != 1 {
+		t.Fatalf("Failed - Delivery health is not shared with server.")
+	}
+}
@@ -0,0 +1,59 @@
+package delivery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+)
+
+const subscriberSweepInterval = 10 * time.Minute
+
+// recordDelivery : Update delivery health of subscriber which owns inbox.
+func (tenant *Tenant) recordDelivery(inboxURL string, err error) {
+	subscription := tenant.relayState.SelectSubscriptionByInbox(inboxURL)
+	if subscription == nil {
+		return
+	}
+	if err == nil {
+		tenant.relayState.RecordDeliverySuccess(subscription.Domain)
+		return
+	}
+	marked := tenant.relayState.RecordDeliveryFailure(subscription.Domain, viper.GetInt("subscriber_unreachable_failures"), viper.GetDuration("subscriber_unreachable_after"))
+	if marked {
+		logger.WithField(logger.Domain, subscription.Domain).Warn("Mark subscriber as unreachable")
+	}
+}
+
+// isUnreachableInbox : Check inbox belongs to subscriber marked as unreachable.
+func (tenant *Tenant) isUnreachableInbox(inboxURL string) bool {
+	subscription := tenant.relayState.SelectSubscriptionByInbox(inboxURL)
+	return subscription != nil && tenant.relayState.IsUnreachable(subscription.Domain)
+}
+
+// sweepSubscriptions : Drop subscriptions which are unreachable over grace period.
+func (tenant *Tenant) sweepSubscriptions() {
+	gracePeriod := viper.GetDuration("subscriber_drop_after")
+	for _, subscription := range tenant.relayState.Subscriptions {
+		health := tenant.relayState.SelectHealth(subscription.Domain)
+		if health.UnreachableSince.IsZero() || time.Since(health.UnreachableSince) < gracePeriod {
+			continue
+		}
+		reason := fmt.Sprintf("unreachable since %s (%d failures)", health.UnreachableSince.Format("2006-01-02T15:04:05Z"), health.Failures)
+		err := tenant.relayState.DropSubscription(subscription.Domain, reason)
+		if err != nil {
+			logger.WithError(err).WithField(logger.Domain, subscription.Domain).Error("Failed drop subscriber")
+			continue
+		}
+		logger.WithFields(logger.Fields{logger.Domain: subscription.Domain, "reason": reason}).Warn("Drop subscriber")
+	}
+}
+
+func watchSubscriptions() {
+	for range time.Tick(subscriberSweepInterval) {
+		for _, tenant := range tenants {
+			tenant.sweepSubscriptions()
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/spf13/viper"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+const (
+	retryBaseDelay = 10 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+func relayActivityTask(ctx context.Context, inboxURL string, payloadKey string) error {
+	signature := tasks.SignatureFromContext(ctx)
+	body := payloadKey
+	// Jobs enqueued before payload store carry activity body itself.
+	if state.IsPayloadKey(payloadKey) {
+		payload, err := state.LoadPayload(redisClient, payloadKey)
+		if err == state.ErrPayloadExpired {
+			return deadLetterRelayJob(signature, inboxURL, "", state.PayloadExpiredReason, errors.New("Payload ["+payloadKey+"] is expired or not found"))
+		}
+		if err != nil {
+			return err
+		}
+		body = string(payload)
+	}
+	tenant := jobTenant(ctx)
+	if tenant == nil {
+		logger.WithField(logger.Inbox, inboxURL).Warn("Skipping job of unknown tenant")
+		return nil
+	}
+	probe := signature != nil && signature.Headers[state.ProbeJobHeader] == true
+	if tenant.isUnreachableInbox(inboxURL) && !probe {
+		return nil
+	}
+	host := inboxHost(inboxURL)
+	acquired, delay := deliveryGate.acquire(host)
+	if !acquired {
+		return deferRelayJob(signature, inboxURL, body, host, delay)
+	}
+	err := tenant.deliverActivity(inboxURL, body)
+	deliveryGate.release(host, err == nil)
+	if err != nil && probe {
+		// Failed probe is not retried, next probe is claimed after interval.
+		logger.WithError(err).WithField(logger.Inbox, inboxURL).Info("Unreachable subscriber is still failing")
+		return nil
+	}
+	if err != nil {
+		return retryRelayJob(signature, inboxURL, body, err)
+	}
+	return nil
+}
+
+// markFirstAttempt : Record first attempt of relay job on its headers. Returns unix time of first attempt.
+func markFirstAttempt(signature *tasks.Signature) int64 {
+	if signature.Headers == nil {
+		signature.Headers = tasks.Headers{}
+	}
+	firstAttempt := headerInt64(signature.Headers, "relay_first_attempt")
+	if firstAttempt == 0 {
+		firstAttempt = time.Now().Unix()
+	}
+	signature.Headers["relay_first_attempt"] = firstAttempt
+	return firstAttempt
+}
+
+// countAttempt : Count attempt of relay job on its headers. Returns attempts and unix time of first attempt.
+func countAttempt(signature *tasks.Signature) (int64, int64) {
+	firstAttempt := markFirstAttempt(signature)
+	attempts := headerInt64(signature.Headers, "relay_attempts") + 1
+	signature.Headers["relay_attempts"] = attempts
+	return attempts, firstAttempt
+}
+
+// deferRelayJob : Reschedule relay job which host gate does not let through.
+// Deferral is not counted as attempt, but job deferred over retry window is moved to dead-letter queue.
+func deferRelayJob(signature *tasks.Signature, inboxURL string, body string, host string, delay time.Duration) error {
+	deferErr := errors.New("Delivery to " + host + " is deferred")
+	if signature == nil {
+		return tasks.NewErrRetryTaskLater(deferErr.Error(), delay)
+	}
+	firstAttempt := markFirstAttempt(signature)
+	if time.Since(time.Unix(firstAttempt, 0))+delay > viper.GetDuration("job_retry_window") {
+		attempts := headerInt64(signature.Headers, "relay_attempts")
+		return pushDeadLetter(signature, inboxURL, body, state.RetryExhaustedReason, deferErr, attempts, firstAttempt)
+	}
+	logger.WithFields(logger.Fields{logger.JobID: signature.UUID, logger.Inbox: inboxURL, logger.Domain: host, "delay": delay.String()}).Debug("Defer relay job")
+	return tasks.NewErrRetryTaskLater(deferErr.Error(), delay)
+}
+
+// retryRelayJob : Reschedule failed relay job with backoff, or move it to dead-letter queue.
+func retryRelayJob(signature *tasks.Signature, inboxURL string, body string, jobErr error) error {
+	if signature == nil {
+		return jobErr
+	}
+	attempts, firstAttempt := countAttempt(signature)
+	delay := retryDelay(int(attempts))
+	elapsed := time.Since(time.Unix(firstAttempt, 0))
+	if attempts > int64(viper.GetInt("job_retry_max")) || elapsed+delay > viper.GetDuration("job_retry_window") {
+		return pushDeadLetter(signature, inboxURL, body, state.RetryExhaustedReason, jobErr, attempts, firstAttempt)
+	}
+	logger.WithError(jobErr).WithFields(logger.Fields{logger.JobID: signature.UUID, logger.Inbox: inboxURL, "attempts": attempts, "delay": delay.String()}).Debug("Retry relay job later")
+	return tasks.NewErrRetryTaskLater(jobErr.Error(), delay)
+}
+
+// deadLetterRelayJob : Move relay job to dead-letter queue without retry.
+func deadLetterRelayJob(signature *tasks.Signature, inboxURL string, body string, reason string, jobErr error) error {
+	if signature == nil {
+		return jobErr
+	}
+	attempts, firstAttempt := countAttempt(signature)
+	return pushDeadLetter(signature, inboxURL, body, reason, jobErr, attempts, firstAttempt)
+}
+
+func pushDeadLetter(signature *tasks.Signature, inboxURL string, body string, reason string, jobErr error, attempts int64, firstAttempt int64) error {
+	tenantDomain, _ := signature.Headers[state.TenantJobHeader].(string)
+	err := state.PushDeadLetter(redisClient, state.DeadLetter{
+		ID:           signature.UUID,
+		InboxURL:     inboxURL,
+		Body:         body,
+		Error:        jobErr.Error(),
+		Attempts:     int(attempts),
+		FirstAttempt: time.Unix(firstAttempt, 0).UTC(),
+		FailedAt:     time.Now().UTC(),
+		Tenant:       tenantDomain,
+		Reason:       reason,
+	})
+	if err != nil {
+		return err
+	}
+	logger.WithError(jobErr).WithFields(logger.Fields{logger.JobID: signature.UUID, logger.Inbox: inboxURL, "attempts": attempts, "reason": reason}).Warn("Move relay job to dead-letter queue")
+	return jobErr
+}
+
+// retryDelay : Exponential backoff with jitter. attempts is 1-origin.
+func retryDelay(attempts int) time.Duration {
+	delay := retryMaxDelay
+	if attempts < 32 {
+		delay = retryBaseDelay << uint(attempts-1)
+	}
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func headerInt64(headers tasks.Headers, key string) int64 {
+	switch value := headers[key].(type) {
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package delivery
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	httpdate "github.com/Songmu/go-httpdate"
+	"github.com/spf13/viper"
+	httpsignature "github.com/yukimochi/Activity-Relay/HTTPSignature"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// signatureSchemes : Schemes to try for inbox, led by scheme which inbox accepted last time.
+func signatureSchemes(remembered string) []httpsignature.Scheme {
+	scheme, ok := httpsignature.ParseScheme(remembered)
+	if !ok {
+		return httpsignature.Schemes
+	}
+	schemes := []httpsignature.Scheme{scheme}
+	for _, other := range httpsignature.Schemes {
+		if other != scheme {
+			schemes = append(schemes, other)
+		}
+	}
+	return schemes
+}
+
+// sendActivity : Deliver activity, negotiating signature scheme which inbox accepts.
+// Scheme which inbox accepted is remembered, and tried first on next delivery.
+func sendActivity(inboxURL string, KeyID string, body []byte, privateKey crypto.PrivateKey) error {
+	remembered := state.SelectSignatureScheme(redisClient, inboxURL)
+	var err error
+	for _, scheme := range signatureSchemes(remembered) {
+		var status int
+		status, err = postActivity(inboxURL, KeyID, body, privateKey, scheme)
+		if err == nil {
+			if string(scheme) != remembered {
+				state.SetSignatureScheme(redisClient, inboxURL, string(scheme))
+			}
+			return nil
+		}
+		// Inbox answers 401 to signature which it can not verify, so other scheme may be accepted.
+		if status != http.StatusUnauthorized {
+			return err
+		}
+		logger.WithFields(logger.Fields{logger.Inbox: inboxURL, "scheme": scheme}).Debug("Signature scheme is rejected")
+	}
+	return err
+}
+
+func postActivity(inboxURL string, KeyID string, body []byte, privateKey crypto.PrivateKey, scheme httpsignature.Scheme) (int, error) {
+	req, _ := http.NewRequest("POST", inboxURL, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", viper.GetString("relay_servicename"), version, defaultTenant.hostURL.Host))
+	req.Header.Set("Date", httpdate.Time2Str(time.Now()))
+	err := httpsignature.Sign(req, body, KeyID, privateKey, scheme)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		metrics.Deliveries.WithLabelValues(req.URL.Host, "error").Inc()
+		metrics.DeliveryDuration.WithLabelValues("failure").Observe(time.Since(start).Seconds())
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	result := "success"
+	if resp.StatusCode/100 != 2 {
+		result = "failure"
+	}
+	metrics.Deliveries.WithLabelValues(req.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+	metrics.DeliveryDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	log := logger.WithFields(logger.Fields{logger.Inbox: inboxURL, "status": resp.StatusCode, "scheme": scheme})
+	if result == "success" {
+		log.Debug("Deliver activity")
+	} else {
+		log.Warn("Deliver activity")
+	}
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, errors.New("Post " + inboxURL + ": " + resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
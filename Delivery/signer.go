@@ -0,0 +1,47 @@
+package delivery
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+)
+
+var (
+	topicKeysMutex sync.Mutex
+	topicKeys      = map[string]*rsa.PrivateKey{}
+)
+
+// activitySigner : Select key id and key which sign delivery of body.
+// Activities generated by topic actor are signed by topic key, and others are signed by relay actor key.
+func (tenant *Tenant) activitySigner(body []byte) (string, *rsa.PrivateKey) {
+	var activity struct {
+		Actor string `json:"actor"`
+	}
+	json.Unmarshal(body, &activity)
+	prefix := tenant.hostURL.String() + "/"
+	if activity.Actor == tenant.Actor.ID || !strings.HasPrefix(activity.Actor, prefix) || !strings.HasSuffix(activity.Actor, "/actor") {
+		return tenant.Actor.PublicKey.ID, tenant.hostPrivatekey
+	}
+	topic := tenant.relayState.SelectTopic(strings.TrimSuffix(strings.TrimPrefix(activity.Actor, prefix), "/actor"))
+	if topic == nil {
+		return tenant.Actor.PublicKey.ID, tenant.hostPrivatekey
+	}
+
+	topicKeysMutex.Lock()
+	defer topicKeysMutex.Unlock()
+	privateKey, ok := topicKeys[topic.PrivateKey]
+	if !ok {
+		var err error
+		privateKey, err = keyloader.ReadPrivateKeyRSAfromString(topic.PrivateKey)
+		if err != nil {
+			logger.WithError(err).WithField("topic", topic.Name).Error("Failed read topic key")
+			return tenant.Actor.PublicKey.ID, tenant.hostPrivatekey
+		}
+		topicKeys[topic.PrivateKey] = privateKey
+	}
+	return activity.Actor + "#main-key", privateKey
+}
@@ -0,0 +1,72 @@
+package delivery
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/url"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/go-redis/redis"
+	"github.com/spf13/viper"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// Tenant : Relay hosted on one relay domain. Jobs are delivered with actor, key and relay state of their tenant.
+type Tenant struct {
+	// Actor : Relay's Actor
+	Actor activitypub.Actor
+
+	hostURL        *url.URL
+	hostPrivatekey *rsa.PrivateKey
+	relayState     state.RelayState
+}
+
+var (
+	// defaultTenant : Relay configured by top level keys. It delivers jobs without tenant header.
+	defaultTenant *Tenant
+
+	// tenants : Tenants keyed by relay domain
+	tenants map[string]*Tenant
+)
+
+// newTenant : Load key and relay state of tenant
+func newTenant(config state.Tenant, redisClient *redis.Client) (*Tenant, error) {
+	hostURL, err := url.Parse("https://" + config.Domain)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := keyloader.ReadPrivateKeyRSAfromPath(config.ActorPem)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := state.OpenBackend(viper.GetString("storage_backend"), redisClient, config.StoragePath, config.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := &Tenant{
+		hostURL:        hostURL,
+		hostPrivatekey: privateKey,
+	}
+	tenant.relayState = state.NewStateWithBackend(backend, true)
+	tenant.relayState.RedisClient = redisClient
+	tenant.relayState.Namespace = config.Namespace
+	tenant.Actor.Name = config.ServiceName
+	tenant.Actor.GenerateSelfKey(hostURL, &privateKey.PublicKey)
+	return tenant, nil
+}
+
+// jobTenant : Select tenant by header of running job. Returns nil when tenant is not hosted anymore.
+func jobTenant(ctx context.Context) *Tenant {
+	signature := tasks.SignatureFromContext(ctx)
+	if signature == nil {
+		return defaultTenant
+	}
+	domain, _ := signature.Headers[state.TenantJobHeader].(string)
+	if domain == "" {
+		return defaultTenant
+	}
+	return tenants[domain]
+}
@@ -0,0 +1,212 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// ErrForbiddenAddress : Destination resolves to private, loopback or other internal address
+	ErrForbiddenAddress = errors.New("Destination address is not allowed")
+	// ErrTooManyRedirects : Response redirects more than MaxRedirects times
+	ErrTooManyRedirects = errors.New("Too many redirects")
+	// ErrBodyTooLarge : Response body exceeds MaxBodySize
+	ErrBodyTooLarge = errors.New("Response body is too large")
+)
+
+// Options : Limits of outbound HTTP client
+type Options struct {
+	// Timeout : Limit of whole request, including reading response body
+	Timeout time.Duration
+	// MaxBodySize : Limit of response body in bytes, 0 for no limit
+	MaxBodySize int64
+	// MaxRedirects : Limit of followed redirects
+	MaxRedirects int
+	// AllowedNetworks : Networks reachable even if internal, such as test servers on loopback
+	AllowedNetworks []*net.IPNet
+}
+
+// DefaultOptions : Limits used unless configured
+var DefaultOptions = Options{
+	Timeout:      5 * time.Second,
+	MaxBodySize:  1 << 20,
+	MaxRedirects: 3,
+}
+
+// reservedNetworks : Private and other internal ranges which net.IP methods do not classify
+var reservedNetworks = mustParseNetworks([]string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"fc00::/7",
+	"fec0::/10",
+})
+
+// New : Create HTTP client for remote instances. Destination is checked after DNS resolution,
+// so host names resolving to internal addresses are refused too.
+func New(options Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   options.Timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			return options.checkAddress(address)
+		},
+	}
+	transport := &http.Transport{
+		// Proxy from environment would receive every connection, bypassing address check.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   options.Timeout,
+		ResponseHeaderTimeout: options.Timeout,
+	}
+	return &http.Client{
+		Transport: &limitedTransport{transport: transport, maxBodySize: options.MaxBodySize},
+		Timeout:   options.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > options.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			return nil
+		},
+	}
+}
+
+// FromConfig : Create client by limits configured as http_timeout, http_max_body_size,
+// http_max_redirects and http_allowed_networks.
+func FromConfig() (*http.Client, error) {
+	allowedNetworks, err := ParseNetworks(viper.GetStringSlice("http_allowed_networks"))
+	if err != nil {
+		return nil, err
+	}
+	return New(Options{
+		Timeout:         viper.GetDuration("http_timeout"),
+		MaxBodySize:     viper.GetInt64("http_max_body_size"),
+		MaxRedirects:    viper.GetInt("http_max_redirects"),
+		AllowedNetworks: allowedNetworks,
+	}), nil
+}
+
+// ParseNetworks : Parse CIDR notations. Bare address is taken as single host network.
+func ParseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("Invalid network [" + entry + "]")
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("Invalid network [" + entry + "]")
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(entries []string) []*net.IPNet {
+	networks, err := ParseNetworks(entries)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// Forbidden : Check address is internal, such as private, loopback, link-local or multicast one.
+func Forbidden(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddress : Check resolved address which client is dialing
+func (options *Options) checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return ErrForbiddenAddress
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrForbiddenAddress
+	}
+	for _, network := range options.AllowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if Forbidden(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// limitedTransport : Transport which fails reading response body beyond maxBodySize
+type limitedTransport struct {
+	transport   http.RoundTripper
+	maxBodySize int64
+}
+
+func (limited *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := limited.transport.RoundTrip(req)
+	if err != nil || limited.maxBodySize <= 0 {
+		return resp, err
+	}
+	if resp.ContentLength > limited.maxBodySize {
+		resp.Body.Close()
+		return nil, ErrBodyTooLarge
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: limited.maxBodySize}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	// One byte beyond limit is read, so body of exactly the limit is not refused.
+	if int64(len(p)) > body.remaining+1 {
+		p = p[:body.remaining+1]
+	}
+	n, err := body.ReadCloser.Read(p)
+	body.remaining -= int64(n)
+	if body.remaining < 0 {
+		return n + int(body.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
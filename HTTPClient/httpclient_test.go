@@ -0,0 +1,139 @@
+package httpclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func allowLoopback() Options {
+	options := DefaultOptions
+	options.AllowedNetworks, _ = ParseNetworks([]string{"127.0.0.0/8", "::1"})
+	return options
+}
+
+func TestForbiddenAddress(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer s.Close()
+	localhostURL := strings.Replace(s.URL, "127.0.0.1", "localhost", 1)
+
+	client := New(DefaultOptions)
+	for _, url := range []string{s.URL, localhostURL} {
+		_, err := client.Get(url)
+		if !errors.Is(err, ErrForbiddenAddress) {
+			t.Fatalf("Failed - Fetch loopback address by %s", url)
+		}
+	}
+
+	resp, err := New(allowLoopback()).Get(s.URL)
+	if err != nil {
+		t.Fatalf("Failed - Allowed network is refused : " + err.Error())
+	}
+	resp.Body.Close()
+}
+
+func TestFromConfig(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer s.Close()
+
+	viper.Set("http_timeout", "5s")
+	viper.Set("http_allowed_networks", "127.0.0.0/8 ::1")
+	client, err := FromConfig()
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	resp, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Failed - Configured network is refused : " + err.Error())
+	}
+	resp.Body.Close()
+
+	viper.Set("http_allowed_networks", "localhost")
+	if _, err = FromConfig(); err == nil {
+		t.Fatalf("Failed - Accept invalid network")
+	}
+	viper.Set("http_allowed_networks", nil)
+}
+
+func TestForbidden(t *testing.T) {
+	for _, address := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fc00::1", "fd00::1", "172.31.255.255", "fe80::1", "::ffff:10.0.0.1"} {
+		if !Forbidden(net.ParseIP(address)) {
+			t.Fatalf("Failed - Internal address %s is not forbidden", address)
+		}
+	}
+	for _, address := range []string{"203.0.113.1", "8.8.8.8", "2001:4860:4860::8888"} {
+		if Forbidden(net.ParseIP(address)) {
+			t.Fatalf("Failed - Public address %s is forbidden", address)
+		}
+	}
+}
+
+func TestRedirectLimit(t *testing.T) {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, s.URL+r.URL.Path+"/next", http.StatusFound)
+	}))
+	defer s.Close()
+
+	_, err := New(allowLoopback()).Get(s.URL)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("Failed - Endless redirect is followed")
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			w.Write([]byte(strings.Repeat("a", 64)))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(strings.Repeat("a", 64)))
+	}))
+	defer s.Close()
+	options := allowLoopback()
+	options.MaxBodySize = 64
+	client := New(options)
+
+	resp, err := client.Get(s.URL + "/exact")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(data) != 64 {
+		t.Fatalf("Failed - Body of exactly the limit is refused")
+	}
+
+	resp, err = client.Get(s.URL + "/chunked")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	data, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != ErrBodyTooLarge || len(data) != 64 {
+		t.Fatalf("Failed - Body beyond the limit is read")
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.0.0.0/8", "127.0.0.1", " "})
+	if err != nil || len(networks) != 2 {
+		t.Fatalf("Failed - Networks are not parsed")
+	}
+	if !networks[1].Contains(net.ParseIP("127.0.0.1")) || networks[1].Contains(net.ParseIP("127.0.0.2")) {
+		t.Fatalf("Failed - Bare address is not single host network")
+	}
+	if _, err = ParseNetworks([]string{"example.com"}); err == nil {
+		t.Fatalf("Failed - Accept invalid network")
+	}
+}
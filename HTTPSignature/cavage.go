@@ -0,0 +1,152 @@
+package httpsignature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cavageHeaders : Headers covered by outgoing draft-cavage signature
+var cavageHeaders = []string{"(request-target)", "host", "date", "digest", "content-type"}
+
+// cavageFetchHeaders : Headers covered by outgoing draft-cavage signature of GET request
+var cavageFetchHeaders = []string{"(request-target)", "host", "date"}
+
+func newCavageVerifier(request *http.Request) (*Verifier, error) {
+	header := request.Header.Get("Signature")
+	if header == "" {
+		authorization := request.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Signature ") {
+			return nil, ErrNoSignature
+		}
+		header = strings.TrimPrefix(authorization, "Signature ")
+	}
+	params, err := parseCavageParams(header)
+	if err != nil {
+		return nil, err
+	}
+	if params["keyid"] == "" || params["signature"] == "" {
+		return nil, ErrInvalidHeader
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return nil, ErrInvalidHeader
+	}
+
+	algorithm := strings.ToLower(params["algorithm"])
+	scheme := Cavage
+	if algorithm == algorithmHS2019 {
+		scheme = HS2019
+	}
+	headers := []string{"date"}
+	if params["headers"] != "" {
+		headers = strings.Fields(strings.ToLower(params["headers"]))
+	} else if scheme == HS2019 {
+		headers = []string{"(created)"}
+	}
+	base, err := cavageSigningString(request, headers, params)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier := &Verifier{
+		scheme:    scheme,
+		keyID:     params["keyid"],
+		algorithm: algorithm,
+		base:      []byte(base),
+		signature: signature,
+		covered:   headers,
+	}
+	// Pseudo headers are authenticated only when covered.
+	if verifier.Covers("(created)") {
+		verifier.created, _ = strconv.ParseInt(params["created"], 10, 64)
+	}
+	if verifier.Covers("(expires)") {
+		verifier.expires, _ = strconv.ParseInt(params["expires"], 10, 64)
+	}
+	return verifier, nil
+}
+
+func signCavage(request *http.Request, keyID string, key crypto.PrivateKey, scheme Scheme) error {
+	algorithm := algorithmHS2019
+	if scheme == Cavage {
+		algorithm = algorithmRSASHA256
+		if _, ok := key.(ed25519.PrivateKey); ok {
+			algorithm = algorithmEd25519
+		}
+	}
+	headers := cavageHeaders
+	if isBodyless(request) {
+		headers = cavageFetchHeaders
+	}
+	base, err := cavageSigningString(request, headers, nil)
+	if err != nil {
+		return err
+	}
+	signature, err := signWith(algorithm, key, []byte(base))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Signature", `keyId="`+keyID+`",algorithm="`+algorithm+`",headers="`+strings.Join(headers, " ")+`",signature="`+base64.StdEncoding.EncodeToString(signature)+`"`)
+	return nil
+}
+
+// parseCavageParams : Parse comma separated params of Signature header. Names are lowercased.
+func parseCavageParams(header string) (map[string]string, error) {
+	params := map[string]string{}
+	for {
+		header = strings.TrimLeft(header, " ,")
+		if header == "" {
+			return params, nil
+		}
+		eq := strings.IndexByte(header, '=')
+		if eq < 0 {
+			return nil, ErrInvalidHeader
+		}
+		name := strings.ToLower(strings.TrimSpace(header[:eq]))
+		rest := header[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return nil, ErrInvalidHeader
+			}
+			params[name] = rest[1 : end+1]
+			header = rest[end+2:]
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				end = len(rest)
+			}
+			params[name] = strings.TrimSpace(rest[:end])
+			header = rest[end:]
+		}
+	}
+}
+
+// cavageSigningString : Build signing string from covered headers and pseudo headers
+func cavageSigningString(request *http.Request, headers []string, params map[string]string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(request.Method) + " " + request.URL.RequestURI()
+		case "(created)", "(expires)":
+			value = params[strings.Trim(name, "()")]
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return "", ErrMissingHeader
+			}
+		default:
+			var err error
+			value, err = headerValue(request, name)
+			if err != nil {
+				return "", err
+			}
+		}
+		lines = append(lines, name+": "+value)
+	}
+	return strings.Join(lines, "\n"), nil
+}
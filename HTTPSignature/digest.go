@@ -0,0 +1,81 @@
+package httpsignature
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+func setDigest(request *http.Request, body []byte) {
+	hash := sha256.Sum256(body)
+	encoded := base64.StdEncoding.EncodeToString(hash[:])
+	request.Header.Set("Digest", "SHA-256="+encoded)
+	request.Header.Set("Content-Digest", "sha-256=:"+encoded+":")
+}
+
+// VerifyDigest : Check Digest and Content-Digest headers match body. At least one of them is required.
+func VerifyDigest(request *http.Request, body []byte) error {
+	digest := request.Header.Get("Digest")
+	contentDigest := request.Header.Get("Content-Digest")
+	if digest == "" && contentDigest == "" {
+		return ErrDigestMismatch
+	}
+
+	if digest != "" {
+		matched := false
+		for _, value := range strings.Split(digest, ",") {
+			parts := strings.SplitN(strings.TrimSpace(value), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			expected, ok := bodyDigest(parts[0], body)
+			if !ok {
+				continue
+			}
+			if parts[1] != expected {
+				return ErrDigestMismatch
+			}
+			matched = true
+		}
+		if !matched {
+			return ErrDigestMismatch
+		}
+	}
+
+	if contentDigest != "" {
+		members, err := parseDictionary(contentDigest)
+		if err != nil {
+			return ErrDigestMismatch
+		}
+		matched := false
+		for _, member := range members {
+			expected, ok := bodyDigest(member.key, body)
+			if !ok || member.bytes == nil {
+				continue
+			}
+			if base64.StdEncoding.EncodeToString(member.bytes) != expected {
+				return ErrDigestMismatch
+			}
+			matched = true
+		}
+		if !matched {
+			return ErrDigestMismatch
+		}
+	}
+	return nil
+}
+
+// bodyDigest : Base64 digest of body by algorithm name. Returns false for unknown algorithm.
+func bodyDigest(algorithm string, body []byte) (string, bool) {
+	switch strings.ToLower(algorithm) {
+	case "sha-256":
+		hash := sha256.Sum256(body)
+		return base64.StdEncoding.EncodeToString(hash[:]), true
+	case "sha-512":
+		hash := sha512.Sum512(body)
+		return base64.StdEncoding.EncodeToString(hash[:]), true
+	}
+	return "", false
+}
@@ -0,0 +1,260 @@
+package httpsignature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Scheme : HTTP message signature scheme
+type Scheme string
+
+const (
+	// Cavage : draft-cavage-http-signatures with named algorithm such as rsa-sha256
+	Cavage Scheme = "draft-cavage"
+	// HS2019 : draft-cavage-http-signatures with hs2019 algorithm, derived from key
+	HS2019 Scheme = "hs2019"
+	// RFC9421 : RFC 9421 HTTP Message Signatures with Signature-Input header
+	RFC9421 Scheme = "rfc9421"
+)
+
+// Schemes : Outgoing schemes in order of preference, used for recipient which accepted none of them yet.
+var Schemes = []Scheme{Cavage, RFC9421, HS2019}
+
+const (
+	algorithmRSASHA256    = "rsa-sha256"
+	algorithmHS2019       = "hs2019"
+	algorithmEd25519      = "ed25519"
+	algorithmRSAv15SHA256 = "rsa-v1_5-sha256"
+	algorithmRSAPSSSHA512 = "rsa-pss-sha512"
+)
+
+var (
+	// ErrNoSignature : Request has neither Signature nor Signature-Input header
+	ErrNoSignature = errors.New("Request is not signed")
+	// ErrInvalidHeader : Signature header is malformed
+	ErrInvalidHeader = errors.New("Signature header is malformed")
+	// ErrMissingHeader : Signed header is missing in request
+	ErrMissingHeader = errors.New("Signed header is missing")
+	// ErrUnsupportedScheme : Signature scheme is not supported
+	ErrUnsupportedScheme = errors.New("Signature scheme is not supported")
+	// ErrUnsupportedAlgorithm : Signature algorithm is not supported or does not match key
+	ErrUnsupportedAlgorithm = errors.New("Signature algorithm is not supported for the key")
+	// ErrInvalidKey : Public key can not be parsed
+	ErrInvalidKey = errors.New("Public key is malformed")
+	// ErrUnsupportedKey : Public key type is neither RSA nor Ed25519
+	ErrUnsupportedKey = errors.New("Public key type is not supported")
+	// ErrSignatureMismatch : Signature is not made by the key
+	ErrSignatureMismatch = errors.New("Signature verification failed")
+	// ErrDigestMismatch : Digest or Content-Digest header does not match body
+	ErrDigestMismatch = errors.New("Digest header is mismatch")
+)
+
+// ParseScheme : Parse scheme name. Returns false for unknown name.
+func ParseScheme(name string) (Scheme, bool) {
+	for _, scheme := range Schemes {
+		if string(scheme) == name {
+			return scheme, true
+		}
+	}
+	return "", false
+}
+
+// Verifier : Signature of incoming request, ready to be verified by key of keyID
+type Verifier struct {
+	scheme    Scheme
+	keyID     string
+	algorithm string
+	base      []byte
+	signature []byte
+	// covered : Lowercased headers and components covered by signature
+	covered []string
+	// created, expires : Signed creation and expiry time in unix seconds, 0 when not signed
+	created int64
+	expires int64
+}
+
+// NewVerifier : Parse signature of request. RFC 9421 signature is prior to draft-cavage one.
+func NewVerifier(request *http.Request) (*Verifier, error) {
+	if request.Header.Get("Signature-Input") != "" {
+		return newRFC9421Verifier(request)
+	}
+	return newCavageVerifier(request)
+}
+
+// KeyID : Key which request claims to be signed with
+func (verifier *Verifier) KeyID() string {
+	return verifier.keyID
+}
+
+// Scheme : Scheme which request is signed by
+func (verifier *Verifier) Scheme() Scheme {
+	return verifier.scheme
+}
+
+// Signature : Raw signature value, which is unique to signed request
+func (verifier *Verifier) Signature() []byte {
+	return verifier.signature
+}
+
+// Covers : Check header or component is covered by signature
+func (verifier *Verifier) Covers(name string) bool {
+	name = strings.ToLower(name)
+	for _, covered := range verifier.covered {
+		if covered == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SignedAt : Time when request is signed, from signed created param or covered Date header.
+// Returns false when neither is signed.
+func (verifier *Verifier) SignedAt(request *http.Request) (time.Time, bool) {
+	if verifier.created != 0 {
+		return time.Unix(verifier.created, 0), true
+	}
+	if !verifier.Covers("date") {
+		return time.Time{}, false
+	}
+	date, err := http.ParseTime(request.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// ExpiresAt : Signed expiry time of signature. Returns false when not signed.
+func (verifier *Verifier) ExpiresAt() (time.Time, bool) {
+	if verifier.expires == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(verifier.expires, 0), true
+}
+
+// Verify : Verify signature by RSA or Ed25519 public key
+func (verifier *Verifier) Verify(key crypto.PublicKey) error {
+	return verifyWith(verifier.algorithm, key, verifier.base, verifier.signature)
+}
+
+// Sign : Set Digest, Content-Digest and signature headers of request by scheme.
+// key is RSA or Ed25519 private key. GET and HEAD requests are signed without body digest.
+func Sign(request *http.Request, body []byte, keyID string, key crypto.PrivateKey, scheme Scheme) error {
+	if !isBodyless(request) {
+		setDigest(request, body)
+	}
+	if request.Header.Get("Date") == "" {
+		request.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	switch scheme {
+	case Cavage, HS2019:
+		return signCavage(request, keyID, key, scheme)
+	case RFC9421:
+		return signRFC9421(request, keyID, key)
+	default:
+		return ErrUnsupportedScheme
+	}
+}
+
+func verifyWith(algorithm string, key crypto.PublicKey, base []byte, signature []byte) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case algorithmRSASHA256, algorithmRSAv15SHA256:
+			return verifyPKCS1v15(key, base, signature)
+		case algorithmRSAPSSSHA512:
+			return verifyPSS(key, base, signature)
+		case algorithmHS2019, "":
+			// RSA of hs2019 is deployed as both PKCS#1 v1.5 with SHA-256 and PSS with SHA-512.
+			if verifyPKCS1v15(key, base, signature) == nil {
+				return nil
+			}
+			return verifyPSS(key, base, signature)
+		}
+	case ed25519.PublicKey:
+		switch algorithm {
+		case algorithmEd25519, algorithmHS2019, "":
+			if ed25519.Verify(key, base, signature) {
+				return nil
+			}
+			return ErrSignatureMismatch
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return ErrUnsupportedAlgorithm
+}
+
+func verifyPKCS1v15(key *rsa.PublicKey, base []byte, signature []byte) error {
+	hash := sha256.Sum256(base)
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func verifyPSS(key *rsa.PublicKey, base []byte, signature []byte) error {
+	hash := sha512.Sum512(base)
+	if rsa.VerifyPSS(key, crypto.SHA512, hash[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}) != nil {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func signWith(algorithm string, key crypto.PrivateKey, base []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		switch algorithm {
+		case algorithmRSASHA256, algorithmRSAv15SHA256, algorithmHS2019:
+			hash := sha256.Sum256(base)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		case algorithmRSAPSSSHA512:
+			hash := sha512.Sum512(base)
+			return rsa.SignPSS(rand.Reader, key, crypto.SHA512, hash[:], &rsa.PSSOptions{SaltLength: sha512.Size})
+		}
+	case ed25519.PrivateKey:
+		switch algorithm {
+		case algorithmEd25519, algorithmHS2019:
+			return ed25519.Sign(key, base), nil
+		}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// isBodyless : Check request is fetch, which signature covers no body digest
+func isBodyless(request *http.Request) bool {
+	return request.Method == "GET" || request.Method == "HEAD"
+}
+
+// headerValue : Value of header field as covered by signature. Host is taken from request when not in header.
+func headerValue(request *http.Request, name string) (string, error) {
+	values := request.Header[textproto.CanonicalMIMEHeaderKey(name)]
+	if len(values) == 0 && strings.EqualFold(name, "host") {
+		values = []string{requestHost(request)}
+	}
+	if len(values) == 0 || values[0] == "" {
+		return "", ErrMissingHeader
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+func requestHost(request *http.Request) string {
+	if request.Host != "" {
+		return request.Host
+	}
+	return request.URL.Host
+}
@@ -0,0 +1,257 @@
+package httpsignature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mockOutgoingRequest(body []byte) *http.Request {
+	req, _ := http.NewRequest("POST", "https://relay.yukimochi.example.org/inbox", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Date", "Sun, 23 Dec 2018 07:39:37 GMT")
+	return req
+}
+
+// mockIncomingRequest : Request as seen by server behind proxy, which has relative URL
+func mockIncomingRequest(outgoing *http.Request, body []byte) *http.Request {
+	req, _ := http.NewRequest(outgoing.Method, outgoing.URL.RequestURI(), bytes.NewReader(body))
+	req.Host = outgoing.URL.Host
+	req.Header = outgoing.Header.Clone()
+	return req
+}
+
+func TestSignAndVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ed25519Public, ed25519Private, _ := ed25519.GenerateKey(rand.Reader)
+	body := []byte(`{"type":"Create"}`)
+
+	for _, scheme := range Schemes {
+		for name, keys := range map[string][2]interface{}{
+			"rsa":     {rsaKey, &rsaKey.PublicKey},
+			"ed25519": {ed25519Private, ed25519Public},
+		} {
+			outgoing := mockOutgoingRequest(body)
+			err := Sign(outgoing, body, "https://relay.yukimochi.example.org/actor#main-key", keys[0], scheme)
+			if err != nil {
+				t.Fatalf("Failed - Sign by %s with %s key : %s", scheme, name, err.Error())
+			}
+
+			verifier, err := NewVerifier(mockIncomingRequest(outgoing, body))
+			if err != nil {
+				t.Fatalf("Failed - Parse %s signature with %s key : %s", scheme, name, err.Error())
+			}
+			if verifier.Scheme() != scheme || verifier.KeyID() != "https://relay.yukimochi.example.org/actor#main-key" {
+				t.Fatalf("Failed - Wrong scheme or keyId parsed for %s with %s key", scheme, name)
+			}
+			if err = verifier.Verify(keys[1]); err != nil {
+				t.Fatalf("Failed - Verify %s signature with %s key : %s", scheme, name, err.Error())
+			}
+			if err = VerifyDigest(mockIncomingRequest(outgoing, body), body); err != nil {
+				t.Fatalf("Failed - Verify digest of %s signature : %s", scheme, err.Error())
+			}
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	body := []byte(`{"type":"Create"}`)
+
+	for _, scheme := range Schemes {
+		outgoing := mockOutgoingRequest(body)
+		Sign(outgoing, body, "https://relay.yukimochi.example.org/actor#main-key", key, scheme)
+
+		verifier, _ := NewVerifier(mockIncomingRequest(outgoing, body))
+		if verifier.Verify(&otherKey.PublicKey) != ErrSignatureMismatch {
+			t.Fatalf("Failed - Accept %s signature by other key", scheme)
+		}
+
+		incoming := mockIncomingRequest(outgoing, body)
+		incoming.Header.Set("Content-Type", "text/plain")
+		verifier, _ = NewVerifier(incoming)
+		if verifier.Verify(&key.PublicKey) != ErrSignatureMismatch {
+			t.Fatalf("Failed - Accept %s signature of tampered header", scheme)
+		}
+
+		incoming = mockIncomingRequest(outgoing, body)
+		incoming.Host = "other.example.org"
+		verifier, _ = NewVerifier(incoming)
+		if verifier.Verify(&key.PublicKey) != ErrSignatureMismatch {
+			t.Fatalf("Failed - Accept %s signature sent to other host", scheme)
+		}
+	}
+}
+
+func TestVerifyAlgorithmKeyMismatch(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ed25519Public, _, _ := ed25519.GenerateKey(rand.Reader)
+	body := []byte(`{"type":"Create"}`)
+
+	outgoing := mockOutgoingRequest(body)
+	Sign(outgoing, body, "https://relay.yukimochi.example.org/actor#main-key", key, Cavage)
+	verifier, _ := NewVerifier(mockIncomingRequest(outgoing, body))
+	if verifier.Verify(ed25519Public) != ErrUnsupportedAlgorithm {
+		t.Fatalf("Failed - Accept rsa-sha256 signature for Ed25519 key")
+	}
+}
+
+func TestVerifyRFC9421Example(t *testing.T) {
+	// Example of RFC 9421 Appendix B.2.6
+	key, _ := ParsePublicKeyPEM(`-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=
+-----END PUBLIC KEY-----`)
+	req, _ := http.NewRequest("POST", "/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	req.Host = "example.com"
+	req.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", "18")
+	req.Header.Set("Signature-Input", `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+	req.Header.Set("Signature", `sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:`)
+
+	verifier, err := NewVerifier(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if verifier.KeyID() != "test-key-ed25519" {
+		t.Fatalf("Failed - Wrong keyid parsed")
+	}
+	if err = verifier.Verify(key); err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+}
+
+func TestVerifyHS2019Created(t *testing.T) {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	req, _ := http.NewRequest("POST", "/inbox", nil)
+	req.Host = "relay.yukimochi.example.org"
+	signature, _ := signWith(algorithmHS2019, key, []byte("(request-target): post /inbox\n(created): 1402170695"))
+	req.Header.Set("Authorization", `Signature keyId="https://remote.example.com/actor#ed25519-key",algorithm="hs2019",created=1402170695,headers="(request-target) (created)",signature="`+base64.StdEncoding.EncodeToString(signature)+`"`)
+
+	verifier, err := NewVerifier(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if err = verifier.Verify(key.Public()); err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+}
+
+func TestNewVerifierWithoutSignature(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/inbox", nil)
+	if _, err := NewVerifier(req); err != ErrNoSignature {
+		t.Fatalf("Failed - Accept request without signature")
+	}
+	req.Header.Set("Signature", `keyId="https://remote.example.com/actor#main-key",signature="`)
+	if _, err := NewVerifier(req); err != ErrInvalidHeader {
+		t.Fatalf("Failed - Accept malformed signature")
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	body := []byte(`{"type":"Create"}`)
+	req := mockOutgoingRequest(body)
+	setDigest(req, body)
+
+	if VerifyDigest(req, body) != nil {
+		t.Fatalf("Failed - Reject valid digest")
+	}
+	if VerifyDigest(req, []byte(`{"type":"Delete"}`)) != ErrDigestMismatch {
+		t.Fatalf("Failed - Accept digest of other body")
+	}
+	req.Header.Del("Digest")
+	if VerifyDigest(req, body) != nil {
+		t.Fatalf("Failed - Reject valid Content-Digest only")
+	}
+	req.Header.Del("Content-Digest")
+	if VerifyDigest(req, body) != ErrDigestMismatch {
+		t.Fatalf("Failed - Accept request without digest")
+	}
+}
+
+func TestMultibaseKey(t *testing.T) {
+	public, _, _ := ed25519.GenerateKey(rand.Reader)
+	multibase := EncodeMultibaseKey(public)
+	if !strings.HasPrefix(multibase, "z6Mk") {
+		t.Fatalf("Failed - Wrong multibase prefix " + multibase)
+	}
+	key, err := ParseMultibaseKey(multibase)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if !bytes.Equal(public, key.(ed25519.PublicKey)) {
+		t.Fatalf("Failed - Parsed key differs")
+	}
+	if _, err = ParseMultibaseKey("uAAAA"); err != ErrInvalidKey {
+		t.Fatalf("Failed - Accept non base58btc multibase")
+	}
+}
+
+func TestSignedAt(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	body := []byte(`{"type":"Create"}`)
+
+	for _, scheme := range Schemes {
+		outgoing := mockOutgoingRequest(body)
+		Sign(outgoing, body, "https://relay.yukimochi.example.org/actor#main-key", key, scheme)
+		incoming := mockIncomingRequest(outgoing, body)
+		verifier, _ := NewVerifier(incoming)
+
+		signedAt, ok := verifier.SignedAt(incoming)
+		if !ok {
+			t.Fatalf("Failed - Signed time of %s signature is not found", scheme)
+		}
+		if scheme != RFC9421 && !signedAt.Equal(time.Date(2018, 12, 23, 7, 39, 37, 0, time.UTC)) {
+			t.Fatalf("Failed - Signed time of %s signature is not taken from Date header", scheme)
+		}
+		if scheme == RFC9421 && time.Since(signedAt) > time.Minute {
+			t.Fatalf("Failed - Signed time of %s signature is not taken from created param", scheme)
+		}
+		if !verifier.Covers("Content-Digest") && !verifier.Covers("Digest") {
+			t.Fatalf("Failed - Digest is not covered by %s signature", scheme)
+		}
+	}
+
+	req, _ := http.NewRequest("POST", "/inbox", nil)
+	req.Header.Set("Date", "Sun, 23 Dec 2018 07:39:37 GMT")
+	req.Header.Set("Signature", `keyId="https://remote.example.com/actor#main-key",algorithm="rsa-sha256",headers="(request-target) host",signature="c2lnbmF0dXJl"`)
+	req.Host = "relay.yukimochi.example.org"
+	verifier, _ := NewVerifier(req)
+	if _, ok := verifier.SignedAt(req); ok {
+		t.Fatalf("Failed - Date header which is not covered is trusted")
+	}
+}
+
+func TestSignFetch(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for _, scheme := range Schemes {
+		outgoing, _ := http.NewRequest("GET", "https://remote.example.com/users/alice", nil)
+		err := Sign(outgoing, nil, "https://relay.yukimochi.example.org/actor#main-key", key, scheme)
+		if err != nil {
+			t.Fatalf("Failed - Sign GET by %s : %s", scheme, err.Error())
+		}
+		if outgoing.Header.Get("Digest") != "" || outgoing.Header.Get("Content-Digest") != "" {
+			t.Fatalf("Failed - GET signed by %s has digest", scheme)
+		}
+
+		incoming := mockIncomingRequest(outgoing, nil)
+		verifier, err := NewVerifier(incoming)
+		if err != nil {
+			t.Fatalf("Failed - " + err.Error())
+		}
+		if err = verifier.Verify(&key.PublicKey); err != nil {
+			t.Fatalf("Failed - Verify GET signed by %s : %s", scheme, err.Error())
+		}
+		if _, ok := verifier.SignedAt(incoming); !ok {
+			t.Fatalf("Failed - Signed time of GET by %s is not found", scheme)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package httpsignature
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// ed25519Multicodec : Multicodec prefix of Ed25519 public key
+var ed25519Multicodec = []byte{0xed, 0x01}
+
+// ParsePublicKeyPEM : Parse RSA or Ed25519 public key in PKIX or PKCS#1 PEM
+func ParsePublicKeyPEM(pemString string) (crypto.PublicKey, error) {
+	decoded, _ := pem.Decode([]byte(pemString))
+	if decoded == nil {
+		return nil, ErrInvalidKey
+	}
+	if key, err := x509.ParsePKIXPublicKey(decoded.Bytes); err == nil {
+		switch key := key.(type) {
+		case *rsa.PublicKey, ed25519.PublicKey:
+			return key, nil
+		}
+		return nil, ErrUnsupportedKey
+	}
+	key, err := x509.ParsePKCS1PublicKey(decoded.Bytes)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// ParseMultibaseKey : Parse Ed25519 public key of FEP-521a Multikey
+func ParseMultibaseKey(multibase string) (crypto.PublicKey, error) {
+	if !strings.HasPrefix(multibase, "z") {
+		return nil, ErrInvalidKey
+	}
+	decoded, err := decodeBase58(multibase[1:])
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != len(ed25519Multicodec)+ed25519.PublicKeySize || decoded[0] != ed25519Multicodec[0] || decoded[1] != ed25519Multicodec[1] {
+		return nil, ErrUnsupportedKey
+	}
+	return ed25519.PublicKey(decoded[len(ed25519Multicodec):]), nil
+}
+
+// EncodeMultibaseKey : Encode Ed25519 public key as publicKeyMultibase of FEP-521a Multikey
+func EncodeMultibaseKey(key ed25519.PublicKey) string {
+	return "z" + encodeBase58(append(append([]byte{}, ed25519Multicodec...), key...))
+}
+
+func decodeBase58(encoded string) ([]byte, error) {
+	number := new(big.Int)
+	radix := big.NewInt(58)
+	for _, char := range encoded {
+		index := strings.IndexRune(base58Alphabet, char)
+		if index < 0 {
+			return nil, ErrInvalidKey
+		}
+		number.Mul(number, radix)
+		number.Add(number, big.NewInt(int64(index)))
+	}
+	zeros := len(encoded) - len(strings.TrimLeft(encoded, "1"))
+	return append(make([]byte, zeros), number.Bytes()...), nil
+}
+
+func encodeBase58(data []byte) string {
+	number := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	modulo := new(big.Int)
+	var encoded []byte
+	for number.Sign() > 0 {
+		number.DivMod(number, radix, modulo)
+		encoded = append(encoded, base58Alphabet[modulo.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, '1')
+	}
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
@@ -0,0 +1,267 @@
+package httpsignature
+
+import (
+	"crypto"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rfc9421Components : Components covered by outgoing RFC 9421 signature
+var rfc9421Components = []string{"@method", "@target-uri", "content-type", "content-digest"}
+
+// rfc9421FetchComponents : Components covered by outgoing RFC 9421 signature of GET request
+var rfc9421FetchComponents = []string{"@method", "@target-uri"}
+
+// rfc9421Label : Label of outgoing RFC 9421 signature
+const rfc9421Label = "sig1"
+
+// sfMember : Member of structured field dictionary, holding inner list or byte sequence
+type sfMember struct {
+	key    string
+	items  []string
+	bytes  []byte
+	params map[string]string
+	// raw : Serialized member value, which is signed as @signature-params
+	raw string
+}
+
+func newRFC9421Verifier(request *http.Request) (*Verifier, error) {
+	inputs, err := parseDictionary(strings.Join(request.Header["Signature-Input"], ", "))
+	if err != nil {
+		return nil, err
+	}
+	signatures, err := parseDictionary(strings.Join(request.Header["Signature"], ", "))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, input := range inputs {
+		for _, signature := range signatures {
+			if signature.key != input.key {
+				continue
+			}
+			if input.items == nil || signature.bytes == nil || input.params["keyid"] == "" {
+				return nil, ErrInvalidHeader
+			}
+			base, err := rfc9421SignatureBase(request, input.items, input.raw)
+			if err != nil {
+				return nil, err
+			}
+			created, _ := strconv.ParseInt(input.params["created"], 10, 64)
+			expires, _ := strconv.ParseInt(input.params["expires"], 10, 64)
+			return &Verifier{
+				scheme:    RFC9421,
+				keyID:     input.params["keyid"],
+				algorithm: input.params["alg"],
+				base:      []byte(base),
+				signature: signature.bytes,
+				covered:   input.items,
+				created:   created,
+				expires:   expires,
+			}, nil
+		}
+	}
+	return nil, ErrInvalidHeader
+}
+
+func signRFC9421(request *http.Request, keyID string, key crypto.PrivateKey) error {
+	components := rfc9421Components
+	if isBodyless(request) {
+		components = rfc9421FetchComponents
+	}
+	quoted := make([]string, len(components))
+	for i, component := range components {
+		quoted[i] = strconv.Quote(component)
+	}
+	// alg is left out, as fediverse servers derive algorithm from key.
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(time.Now().Unix(), 10) + ";keyid=" + strconv.Quote(keyID)
+	base, err := rfc9421SignatureBase(request, components, params)
+	if err != nil {
+		return err
+	}
+	signature, err := signWith(algorithmRSAv15SHA256, key, []byte(base))
+	if err == ErrUnsupportedAlgorithm {
+		signature, err = signWith(algorithmEd25519, key, []byte(base))
+	}
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Signature-Input", rfc9421Label+"="+params)
+	request.Header.Set("Signature", rfc9421Label+"=:"+base64.StdEncoding.EncodeToString(signature)+":")
+	return nil
+}
+
+// rfc9421SignatureBase : Build signature base from covered components and serialized signature params
+func rfc9421SignatureBase(request *http.Request, components []string, params string) (string, error) {
+	var base strings.Builder
+	for _, component := range components {
+		value, err := componentValue(request, component)
+		if err != nil {
+			return "", err
+		}
+		base.WriteString(strconv.Quote(component) + ": " + value + "\n")
+	}
+	base.WriteString(`"@signature-params": ` + params)
+	return base.String(), nil
+}
+
+// componentValue : Value of derived component or header field.
+// Relay is served over https, so target URI of incoming request is rebuilt with https scheme.
+func componentValue(request *http.Request, component string) (string, error) {
+	switch component {
+	case "@method":
+		return request.Method, nil
+	case "@target-uri":
+		if request.URL.IsAbs() {
+			return request.URL.String(), nil
+		}
+		return "https://" + requestHost(request) + request.URL.RequestURI(), nil
+	case "@authority":
+		return strings.ToLower(requestHost(request)), nil
+	case "@scheme":
+		if request.URL.Scheme != "" {
+			return request.URL.Scheme, nil
+		}
+		return "https", nil
+	case "@request-target":
+		return request.URL.RequestURI(), nil
+	case "@path":
+		if request.URL.EscapedPath() == "" {
+			return "/", nil
+		}
+		return request.URL.EscapedPath(), nil
+	case "@query":
+		return "?" + request.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", ErrInvalidHeader
+	}
+	return headerValue(request, component)
+}
+
+// parseDictionary : Parse structured field dictionary, which members are inner lists of strings or byte sequences.
+func parseDictionary(header string) ([]sfMember, error) {
+	var members []sfMember
+	rest := strings.TrimSpace(header)
+	for rest != "" {
+		member, remaining, err := parseMember(rest)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+		rest = strings.TrimLeft(remaining, " \t")
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, ErrInvalidHeader
+		}
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+	return members, nil
+}
+
+func parseMember(input string) (sfMember, string, error) {
+	var member sfMember
+	eq := strings.IndexByte(input, '=')
+	if eq <= 0 {
+		return member, "", ErrInvalidHeader
+	}
+	member.key = input[:eq]
+	rest := input[eq+1:]
+	start := rest
+
+	switch {
+	case strings.HasPrefix(rest, "("):
+		rest = rest[1:]
+		member.items = []string{}
+		for {
+			rest = strings.TrimLeft(rest, " ")
+			if strings.HasPrefix(rest, ")") {
+				rest = rest[1:]
+				break
+			}
+			item, remaining, err := parseString(rest)
+			if err != nil {
+				return member, "", err
+			}
+			if strings.HasPrefix(remaining, ";") {
+				// Component parameters such as ;req or ;sf are not supported.
+				return member, "", ErrInvalidHeader
+			}
+			member.items = append(member.items, item)
+			rest = remaining
+		}
+	case strings.HasPrefix(rest, ":"):
+		end := strings.IndexByte(rest[1:], ':')
+		if end < 0 {
+			return member, "", ErrInvalidHeader
+		}
+		decoded, err := base64.StdEncoding.DecodeString(rest[1 : end+1])
+		if err != nil {
+			return member, "", ErrInvalidHeader
+		}
+		member.bytes = decoded
+		rest = rest[end+2:]
+	default:
+		return member, "", ErrInvalidHeader
+	}
+
+	member.params = map[string]string{}
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		end := strings.IndexAny(rest, "=;, ")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		rest = rest[end:]
+		value := "?1"
+		if strings.HasPrefix(rest, "=") {
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				var err error
+				value, rest, err = parseString(rest)
+				if err != nil {
+					return member, "", err
+				}
+			} else {
+				end = strings.IndexAny(rest, ";, ")
+				if end < 0 {
+					end = len(rest)
+				}
+				value = rest[:end]
+				rest = rest[end:]
+			}
+		}
+		member.params[name] = value
+	}
+	member.raw = start[:len(start)-len(rest)]
+	return member, rest, nil
+}
+
+// parseString : Parse structured field string at head of input
+func parseString(input string) (string, string, error) {
+	if !strings.HasPrefix(input, `"`) {
+		return "", "", ErrInvalidHeader
+	}
+	var value strings.Builder
+	for i := 1; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if i+1 >= len(input) {
+				return "", "", ErrInvalidHeader
+			}
+			i++
+			value.WriteByte(input[i])
+		case '"':
+			return value.String(), input[i+1:], nil
+		default:
+			value.WriteByte(input[i])
+		}
+	}
+	return "", "", ErrInvalidHeader
+}
@@ -4,9 +4,10 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
-	"fmt"
+	"errors"
 	"io/ioutil"
-	"os"
+
+	logger "github.com/yukimochi/Activity-Relay/Logger"
 )
 
 func ReadPrivateKeyRSAfromPath(path string) (*rsa.PrivateKey, error) {
@@ -30,7 +31,7 @@ func ReadPublicKeyRSAfromString(pemString string) (*rsa.PublicKey, error) {
 	}()
 	keyInterface, err := x509.ParsePKIXPublicKey(decoded.Bytes)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).Debug("Failed parse public key")
 		return nil, err
 	}
 	pub := keyInterface.(*rsa.PublicKey)
@@ -47,3 +48,21 @@ func GeneratePublicKeyPEMString(publicKey *rsa.PublicKey) string {
 	)
 	return string(publicKeyPem)
 }
+
+func ReadPrivateKeyRSAfromString(pemString string) (*rsa.PrivateKey, error) {
+	decoded, _ := pem.Decode([]byte(pemString))
+	if decoded == nil {
+		return nil, errors.New("Invalid PEM string")
+	}
+	return x509.ParsePKCS1PrivateKey(decoded.Bytes)
+}
+
+func GeneratePrivateKeyPEMString(privateKey *rsa.PrivateKey) string {
+	privateKeyPem := pem.EncodeToMemory(
+		&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		},
+	)
+	return string(privateKeyPem)
+}
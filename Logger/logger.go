@@ -0,0 +1,169 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	machinerylog "github.com/RichardKnop/machinery/v1/log"
+	"github.com/sirupsen/logrus"
+)
+
+// Field names shared by all binaries
+const (
+	ActivityID = "activity_id"
+	Actor      = "actor"
+	Domain     = "domain"
+	JobID      = "job_id"
+	Inbox      = "inbox"
+	Component  = "component"
+)
+
+// Fields : Structured fields attached to log entry
+type Fields = logrus.Fields
+
+// Entry : Log entry with fields
+type Entry = logrus.Entry
+
+var log = logrus.New()
+
+// Configure : Set log level [debug,info,warn,error] and format [text,json]
+func Configure(level string, format string) error {
+	parsedLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(parsedLevel)
+	switch format {
+	case "", "text":
+		log.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		log.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("Unknown log format : %s", format)
+	}
+	return nil
+}
+
+// SetOutput : Change destination of logs. Default is stderr.
+func SetOutput(out io.Writer) {
+	log.SetOutput(out)
+}
+
+// WithFields : Create log entry with fields
+func WithFields(fields Fields) *Entry {
+	return log.WithFields(fields)
+}
+
+// WithField : Create log entry with single field
+func WithField(key string, value interface{}) *Entry {
+	return log.WithField(key, value)
+}
+
+// WithError : Create log entry with error field
+func WithError(err error) *Entry {
+	return log.WithError(err)
+}
+
+// Debug : Log message at debug level
+func Debug(args ...interface{}) {
+	log.Debug(args...)
+}
+
+// Info : Log message at info level
+func Info(args ...interface{}) {
+	log.Info(args...)
+}
+
+// Warn : Log message at warn level
+func Warn(args ...interface{}) {
+	log.Warn(args...)
+}
+
+// Error : Log message at error level
+func Error(args ...interface{}) {
+	log.Error(args...)
+}
+
+// BridgeMachinery : Send machinery logs into this logger instead of its own output
+func BridgeMachinery() {
+	machinerylog.SetDebug(NewMachineryLogger("debug"))
+	machinerylog.SetInfo(NewMachineryLogger("info"))
+	machinerylog.SetWarning(NewMachineryLogger("warn"))
+	machinerylog.SetError(NewMachineryLogger("error"))
+	machinerylog.SetFatal(NewMachineryLogger("fatal"))
+}
+
+// MachineryLogger : Bridge machinery's logger interface into this logger at given level.
+type MachineryLogger struct {
+	entry *Entry
+	level logrus.Level
+}
+
+// NewMachineryLogger : Create bridge for machinery log level [debug,info,warn,error,fatal]
+func NewMachineryLogger(level string) *MachineryLogger {
+	parsedLevel, err := logrus.ParseLevel(level)
+	if err != nil {
+		parsedLevel = logrus.InfoLevel
+	}
+	// Machinery FATAL is logged as error here, and exits in Fatal family methods.
+	if parsedLevel < logrus.ErrorLevel {
+		parsedLevel = logrus.ErrorLevel
+	}
+	return &MachineryLogger{log.WithField(Component, "machinery"), parsedLevel}
+}
+
+// Print : Log message at bridge level
+func (l *MachineryLogger) Print(v ...interface{}) {
+	l.entry.Log(l.level, fmt.Sprint(v...))
+}
+
+// Printf : Log formatted message at bridge level
+func (l *MachineryLogger) Printf(format string, v ...interface{}) {
+	l.entry.Log(l.level, fmt.Sprintf(format, v...))
+}
+
+// Println : Log message at bridge level, without trailing newline
+func (l *MachineryLogger) Println(v ...interface{}) {
+	l.entry.Log(l.level, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+// Fatal : Log message as error and exit
+func (l *MachineryLogger) Fatal(v ...interface{}) {
+	l.entry.Error(fmt.Sprint(v...))
+	os.Exit(1)
+}
+
+// Fatalf : Log formatted message as error and exit
+func (l *MachineryLogger) Fatalf(format string, v ...interface{}) {
+	l.entry.Error(fmt.Sprintf(format, v...))
+	os.Exit(1)
+}
+
+// Fatalln : Log message as error, without trailing newline, and exit
+func (l *MachineryLogger) Fatalln(v ...interface{}) {
+	l.entry.Error(strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+	os.Exit(1)
+}
+
+// Panic : Log message as error and panic
+func (l *MachineryLogger) Panic(v ...interface{}) {
+	message := fmt.Sprint(v...)
+	l.entry.Error(message)
+	panic(message)
+}
+
+// Panicf : Log formatted message as error and panic
+func (l *MachineryLogger) Panicf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	l.entry.Error(message)
+	panic(message)
+}
+
+// Panicln : Log message as error, without trailing newline, and panic
+func (l *MachineryLogger) Panicln(v ...interface{}) {
+	message := strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+	l.entry.Error(message)
+	panic(message)
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfigure(t *testing.T) {
+	err := Configure("debug", "json")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	err = Configure("verbose", "json")
+	if err == nil {
+		t.Fatalf("Failed - Accept unknown level")
+	}
+	err = Configure("info", "xml")
+	if err == nil {
+		t.Fatalf("Failed - Accept unknown format")
+	}
+	Configure("info", "text")
+}
+
+func TestJSONFields(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	SetOutput(buffer)
+	Configure("info", "json")
+	defer Configure("info", "text")
+	defer SetOutput(os.Stderr)
+
+	WithFields(Fields{ActivityID: "https://example.com/UUID", Domain: "example.com"}).Info("Accept Relay Status")
+	WithField(Inbox, "https://example.com/inbox").Debug("Suppressed")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Failed - Level not applied")
+	}
+	var entry map[string]interface{}
+	err := json.Unmarshal([]byte(lines[0]), &entry)
+	if err != nil {
+		t.Fatalf("Failed - Output is not JSON")
+	}
+	if entry["msg"] != "Accept Relay Status" || entry["level"] != "info" || entry[ActivityID] != "https://example.com/UUID" || entry[Domain] != "example.com" {
+		t.Fatalf("Failed - Fields not match")
+	}
+}
+
+func TestMachineryLogger(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	SetOutput(buffer)
+	Configure("info", "json")
+	defer Configure("info", "text")
+	defer SetOutput(os.Stderr)
+
+	NewMachineryLogger("debug").Println("Suppressed")
+	NewMachineryLogger("warn").Printf("Retry %s", "later")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Failed - Level not applied")
+	}
+	var entry map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &entry)
+	if entry["msg"] != "Retry later" || entry["level"] != "warning" || entry[Component] != "machinery" {
+		t.Fatalf("Failed - Machinery log not bridged")
+	}
+}
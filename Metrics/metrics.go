@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+const namespace = "relay"
+
+var (
+	// InboxRequests : Inbox requests by activity type and outcome [accepted,rejected,ignored,invalid]
+	InboxRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "inbox_requests_total",
+		Help:      "Inbox requests by activity type and outcome.",
+	}, []string{"type", "outcome"})
+
+	// DuplicateActivities : Activities not relayed because already relayed within dedupe window
+	DuplicateActivities = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicate_activities_total",
+		Help:      "Activities skipped as duplicate by activity type.",
+	}, []string{"type"})
+
+	// LoopedActivities : Activities forwarded back to this relay by peer relays
+	LoopedActivities = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "looped_activities_total",
+		Help:      "Activities skipped because they are forwarded back to this relay.",
+	})
+
+	// FilterMatches : Relayed objects matched by content filter rules
+	FilterMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "filter_matches_total",
+		Help:      "Relayed objects matched by content filter rules.",
+	}, []string{"action"})
+
+	// SignatureFailures : Rejected inbox requests by signature verification failure reason
+	SignatureFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signature_failures_total",
+		Help:      "Signature verification failures by reason.",
+	}, []string{"reason"})
+
+	// ActorCacheRequests : Remote actor lookups by cache result [hit,negative_hit,revalidated,miss]
+	ActorCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actor_cache_requests_total",
+		Help:      "Remote actor lookups by cache result.",
+	}, []string{"result"})
+
+	// JobsEnqueued : Jobs sent to queue by task name
+	JobsEnqueued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_enqueued_total",
+		Help:      "Jobs sent to queue by task name.",
+	}, []string{"task"})
+
+	// Deliveries : Activity deliveries by destination host and status code. Status is "error" when no response.
+	Deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deliveries_total",
+		Help:      "Activity deliveries by destination host and status code.",
+	}, []string{"host", "status"})
+
+	// DeliveryDuration : Latency of activity deliveries by result [success,failure]
+	DeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_duration_seconds",
+		Help:      "Latency of activity deliveries by result.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"result"})
+)
+
+// Handler : HTTP handler which exposes registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterStateGauges : Expose subscriber, blocked and limited domain counts of relay state, labeled by tenant
+func RegisterStateGauges(tenant string, relayState *state.RelayState) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "subscribers",
+		ConstLabels: prometheus.Labels{"tenant": tenant},
+		Help:        "Number of subscribers.",
+	}, func() float64 {
+		return float64(len(relayState.Subscriptions))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "blocked_domains",
+		ConstLabels: prometheus.Labels{"tenant": tenant},
+		Help:        "Number of blocked domains.",
+	}, func() float64 {
+		return float64(len(relayState.BlockedDomains))
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "limited_domains",
+		ConstLabels: prometheus.Labels{"tenant": tenant},
+		Help:        "Number of limited domains.",
+	}, func() float64 {
+		return float64(len(relayState.LimitedDomains))
+	})
+}
+
+// RegisterQueueGauges : Expose depth of job queue, delayed jobs and dead-letter queue
+func RegisterQueueGauges(redisClient *redis.Client, queue string) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Number of jobs waiting in queue.",
+		ConstLabels: prometheus.Labels{"queue": queue},
+	}, func() float64 {
+		depth, _ := redisClient.LLen(queue).Result()
+		return float64(depth)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Number of jobs waiting in queue.",
+		ConstLabels: prometheus.Labels{"queue": "delayed"},
+	}, func() float64 {
+		// Jobs waiting for retry are kept in this sorted set by machinery.
+		depth, _ := redisClient.ZCard("delayed_tasks").Result()
+		return float64(depth)
+	})
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deadletter_jobs",
+		Help:      "Number of jobs in dead-letter queue.",
+	}, func() float64 {
+		count, _ := state.CountDeadLetters(redisClient)
+		return float64(count)
+	})
+}
@@ -0,0 +1,94 @@
+package state
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// DomainList : Enum for domain list
+type DomainList string
+
+const (
+	// LimitedDomainList : Domains which activities are not relayed
+	LimitedDomainList DomainList = "limitedDomain"
+	// BlockedDomainList : Domains which are rejected
+	BlockedDomainList DomainList = "blockedDomain"
+)
+
+// PendingFollow : Follow request waiting for manual accept
+type PendingFollow struct {
+	Domain     string `json:"domain,omitempty"`
+	InboxURL   string `json:"inbox_url,omitempty"`
+	ActivityID string `json:"activity_id,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+	Object     string `json:"object,omitempty"`
+	// Topic : Followed topic actor. Empty for relay actor itself.
+	Topic string `json:"topic,omitempty"`
+}
+
+// Backend : Storage of relay state
+type Backend interface {
+	// LoadConfig : Read relay config flag. Missing flag is false.
+	LoadConfig(key string) (bool, error)
+	SaveConfig(key string, value bool) error
+
+	ListDomains(list DomainList) ([]string, error)
+	SetDomain(list DomainList, domain string, value bool) error
+
+	ListSubscriptions() ([]Subscription, error)
+	PutSubscription(subscription Subscription) error
+	DelSubscription(domain string) error
+
+	ListPendingFollows() ([]PendingFollow, error)
+	// SelectPendingFollow : Select follow request by domain. Returns nil when not found.
+	SelectPendingFollow(domain string) (*PendingFollow, error)
+	PutPendingFollow(follow PendingFollow) error
+	DelPendingFollow(domain string) error
+
+	ListPeers() ([]Peer, error)
+	PutPeer(peer Peer) error
+	DelPeer(domain string) error
+
+	ListFilters() ([]FilterRule, error)
+	PutFilter(rule FilterRule) error
+	DelFilter(name string) error
+
+	ListTopics() ([]Topic, error)
+	PutTopic(topic Topic) error
+	DelTopic(name string) error
+
+	SelectHealth(domain string) (Health, error)
+	// ListHealth : Select delivery health of domains at once, in same order
+	ListHealth(domains []string) ([]Health, error)
+	PutHealth(health Health) error
+	// IncrHealthFailure : Count delivery failure of domain at once, and return counted health
+	IncrHealthFailure(domain string, at time.Time) (Health, error)
+	// MarkHealthUnreachable : Mark domain as unreachable unless it is marked already. Returns true when newly marked.
+	MarkHealthUnreachable(domain string, at time.Time) (bool, error)
+	// ResetHealthFailure : Clear failures of domain with last success at once, and return health before reset
+	ResetHealthFailure(domain string, at time.Time) (Health, error)
+	DelHealth(domain string) error
+	AppendDroppedSubscription(droppedSubscription DroppedSubscription) error
+	ListDroppedSubscriptions() ([]DroppedSubscription, error)
+
+	// Notify : Tell other processes that relay state is changed
+	Notify() error
+	// Listen : Receive notifications sent by Notify
+	Listen() (<-chan struct{}, error)
+}
+
+// OpenBackend : Open storage backend by name [redis,bolt]
+// Redis keys are prefixed by namespace. BoltDB file is not shared between namespaces, so path should differ.
+func OpenBackend(name string, redisClient *redis.Client, path string, namespace string) (Backend, error) {
+	switch name {
+	case "", "redis":
+		return NewNamespacedRedisBackend(redisClient, namespace), nil
+	case "bolt":
+		return NewBoltBackend(path)
+	default:
+		return nil, errors.New("Unknown storage backend : " + name)
+	}
+}
@@ -0,0 +1,445 @@
+package state
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	configBucket       = []byte("config")
+	subscriptionBucket = []byte("subscription")
+	pendingBucket      = []byte("pending")
+	peerBucket         = []byte("peer")
+	filterBucket       = []byte("filter")
+	topicBucket        = []byte("topic")
+	healthBucket       = []byte("health")
+	droppedBucket      = []byte("dropped")
+	metaBucket         = []byte("meta")
+	revisionKey        = []byte("revision")
+)
+
+// boltListenInterval : Interval of polling revision for change notifications
+var boltListenInterval = time.Second
+
+var (
+	// boltDatabases : BoltDB files opened by this process, keyed by path
+	boltDatabases     = map[string]*bolt.DB{}
+	boltDatabasesLock sync.Mutex
+)
+
+// boltBackend : Store relay state into embedded BoltDB file.
+// Database file is opened once per process and kept open. BoltDB locks it while opened,
+// so other processes can not open the same file.
+type boltBackend struct {
+	db *bolt.DB
+}
+
+// openBoltDatabase : Open BoltDB file, or reuse it when this process opened it already.
+func openBoltDatabase(path string) (*bolt.DB, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	boltDatabasesLock.Lock()
+	defer boltDatabasesLock.Unlock()
+	if db, ok := boltDatabases[path]; ok {
+		return db, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.New("Failed open BoltDB file [" + path + "], it may be used by other process : " + err.Error())
+	}
+	boltDatabases[path] = db
+	return db, nil
+}
+
+// NewBoltBackend : Create storage backend with BoltDB file
+func NewBoltBackend(path string) (Backend, error) {
+	db, err := openBoltDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	backend := &boltBackend{db}
+	err = backend.update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{configBucket, []byte(LimitedDomainList), []byte(BlockedDomainList), subscriptionBucket, pendingBucket, peerBucket, filterBucket, topicBucket, healthBucket, droppedBucket, metaBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
+
+func (backend *boltBackend) update(fn func(tx *bolt.Tx) error) error {
+	return backend.db.Update(fn)
+}
+
+func (backend *boltBackend) view(fn func(tx *bolt.Tx) error) error {
+	return backend.db.View(fn)
+}
+
+func (backend *boltBackend) put(bucket []byte, key string, value interface{}) error {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return backend.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), jsonData)
+	})
+}
+
+func (backend *boltBackend) get(bucket []byte, key string, value interface{}) (bool, error) {
+	var data []byte
+	err := backend.view(func(tx *bolt.Tx) error {
+		data = tx.Bucket(bucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, value)
+	})
+	return data != nil, err
+}
+
+func (backend *boltBackend) delete(bucket []byte, key string) error {
+	return backend.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete([]byte(key))
+	})
+}
+
+func (backend *boltBackend) LoadConfig(key string) (bool, error) {
+	var value bool
+	_, err := backend.get(configBucket, key, &value)
+	return value, err
+}
+
+func (backend *boltBackend) SaveConfig(key string, value bool) error {
+	return backend.put(configBucket, key, value)
+}
+
+func (backend *boltBackend) ListDomains(list DomainList) ([]string, error) {
+	var domains []string
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(list)).ForEach(func(key []byte, _ []byte) error {
+			domains = append(domains, string(key))
+			return nil
+		})
+	})
+	return domains, err
+}
+
+func (backend *boltBackend) SetDomain(list DomainList, domain string, value bool) error {
+	if value {
+		return backend.put([]byte(list), domain, value)
+	}
+	return backend.delete([]byte(list), domain)
+}
+
+func (backend *boltBackend) ListSubscriptions() ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionBucket).ForEach(func(_ []byte, value []byte) error {
+			var subscription Subscription
+			err := json.Unmarshal(value, &subscription)
+			if err != nil {
+				return err
+			}
+			subscriptions = append(subscriptions, subscription)
+			return nil
+		})
+	})
+	return subscriptions, err
+}
+
+func (backend *boltBackend) PutSubscription(subscription Subscription) error {
+	return backend.put(subscriptionBucket, subscription.Domain, &subscription)
+}
+
+func (backend *boltBackend) DelSubscription(domain string) error {
+	return backend.delete(subscriptionBucket, domain)
+}
+
+func (backend *boltBackend) ListPendingFollows() ([]PendingFollow, error) {
+	var follows []PendingFollow
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBucket).ForEach(func(_ []byte, value []byte) error {
+			var follow PendingFollow
+			err := json.Unmarshal(value, &follow)
+			if err != nil {
+				return err
+			}
+			follows = append(follows, follow)
+			return nil
+		})
+	})
+	return follows, err
+}
+
+func (backend *boltBackend) SelectPendingFollow(domain string) (*PendingFollow, error) {
+	var follow PendingFollow
+	found, err := backend.get(pendingBucket, domain, &follow)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &follow, nil
+}
+
+func (backend *boltBackend) PutPendingFollow(follow PendingFollow) error {
+	return backend.put(pendingBucket, follow.Domain, &follow)
+}
+
+func (backend *boltBackend) DelPendingFollow(domain string) error {
+	return backend.delete(pendingBucket, domain)
+}
+
+func (backend *boltBackend) ListPeers() ([]Peer, error) {
+	var peers []Peer
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(peerBucket).ForEach(func(_ []byte, value []byte) error {
+			var peer Peer
+			err := json.Unmarshal(value, &peer)
+			if err != nil {
+				return err
+			}
+			peers = append(peers, peer)
+			return nil
+		})
+	})
+	return peers, err
+}
+
+func (backend *boltBackend) PutPeer(peer Peer) error {
+	return backend.put(peerBucket, peer.Domain, &peer)
+}
+
+func (backend *boltBackend) DelPeer(domain string) error {
+	return backend.delete(peerBucket, domain)
+}
+
+func (backend *boltBackend) ListFilters() ([]FilterRule, error) {
+	var rules []FilterRule
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(filterBucket).ForEach(func(_ []byte, value []byte) error {
+			var rule FilterRule
+			err := json.Unmarshal(value, &rule)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	return rules, err
+}
+
+func (backend *boltBackend) PutFilter(rule FilterRule) error {
+	return backend.put(filterBucket, rule.Name, &rule)
+}
+
+func (backend *boltBackend) DelFilter(name string) error {
+	return backend.delete(filterBucket, name)
+}
+
+func (backend *boltBackend) ListTopics() ([]Topic, error) {
+	var topics []Topic
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(topicBucket).ForEach(func(_ []byte, value []byte) error {
+			var topic Topic
+			err := json.Unmarshal(value, &topic)
+			if err != nil {
+				return err
+			}
+			topics = append(topics, topic)
+			return nil
+		})
+	})
+	return topics, err
+}
+
+func (backend *boltBackend) PutTopic(topic Topic) error {
+	return backend.put(topicBucket, topic.Name, &topic)
+}
+
+func (backend *boltBackend) DelTopic(name string) error {
+	return backend.delete(topicBucket, name)
+}
+
+func (backend *boltBackend) SelectHealth(domain string) (Health, error) {
+	health := Health{Domain: domain}
+	_, err := backend.get(healthBucket, domain, &health)
+	return health, err
+}
+
+func (backend *boltBackend) ListHealth(domains []string) ([]Health, error) {
+	healths := make([]Health, len(domains))
+	err := backend.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(healthBucket)
+		for i, domain := range domains {
+			healths[i] = Health{Domain: domain}
+			if value := bucket.Get([]byte(domain)); value != nil {
+				err := json.Unmarshal(value, &healths[i])
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return healths, err
+}
+
+func (backend *boltBackend) PutHealth(health Health) error {
+	return backend.put(healthBucket, health.Domain, &health)
+}
+
+// updateHealth : Read, modify and write health of domain in one transaction. Returns health before modification.
+func (backend *boltBackend) updateHealth(domain string, modify func(health *Health) bool) (Health, error) {
+	var before Health
+	err := backend.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(healthBucket)
+		before = Health{Domain: domain}
+		if value := bucket.Get([]byte(domain)); value != nil {
+			err := json.Unmarshal(value, &before)
+			if err != nil {
+				return err
+			}
+		}
+		health := before
+		if !modify(&health) {
+			return nil
+		}
+		jsonData, err := json.Marshal(&health)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(domain), jsonData)
+	})
+	return before, err
+}
+
+func (backend *boltBackend) IncrHealthFailure(domain string, at time.Time) (Health, error) {
+	var counted Health
+	_, err := backend.updateHealth(domain, func(health *Health) bool {
+		health.Failures++
+		health.LastFailure = at.UTC()
+		if health.FailingSince.IsZero() {
+			health.FailingSince = at.UTC()
+		}
+		counted = *health
+		return true
+	})
+	return counted, err
+}
+
+func (backend *boltBackend) MarkHealthUnreachable(domain string, at time.Time) (bool, error) {
+	marked := false
+	_, err := backend.updateHealth(domain, func(health *Health) bool {
+		marked = health.UnreachableSince.IsZero()
+		if marked {
+			health.UnreachableSince = at.UTC()
+		}
+		return marked
+	})
+	return marked && err == nil, err
+}
+
+func (backend *boltBackend) ResetHealthFailure(domain string, at time.Time) (Health, error) {
+	return backend.updateHealth(domain, func(health *Health) bool {
+		health.Failures = 0
+		health.LastSuccess = at.UTC()
+		health.FailingSince = time.Time{}
+		health.UnreachableSince = time.Time{}
+		return true
+	})
+}
+
+func (backend *boltBackend) DelHealth(domain string) error {
+	return backend.delete(healthBucket, domain)
+}
+
+func (backend *boltBackend) AppendDroppedSubscription(droppedSubscription DroppedSubscription) error {
+	jsonData, err := json.Marshal(&droppedSubscription)
+	if err != nil {
+		return err
+	}
+	return backend.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(droppedBucket)
+		sequence, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, sequence)
+		return bucket.Put(key, jsonData)
+	})
+}
+
+func (backend *boltBackend) ListDroppedSubscriptions() ([]DroppedSubscription, error) {
+	var droppedSubscriptions []DroppedSubscription
+	err := backend.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(droppedBucket).ForEach(func(_ []byte, value []byte) error {
+			var droppedSubscription DroppedSubscription
+			err := json.Unmarshal(value, &droppedSubscription)
+			if err != nil {
+				return nil
+			}
+			droppedSubscriptions = append(droppedSubscriptions, droppedSubscription)
+			return nil
+		})
+	})
+	return droppedSubscriptions, err
+}
+
+func (backend *boltBackend) revision() (uint64, error) {
+	var revision uint64
+	err := backend.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(metaBucket).Get(revisionKey)
+		if value != nil {
+			revision = binary.BigEndian.Uint64(value)
+		}
+		return nil
+	})
+	return revision, err
+}
+
+func (backend *boltBackend) Notify() error {
+	return backend.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metaBucket)
+		var revision uint64
+		if value := bucket.Get(revisionKey); value != nil {
+			revision = binary.BigEndian.Uint64(value)
+		}
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, revision+1)
+		return bucket.Put(revisionKey, value)
+	})
+}
+
+func (backend *boltBackend) Listen() (<-chan struct{}, error) {
+	lastRevision, err := backend.revision()
+	if err != nil {
+		return nil, err
+	}
+	notify := make(chan struct{})
+	go func() {
+		for range time.Tick(boltListenInterval) {
+			revision, err := backend.revision()
+			if err != nil || revision == lastRevision {
+				continue
+			}
+			lastRevision = revision
+			notify <- struct{}{}
+		}
+	}()
+	return notify, nil
+}
@@ -0,0 +1,35 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltBackendOpenOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "relay.db")
+
+	backend, err := NewBoltBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherBackend, err := NewBoltBackend(filepath.Join(dir, ".", "relay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.(*boltBackend).db != otherBackend.(*boltBackend).db {
+		t.Fatalf("Failed - BoltDB file is opened twice in process.")
+	}
+
+	backend.PutSubscription(Subscription{Domain: "example.com", InboxURL: "https://example.com/inbox"})
+	subscriptions, err := otherBackend.ListSubscriptions()
+	if err != nil || len(subscriptions) != 1 {
+		t.Fatalf("Failed - Subscription is not shared through BoltDB file.")
+	}
+}
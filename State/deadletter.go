@@ -0,0 +1,91 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const deadLetterKey = "relay:deadletter"
+
+const (
+	// RetryExhaustedReason : Delivery kept failing over retry limit
+	RetryExhaustedReason = "retry_exhausted"
+	// PayloadExpiredReason : Activity payload expired before delivery, so job has no body to deliver
+	PayloadExpiredReason = "payload_expired"
+)
+
+// DeadLetter : Relay job which exhausted its retries
+type DeadLetter struct {
+	ID           string    `json:"id"`
+	InboxURL     string    `json:"inbox_url"`
+	Body         string    `json:"body"`
+	Error        string    `json:"error"`
+	Attempts     int       `json:"attempts"`
+	FirstAttempt time.Time `json:"first_attempt"`
+	FailedAt     time.Time `json:"failed_at"`
+	// Tenant : Relay domain of tenant which enqueued job. Empty for jobs enqueued before multi-tenant hosting.
+	Tenant string `json:"tenant,omitempty"`
+	// Reason : Why job is moved to dead-letter queue [retry_exhausted,payload_expired]
+	Reason string `json:"reason,omitempty"`
+}
+
+// PushDeadLetter : Store failed relay job into dead-letter queue
+func PushDeadLetter(redisClient *redis.Client, deadLetter DeadLetter) error {
+	jsonData, err := json.Marshal(&deadLetter)
+	if err != nil {
+		return err
+	}
+	return redisClient.HSet(deadLetterKey, deadLetter.ID, jsonData).Err()
+}
+
+// CountDeadLetters : Count jobs in dead-letter queue
+func CountDeadLetters(redisClient *redis.Client) (int64, error) {
+	return redisClient.HLen(deadLetterKey).Result()
+}
+
+// ListDeadLetters : List all jobs in dead-letter queue ordered by failed time
+func ListDeadLetters(redisClient *redis.Client) ([]DeadLetter, error) {
+	entries, err := redisClient.HGetAll(deadLetterKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	var deadLetters []DeadLetter
+	for _, entry := range entries {
+		var deadLetter DeadLetter
+		err = json.Unmarshal([]byte(entry), &deadLetter)
+		if err != nil {
+			continue
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.Before(deadLetters[j].FailedAt)
+	})
+	return deadLetters, nil
+}
+
+// SelectDeadLetter : Select job in dead-letter queue by job ID
+func SelectDeadLetter(redisClient *redis.Client, id string) (*DeadLetter, error) {
+	entry, err := redisClient.HGet(deadLetterKey, id).Result()
+	if err == redis.Nil {
+		return nil, errors.New("Dead letter [" + id + "] is not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deadLetter DeadLetter
+	err = json.Unmarshal([]byte(entry), &deadLetter)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetter, nil
+}
+
+// DelDeadLetter : Delete job from dead-letter queue
+func DelDeadLetter(redisClient *redis.Client, id string) error {
+	return redisClient.HDel(deadLetterKey, id).Err()
+}
@@ -0,0 +1,39 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadLetter(t *testing.T) {
+	redisClient.FlushAll().Result()
+
+	PushDeadLetter(redisClient, DeadLetter{
+		ID:       "task_later",
+		InboxURL: "https://example.com/inbox",
+		FailedAt: time.Now().UTC(),
+	})
+	PushDeadLetter(redisClient, DeadLetter{
+		ID:       "task_earlier",
+		InboxURL: "https://example.org/inbox",
+		FailedAt: time.Now().Add(-time.Minute).UTC(),
+	})
+
+	deadLetters, err := ListDeadLetters(redisClient)
+	if err != nil || len(deadLetters) != 2 || deadLetters[0].ID != "task_earlier" {
+		t.Fatalf("Failed list dead letters.")
+	}
+
+	deadLetter, err := SelectDeadLetter(redisClient, "task_later")
+	if err != nil || deadLetter.InboxURL != "https://example.com/inbox" {
+		t.Fatalf("Failed select dead letter.")
+	}
+
+	DelDeadLetter(redisClient, "task_later")
+	_, err = SelectDeadLetter(redisClient, "task_later")
+	if err == nil {
+		t.Fatalf("Failed delete dead letter.")
+	}
+
+	redisClient.FlushAll().Result()
+}
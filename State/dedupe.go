@@ -0,0 +1,56 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const seenActivityKeyPrefix = "relay:seen:"
+
+func seenActivityKey(id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return seenActivityKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// MarkActivitySeen : Record activity and object IDs as seen for window.
+// Returns false when any of IDs is already seen within window.
+func (config *RelayState) MarkActivitySeen(ids []string, window time.Duration) (bool, error) {
+	pipe := config.RedisClient.Pipeline()
+	var commands []*redis.BoolCmd
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		commands = append(commands, pipe.SetNX(config.key(seenActivityKey(id)), 1, window))
+	}
+	if len(commands) == 0 {
+		return true, nil
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		return true, err
+	}
+	for _, command := range commands {
+		if !command.Val() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ForgetActivitySeen : Remove activity and object IDs recorded by MarkActivitySeen, so they are accepted again.
+func (config *RelayState) ForgetActivitySeen(ids []string) error {
+	var keys []string
+	for _, id := range ids {
+		if id != "" {
+			keys = append(keys, config.key(seenActivityKey(id)))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return config.RedisClient.Del(keys...).Err()
+}
@@ -0,0 +1,53 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarkActivitySeen(t *testing.T) {
+	redisClient.FlushAll().Result()
+	testState := NewState(redisClient, false)
+
+	first, err := testState.MarkActivitySeen([]string{"https://example.com/activity/1", "https://example.com/note/1"}, time.Minute)
+	if err != nil || !first {
+		t.Fatalf("Failed mark activity as seen.")
+	}
+	again, _ := testState.MarkActivitySeen([]string{"https://example.com/activity/1"}, time.Minute)
+	if again {
+		t.Fatalf("Duplicated activity is not detected.")
+	}
+	forwarded, _ := testState.MarkActivitySeen([]string{"https://example.org/announce/1", "https://example.com/note/1"}, time.Minute)
+	if forwarded {
+		t.Fatalf("Duplicated object is not detected.")
+	}
+	other, _ := testState.MarkActivitySeen([]string{"https://example.com/activity/2", ""}, time.Minute)
+	if !other {
+		t.Fatalf("New activity is detected as duplicated.")
+	}
+	for _, key := range []string{"https://example.com/activity/1", "https://example.com/note/1"} {
+		if ttl, _ := redisClient.TTL(seenActivityKey(key)).Result(); ttl <= 0 {
+			t.Fatalf("Seen activity stored without expiry.")
+		}
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestForgetActivitySeen(t *testing.T) {
+	redisClient.FlushAll().Result()
+	testState := NewState(redisClient, false)
+
+	ids := []string{"https://example.com/activity/1", "https://example.com/note/1", ""}
+	testState.MarkActivitySeen(ids, time.Minute)
+	err := testState.ForgetActivitySeen(ids)
+	if err != nil {
+		t.Fatalf("Failed forget seen activity.")
+	}
+	first, _ := testState.MarkActivitySeen(ids, time.Minute)
+	if !first {
+		t.Fatalf("Forgotten activity is detected as duplicated.")
+	}
+
+	redisClient.FlushAll().Result()
+}
@@ -0,0 +1,174 @@
+package state
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FilterDrop : Drop matched activity
+	FilterDrop = "drop"
+	// FilterContentWarning : Relay matched activity behind content warning
+	FilterContentWarning = "cw"
+	// FilterRelay : Relay matched activity as is, even if other rules match
+	FilterRelay = "relay"
+)
+
+// FilterFields : Object fields which filter rule can match
+var FilterFields = []string{"content", "summary", "tag", "language", "sensitive"}
+
+const filterMatchesKey = "relay:filter:matches"
+
+var htmlTagPattern = regexp.MustCompile("<[^>]*>")
+
+// FilterRule : Operator defined rule which matches content of relayed object
+type FilterRule struct {
+	Name    string `json:"name,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Action  string `json:"action,omitempty"`
+}
+
+// Validate : Check field and action of rule
+func (rule *FilterRule) Validate() error {
+	if rule.Name == "" {
+		return errors.New("Filter name is empty")
+	}
+	validField := false
+	for _, field := range FilterFields {
+		validField = validField || field == rule.Field
+	}
+	if !validField {
+		return errors.New("Invalid filter field [" + rule.Field + "] given")
+	}
+	switch rule.Action {
+	case FilterDrop, FilterContentWarning, FilterRelay:
+	default:
+		return errors.New("Invalid filter action [" + rule.Action + "] given")
+	}
+	if rule.Pattern == "" && rule.Field != "sensitive" {
+		return errors.New("Filter pattern is empty")
+	}
+	return nil
+}
+
+// Match : Check object matches rule. Keywords are matched case-insensitively.
+func (rule *FilterRule) Match(object map[string]interface{}) bool {
+	pattern := strings.ToLower(rule.Pattern)
+	switch rule.Field {
+	case "content":
+		texts := []string{stringField(object, "content")}
+		if contentMap, ok := object["contentMap"].(map[string]interface{}); ok {
+			for _, content := range contentMap {
+				text, _ := content.(string)
+				texts = append(texts, text)
+			}
+		}
+		for _, text := range texts {
+			if strings.Contains(strings.ToLower(htmlTagPattern.ReplaceAllString(text, " ")), pattern) {
+				return true
+			}
+		}
+	case "summary":
+		return strings.Contains(strings.ToLower(stringField(object, "summary")), pattern)
+	case "tag":
+		tags, _ := object["tag"].([]interface{})
+		for _, tag := range tags {
+			tag, ok := tag.(map[string]interface{})
+			if !ok || tag["type"] != "Hashtag" {
+				continue
+			}
+			if strings.ToLower(strings.TrimPrefix(stringField(tag, "name"), "#")) == strings.TrimPrefix(pattern, "#") {
+				return true
+			}
+		}
+	case "language":
+		contentMap, _ := object["contentMap"].(map[string]interface{})
+		for language := range contentMap {
+			language = strings.ToLower(language)
+			if language == pattern || strings.HasPrefix(language, pattern+"-") {
+				return true
+			}
+		}
+	case "sensitive":
+		sensitive, _ := object["sensitive"].(bool)
+		return sensitive == (pattern != "false")
+	}
+	return false
+}
+
+func stringField(object map[string]interface{}, key string) string {
+	value, _ := object[key].(string)
+	return value
+}
+
+// MatchFilter : Select rule which decides handling of object. Returns nil when no rule matches.
+// Rules are prior in order of relay, drop and cw, regardless of defined order.
+func (config *RelayState) MatchFilter(object map[string]interface{}) *FilterRule {
+	var matched *FilterRule
+	priority := map[string]int{FilterContentWarning: 1, FilterDrop: 2, FilterRelay: 3}
+	for i, rule := range config.Filters {
+		if (matched == nil || priority[rule.Action] > priority[matched.Action]) && rule.Match(object) {
+			matched = &config.Filters[i]
+		}
+	}
+	return matched
+}
+
+// AddFilter : Add or update filter rule
+func (config *RelayState) AddFilter(rule FilterRule) error {
+	err := rule.Validate()
+	if err != nil {
+		return err
+	}
+	err = config.Backend.PutFilter(rule)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
+
+// DelFilter : Delete filter rule and its match count
+func (config *RelayState) DelFilter(name string) error {
+	err := config.Backend.DelFilter(name)
+	if err != nil {
+		return err
+	}
+	if config.RedisClient != nil {
+		config.RedisClient.HDel(config.key(filterMatchesKey), name)
+	}
+	config.refresh()
+	return nil
+}
+
+// SelectFilter : Select filter rule by name. Returns nil when not found.
+func (config *RelayState) SelectFilter(name string) *FilterRule {
+	for _, rule := range config.Filters {
+		if name == rule.Name {
+			return &rule
+		}
+	}
+	return nil
+}
+
+// CountFilterMatch : Increment match count of filter rule
+func (config *RelayState) CountFilterMatch(name string) error {
+	return config.RedisClient.HIncrBy(config.key(filterMatchesKey), name, 1).Err()
+}
+
+// FilterMatches : Read match counts of filter rules keyed by name
+func (config *RelayState) FilterMatches() (map[string]int64, error) {
+	values, err := config.RedisClient.HGetAll(config.key(filterMatchesKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[string]int64, len(values))
+	for name, value := range values {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		matches[name] = count
+	}
+	return matches, nil
+}
@@ -0,0 +1,103 @@
+package state
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFilterRuleMatch(t *testing.T) {
+	var object map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"type": "Note",
+		"summary": "Spoiler",
+		"content": "<p>Buy <a href=\"https://spam.example.com\">CHEAP</a> watches</p>",
+		"contentMap": {"en-US": "<p>Buy CHEAP watches</p>"},
+		"tag": [{"type": "Hashtag", "name": "#Watches"}, {"type": "Mention", "name": "@spam"}],
+		"sensitive": true
+	}`), &object)
+
+	for _, test := range []struct {
+		rule  FilterRule
+		match bool
+	}{
+		{FilterRule{Field: "content", Pattern: "cheap"}, true},
+		{FilterRule{Field: "content", Pattern: "spam.example.com"}, false},
+		{FilterRule{Field: "summary", Pattern: "spoiler"}, true},
+		{FilterRule{Field: "tag", Pattern: "#watches"}, true},
+		{FilterRule{Field: "tag", Pattern: "spam"}, false},
+		{FilterRule{Field: "language", Pattern: "en"}, true},
+		{FilterRule{Field: "language", Pattern: "ja"}, false},
+		{FilterRule{Field: "sensitive"}, true},
+		{FilterRule{Field: "sensitive", Pattern: "false"}, false},
+	} {
+		if test.rule.Match(object) != test.match {
+			t.Fatalf("Failed match %s [%s].", test.rule.Field, test.rule.Pattern)
+		}
+	}
+}
+
+func TestFilterRuleValidate(t *testing.T) {
+	if (&FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: FilterDrop}).Validate() != nil {
+		t.Fatalf("Valid rule is rejected.")
+	}
+	if (&FilterRule{Name: "spam", Field: "author", Pattern: "spam", Action: FilterDrop}).Validate() == nil {
+		t.Fatalf("Invalid field is accepted.")
+	}
+	if (&FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: "block"}).Validate() == nil {
+		t.Fatalf("Invalid action is accepted.")
+	}
+	if (&FilterRule{Name: "spam", Field: "tag", Action: FilterDrop}).Validate() == nil {
+		t.Fatalf("Empty pattern is accepted.")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		err := testState.AddFilter(FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: FilterDrop})
+		if err != nil {
+			t.Fatalf("Failed add filter.")
+		}
+		testState.AddFilter(FilterRule{Name: "nsfw", Field: "sensitive", Action: FilterContentWarning})
+		testState.AddFilter(FilterRule{Name: "theme", Field: "tag", Pattern: "relay", Action: FilterRelay})
+
+		loadState := NewStateWithBackend(backend, false)
+		if len(loadState.Filters) != 3 || *loadState.SelectFilter("spam") != (FilterRule{"spam", "tag", "spam", FilterDrop}) {
+			t.Fatalf("Failed load filters.")
+		}
+
+		object := map[string]interface{}{
+			"sensitive": true,
+			"tag":       []interface{}{map[string]interface{}{"type": "Hashtag", "name": "#spam"}},
+		}
+		if rule := loadState.MatchFilter(object); rule == nil || rule.Name != "spam" {
+			t.Fatalf("Drop rule should be prior to cw rule.")
+		}
+		object["tag"] = append(object["tag"].([]interface{}), map[string]interface{}{"type": "Hashtag", "name": "#relay"})
+		if rule := loadState.MatchFilter(object); rule == nil || rule.Name != "theme" {
+			t.Fatalf("Relay rule should be prior to drop rule.")
+		}
+		if loadState.MatchFilter(map[string]interface{}{}) != nil {
+			t.Fatalf("Unmatched object is matched.")
+		}
+
+		testState.DelFilter("spam")
+		if testState.SelectFilter("spam") != nil {
+			t.Fatalf("Failed delete filter.")
+		}
+	})
+}
+
+func TestFilterMatches(t *testing.T) {
+	redisClient.FlushAll().Result()
+	testState := NewState(redisClient, false)
+	testState.CountFilterMatch("spam")
+	testState.CountFilterMatch("spam")
+
+	matches, err := testState.FilterMatches()
+	if err != nil || matches["spam"] != 2 {
+		t.Fatalf("Failed count filter matches.")
+	}
+	redisClient.FlushAll().Result()
+}
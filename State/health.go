@@ -0,0 +1,183 @@
+package state
+
+import (
+	"strconv"
+	"time"
+
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+)
+
+const (
+	// HealthyStatus : Last delivery to subscriber was succeeded
+	HealthyStatus = "healthy"
+	// DegradedStatus : Recent deliveries to subscriber are failing
+	DegradedStatus = "degraded"
+	// UnreachableStatus : Subscriber is failing too long, deliveries are stopped
+	UnreachableStatus = "unreachable"
+)
+
+const droppedSubscriptionKey = "relay:audit:dropped"
+
+// ProbeJobHeader : Job header which marks relay job as probe delivery to unreachable subscriber
+const ProbeJobHeader = "relay_probe"
+
+func probeKey(domain string) string {
+	return "relay:probe:" + domain
+}
+
+// Health : Delivery health of subscriber domain
+type Health struct {
+	Domain           string    `json:"domain,omitempty"`
+	Failures         int       `json:"failures"`
+	LastSuccess      time.Time `json:"last_success,omitempty"`
+	LastFailure      time.Time `json:"last_failure,omitempty"`
+	FailingSince     time.Time `json:"failing_since,omitempty"`
+	UnreachableSince time.Time `json:"unreachable_since,omitempty"`
+}
+
+// Status : Summarize health as healthy, degraded or unreachable
+func (health Health) Status() string {
+	switch {
+	case !health.UnreachableSince.IsZero():
+		return UnreachableStatus
+	case health.Failures > 0:
+		return DegradedStatus
+	default:
+		return HealthyStatus
+	}
+}
+
+// DroppedSubscription : Audit record of subscription dropped by relay
+type DroppedSubscription struct {
+	Subscription Subscription `json:"subscription"`
+	Health       Health       `json:"health"`
+	Reason       string       `json:"reason"`
+	DroppedAt    time.Time    `json:"dropped_at"`
+}
+
+func healthKey(domain string) string {
+	return "relay:health:" + domain
+}
+
+func unixTime(value string) time.Time {
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0).UTC()
+}
+
+// SelectHealth : Select delivery health of subscriber domain
+func (config *RelayState) SelectHealth(domain string) Health {
+	health, err := config.Backend.SelectHealth(domain)
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain).Error("Failed select delivery health")
+	}
+	return health
+}
+
+// IsUnreachable : Check subscriber domain is marked as unreachable
+func (config *RelayState) IsUnreachable(domain string) bool {
+	return config.unreachableDomains[domain]
+}
+
+// RecordDeliverySuccess : Reset failure counter of subscriber domain
+func (config *RelayState) RecordDeliverySuccess(domain string) {
+	health, err := config.Backend.ResetHealthFailure(domain, time.Now())
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain).Error("Failed record delivery success")
+		return
+	}
+
+	if !health.UnreachableSince.IsZero() {
+		config.refresh()
+	}
+}
+
+// RecordDeliveryFailure : Count failure of subscriber domain, and mark it as unreachable when it fails over threshold and duration. Returns true when newly marked.
+func (config *RelayState) RecordDeliveryFailure(domain string, threshold int, duration time.Duration) bool {
+	now := time.Now()
+	health, err := config.Backend.IncrHealthFailure(domain, now)
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain).Error("Failed record delivery failure")
+		return false
+	}
+	if !health.UnreachableSince.IsZero() || health.Failures < threshold || now.Sub(health.FailingSince) < duration {
+		return false
+	}
+	// Concurrent failures may pass threshold together, only one of them marks subscriber.
+	marked, err := config.Backend.MarkHealthUnreachable(domain, now)
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain).Error("Failed mark subscriber as unreachable")
+		return false
+	}
+
+	if marked {
+		config.refresh()
+	}
+	return marked
+}
+
+// ClaimProbe : Claim probe delivery to unreachable subscriber domain, which is due interval after last failure.
+// Only one claim succeeds within interval, so unreachable subscriber receives at most one delivery per interval
+// until the delivery succeeds and marks it as reachable again.
+func (config *RelayState) ClaimProbe(domain string, interval time.Duration) bool {
+	if interval <= 0 || time.Since(config.SelectHealth(domain).LastFailure) < interval {
+		return false
+	}
+	claimed, err := config.RedisClient.SetNX(config.key(probeKey(domain)), 1, interval).Result()
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain).Error("Failed claim probe delivery")
+		return false
+	}
+	return claimed
+}
+
+// ResetHealth : Forget delivery health of subscriber domain
+func (config *RelayState) ResetHealth(domain string) {
+	unreachable := config.IsUnreachable(domain)
+	config.Backend.DelHealth(domain)
+
+	if unreachable {
+		config.refresh()
+	}
+}
+
+// DropSubscription : Delete subscription with keeping audit record
+func (config *RelayState) DropSubscription(domain string, reason string) error {
+	subscription := config.SelectSubscription(domain)
+	if subscription == nil {
+		return nil
+	}
+	err := config.Backend.AppendDroppedSubscription(DroppedSubscription{
+		Subscription: *subscription,
+		Health:       config.SelectHealth(domain),
+		Reason:       reason,
+		DroppedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	config.DelSubscription(domain)
+	return nil
+}
+
+// ListDroppedSubscriptions : List audit records of dropped subscriptions
+func (config *RelayState) ListDroppedSubscriptions() ([]DroppedSubscription, error) {
+	return config.Backend.ListDroppedSubscriptions()
+}
+
+func (config *RelayState) loadUnreachableDomains() {
+	var domains []string
+	for _, subscription := range config.Subscriptions {
+		domains = append(domains, subscription.Domain)
+	}
+	healths, _ := config.Backend.ListHealth(domains)
+	unreachableDomains := map[string]bool{}
+	for _, health := range healths {
+		if !health.UnreachableSince.IsZero() {
+			unreachableDomains[health.Domain] = true
+		}
+	}
+	config.unreachableDomains = unreachableDomains
+}
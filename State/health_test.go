@@ -0,0 +1,156 @@
+package state
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRecordDeliveryFailure(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+
+		if testState.RecordDeliveryFailure("example.com", 2, 0) {
+			t.Fatalf("Marked unreachable under threshold.")
+		}
+		health := testState.SelectHealth("example.com")
+		if health.Status() != DegradedStatus || health.Failures != 1 || health.FailingSince.IsZero() {
+			t.Fatalf("Failed record delivery failure.")
+		}
+		if testState.RecordDeliveryFailure("example.com", 2, time.Hour) {
+			t.Fatalf("Marked unreachable under duration.")
+		}
+		if !testState.RecordDeliveryFailure("example.com", 2, 0) {
+			t.Fatalf("Not marked unreachable over threshold.")
+		}
+		if !testState.IsUnreachable("example.com") {
+			t.Fatalf("Failed load unreachable domain.")
+		}
+		if testState.SelectHealth("example.com").Status() != UnreachableStatus {
+			t.Fatalf("Failed mark unreachable.")
+		}
+
+		redisClient.FlushAll().Result()
+	})
+}
+
+func TestRecordDeliverySuccess(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+		testState.RecordDeliveryFailure("example.com", 1, 0)
+		testState.RecordDeliverySuccess("example.com")
+
+		health := testState.SelectHealth("example.com")
+		if health.Status() != HealthyStatus || health.LastSuccess.IsZero() || !health.FailingSince.IsZero() {
+			t.Fatalf("Failed record delivery success.")
+		}
+		if testState.IsUnreachable("example.com") {
+			t.Fatalf("Failed recover unreachable domain.")
+		}
+
+		redisClient.FlushAll().Result()
+	})
+}
+
+func TestRecordDeliveryFailureConcurrently(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+		var wg sync.WaitGroup
+		var marked int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if testState.RecordDeliveryFailure("example.com", 10, 0) {
+					atomic.AddInt32(&marked, 1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if testState.SelectHealth("example.com").Failures != 20 {
+			t.Fatalf("Failed count concurrent delivery failures.")
+		}
+		if marked != 1 {
+			t.Fatalf("Failed mark unreachable only once.")
+		}
+
+		redisClient.FlushAll().Result()
+	})
+}
+
+func TestDropSubscription(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+		testState.RecordDeliveryFailure("example.com", 1, 0)
+		testState.DropSubscription("example.com", "unreachable")
+
+		if testState.SelectSubscription("example.com") != nil {
+			t.Fatalf("Failed drop subscription.")
+		}
+		droppedSubscriptions, err := testState.ListDroppedSubscriptions()
+		if err != nil || len(droppedSubscriptions) != 1 {
+			t.Fatalf("Failed keep audit record.")
+		}
+		if droppedSubscriptions[0].Subscription.InboxURL != "https://example.com/inbox" || droppedSubscriptions[0].Health.Failures != 1 || droppedSubscriptions[0].Reason != "unreachable" {
+			t.Fatalf("Failed keep audit record.")
+		}
+		if testState.SelectHealth("example.com").Failures != 0 {
+			t.Fatalf("Failed clear health.")
+		}
+
+		redisClient.FlushAll().Result()
+	})
+}
+
+func TestClaimProbe(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+		testState.RedisClient = redisClient
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+		testState.RecordDeliveryFailure("example.com", 1, 0)
+
+		if testState.ClaimProbe("example.com", time.Hour) {
+			t.Fatalf("Probe claimed before interval.")
+		}
+		health := testState.SelectHealth("example.com")
+		health.LastFailure = time.Now().Add(-2 * time.Hour)
+		backend.PutHealth(health)
+		if !testState.ClaimProbe("example.com", time.Hour) {
+			t.Fatalf("Probe not claimed after interval.")
+		}
+		if testState.ClaimProbe("example.com", time.Hour) {
+			t.Fatalf("Probe claimed twice within interval.")
+		}
+		if testState.ClaimProbe("example.com", 0) {
+			t.Fatalf("Probe claimed while disabled.")
+		}
+
+		redisClient.FlushAll().Result()
+	})
+}
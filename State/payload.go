@@ -0,0 +1,46 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const payloadKeyPrefix = "relay:payload:"
+
+// ErrPayloadExpired : Activity payload is expired or not stored
+var ErrPayloadExpired = errors.New("Payload is expired or not found")
+
+// PayloadKey : Content-addressed key of activity payload
+func PayloadKey(body []byte) string {
+	hash := sha256.Sum256(body)
+	return payloadKeyPrefix + hex.EncodeToString(hash[:])
+}
+
+// IsPayloadKey : Check string is key of activity payload
+func IsPayloadKey(key string) bool {
+	return strings.HasPrefix(key, payloadKeyPrefix)
+}
+
+// StorePayload : Store activity payload once, and extend its expiry when already stored
+func StorePayload(redisClient *redis.Client, body []byte, ttl time.Duration) (string, error) {
+	key := PayloadKey(body)
+	err := redisClient.Set(key, body, ttl).Err()
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// LoadPayload : Load activity payload by key
+func LoadPayload(redisClient *redis.Client, key string) ([]byte, error) {
+	body, err := redisClient.Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrPayloadExpired
+	}
+	return body, err
+}
@@ -0,0 +1,37 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStorePayload(t *testing.T) {
+	redisClient.FlushAll().Result()
+
+	key, err := StorePayload(redisClient, []byte("data"), time.Minute)
+	if err != nil || !IsPayloadKey(key) {
+		t.Fatalf("Failed store payload.")
+	}
+	sameKey, _ := StorePayload(redisClient, []byte("data"), time.Minute)
+	if key != sameKey {
+		t.Fatalf("Payload key is not content-addressed.")
+	}
+	if keys, _ := redisClient.Keys("relay:payload:*").Result(); len(keys) != 1 {
+		t.Fatalf("Payload stored twice.")
+	}
+	if ttl, _ := redisClient.TTL(key).Result(); ttl <= 0 {
+		t.Fatalf("Payload stored without expiry.")
+	}
+
+	body, err := LoadPayload(redisClient, key)
+	if err != nil || string(body) != "data" {
+		t.Fatalf("Failed load payload.")
+	}
+
+	_, err = LoadPayload(redisClient, PayloadKey([]byte("unknown")))
+	if err == nil {
+		t.Fatalf("Load payload which not stored.")
+	}
+
+	redisClient.FlushAll().Result()
+}
@@ -0,0 +1,67 @@
+package state
+
+import "errors"
+
+const (
+	// PeerPending : Follow is sent to peer relay and waiting for response
+	PeerPending = "pending"
+	// PeerAccepted : Peer relay accepted follow and delivers activities to this relay
+	PeerAccepted = "accepted"
+	// PeerRejected : Peer relay rejected follow
+	PeerRejected = "rejected"
+)
+
+// Peer : Other relay which this relay actor follows
+type Peer struct {
+	Domain   string `json:"domain,omitempty"`
+	InboxURL string `json:"inbox_url,omitempty"`
+	ActorID  string `json:"actor_id,omitempty"`
+	FollowID string `json:"follow_id,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// AddPeer : Add or update peer relay
+func (config *RelayState) AddPeer(peer Peer) error {
+	err := config.Backend.PutPeer(peer)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
+
+// DelPeer : Delete peer relay
+func (config *RelayState) DelPeer(domain string) error {
+	err := config.Backend.DelPeer(domain)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
+
+// SelectPeer : Select peer relay by domain. Returns nil when not found.
+func (config *RelayState) SelectPeer(domain string) *Peer {
+	for _, peer := range config.Peers {
+		if domain == peer.Domain {
+			return &peer
+		}
+	}
+	return nil
+}
+
+// SetPeerStatus : Record response of peer relay for follow
+func (config *RelayState) SetPeerStatus(domain string, status string) error {
+	peer := config.SelectPeer(domain)
+	if peer == nil {
+		return errors.New("Peer [" + domain + "] is not found")
+	}
+	peer.Status = status
+	return config.AddPeer(*peer)
+}
+
+// IsAcceptedPeer : Check domain is peer relay which accepted follow
+func (config *RelayState) IsAcceptedPeer(domain string) bool {
+	peer := config.SelectPeer(domain)
+	return peer != nil && peer.Status == PeerAccepted
+}
@@ -0,0 +1,83 @@
+package state
+
+import "errors"
+
+const (
+	// DeliverAnnounce : Deliver Announce of relayed object regardless of subscriber protocol
+	DeliverAnnounce = "announce"
+	// DeliverRaw : Deliver relayed activity as is regardless of subscriber protocol
+	DeliverRaw = "raw"
+)
+
+// Preferences : Subscriber chosen conditions of relayed activities
+type Preferences struct {
+	Languages        []string `json:"languages,omitempty"`
+	Hashtags         []string `json:"hashtags,omitempty"`
+	ExcludeSensitive bool     `json:"exclude_sensitive,omitempty"`
+	Format           string   `json:"format,omitempty"`
+}
+
+// Validate : Check delivery format of preferences
+func (prefs *Preferences) Validate() error {
+	switch prefs.Format {
+	case "", DeliverAnnounce, DeliverRaw:
+		return nil
+	default:
+		return errors.New("Invalid delivery format [" + prefs.Format + "] given")
+	}
+}
+
+// IsEmpty : Check preferences has no condition
+func (prefs *Preferences) IsEmpty() bool {
+	return prefs == nil || (len(prefs.Languages) == 0 && len(prefs.Hashtags) == 0 && !prefs.ExcludeSensitive && prefs.Format == "")
+}
+
+// Accept : Check subscriber wants activity. Only Create and Announce are examined,
+// and those without embedded object are accepted only when no language or hashtag is chosen.
+func (prefs *Preferences) Accept(activityType string, object interface{}) bool {
+	if prefs == nil || (activityType != "Create" && activityType != "Announce") {
+		return true
+	}
+	objectMap, ok := object.(map[string]interface{})
+	if !ok {
+		return len(prefs.Languages) == 0 && len(prefs.Hashtags) == 0
+	}
+	if sensitive, _ := objectMap["sensitive"].(bool); prefs.ExcludeSensitive && sensitive {
+		return false
+	}
+	return matchAny("language", prefs.Languages, objectMap) && matchAny("tag", prefs.Hashtags, objectMap)
+}
+
+// matchAny : Check object matches any of patterns. Empty patterns match every object.
+func matchAny(field string, patterns []string, object map[string]interface{}) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		rule := FilterRule{Field: field, Pattern: pattern}
+		if rule.Match(object) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPreferences : Set delivery preferences of subscriber. Empty preferences clear them.
+func (config *RelayState) SetPreferences(domain string, prefs *Preferences) error {
+	subscription := config.SelectSubscription(domain)
+	if subscription == nil {
+		return errors.New("Invalid domain [" + domain + "] given")
+	}
+	if prefs.IsEmpty() {
+		prefs = nil
+	} else if err := prefs.Validate(); err != nil {
+		return err
+	}
+	subscription.Preferences = prefs
+	err := config.Backend.PutSubscription(*subscription)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
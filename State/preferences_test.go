@@ -0,0 +1,78 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreferencesAccept(t *testing.T) {
+	object := map[string]interface{}{
+		"contentMap": map[string]interface{}{"ja": "<p>てすてす</p>"},
+		"tag":        []interface{}{map[string]interface{}{"type": "Hashtag", "name": "#relay"}},
+		"sensitive":  true,
+	}
+
+	var noPrefs *Preferences
+	if !noPrefs.Accept("Create", object) {
+		t.Fatalf("Subscriber without preferences should accept everything.")
+	}
+	for _, test := range []struct {
+		prefs  Preferences
+		accept bool
+	}{
+		{Preferences{Languages: []string{"en", "ja"}}, true},
+		{Preferences{Languages: []string{"en"}}, false},
+		{Preferences{Hashtags: []string{"Relay"}}, true},
+		{Preferences{Hashtags: []string{"spam"}}, false},
+		{Preferences{ExcludeSensitive: true}, false},
+		{Preferences{Format: DeliverAnnounce}, true},
+	} {
+		if test.prefs.Accept("Create", object) != test.accept {
+			t.Fatalf("Failed evaluate preferences %+v.", test.prefs)
+		}
+	}
+
+	prefs := Preferences{Languages: []string{"en"}}
+	if !prefs.Accept("Delete", "https://example.com/notes/1") {
+		t.Fatalf("Delete should be accepted regardless of preferences.")
+	}
+	if prefs.Accept("Announce", "https://example.com/notes/1") {
+		t.Fatalf("Announce without embedded object should not be accepted when language is chosen.")
+	}
+}
+
+func TestSetPreferences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+			Protocol: LitePubProtocol,
+		})
+
+		prefs := Preferences{Languages: []string{"en"}, ExcludeSensitive: true, Format: DeliverRaw}
+		err := testState.SetPreferences("example.com", &prefs)
+		if err != nil {
+			t.Fatalf("Failed set preferences.")
+		}
+		loadState := NewStateWithBackend(backend, false)
+		subscription := loadState.SelectSubscription("example.com")
+		if !reflect.DeepEqual(subscription.Preferences, &prefs) || subscription.DeliverAnnounce() {
+			t.Fatalf("Failed load preferences.")
+		}
+
+		err = testState.SetPreferences("example.com", &Preferences{Format: "json"})
+		if err == nil {
+			t.Fatalf("Invalid format is accepted.")
+		}
+		testState.SetPreferences("example.com", &Preferences{})
+		loadState.Load()
+		subscription = loadState.SelectSubscription("example.com")
+		if subscription.Preferences != nil || !subscription.DeliverAnnounce() {
+			t.Fatalf("Failed clear preferences.")
+		}
+		if testState.SetPreferences("example.org", &prefs) == nil {
+			t.Fatalf("Preferences of unknown subscriber is set.")
+		}
+	})
+}
@@ -0,0 +1,534 @@
+package state
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+)
+
+const (
+	subscriptionIndexKey = "relay:index:subscription"
+	pendingIndexKey      = "relay:index:pending"
+	peerIndexKey         = "relay:index:peer"
+	filterIndexKey       = "relay:index:filter"
+	topicIndexKey        = "relay:index:topic"
+)
+
+// redisBackend : Store relay state into redis hashes
+type redisBackend struct {
+	redisClient *redis.Client
+	namespace   string
+}
+
+// NewRedisBackend : Create storage backend with redis client
+func NewRedisBackend(redisClient *redis.Client) Backend {
+	return NewNamespacedRedisBackend(redisClient, "")
+}
+
+// NewNamespacedRedisBackend : Create storage backend which prefixes all keys by namespace.
+// Each tenant stores its state in its own namespace of shared redis.
+func NewNamespacedRedisBackend(redisClient *redis.Client, namespace string) Backend {
+	backend := &redisBackend{redisClient, namespace}
+	err := backend.migrateIndex(subscriptionIndexKey, "relay:subscription:")
+	if err != nil {
+		logger.WithError(err).Error("Failed migrate subscription index")
+	}
+	err = backend.migrateIndex(pendingIndexKey, "relay:pending:")
+	if err != nil {
+		logger.WithError(err).Error("Failed migrate pending follow index")
+	}
+	return backend
+}
+
+func (backend *redisBackend) key(name string) string {
+	return backend.namespace + name
+}
+
+// migrateIndex : Build index set from existing keys. Only runs while index set is not exists.
+func (backend *redisBackend) migrateIndex(indexKey string, prefix string) error {
+	indexKey, prefix = backend.key(indexKey), backend.key(prefix)
+	exists, err := backend.redisClient.Exists(indexKey).Result()
+	if err != nil || exists == 1 {
+		return err
+	}
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor, err = backend.redisClient.Scan(cursor, prefix+"*", 100).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			err = backend.redisClient.SAdd(indexKey, strings.TrimPrefix(key, prefix)).Err()
+			if err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// loadIndexedHashes : Load hashes of all index members with pipelined HGETALL. Returns map keyed by member.
+func (backend *redisBackend) loadIndexedHashes(indexKey string, prefix string) (map[string]map[string]string, error) {
+	indexKey, prefix = backend.key(indexKey), backend.key(prefix)
+	members, err := backend.redisClient.SMembers(indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := backend.redisClient.Pipeline()
+	commands := make(map[string]*redis.StringStringMapCmd, len(members))
+	for _, member := range members {
+		commands[member] = pipe.HGetAll(prefix + member)
+	}
+	if len(members) > 0 {
+		_, err = pipe.Exec()
+		if err != nil {
+			return nil, err
+		}
+	}
+	hashes := make(map[string]map[string]string, len(members))
+	for member, command := range commands {
+		// Index member without hash is left by interrupted deletion.
+		if len(command.Val()) > 0 {
+			hashes[member] = command.Val()
+		}
+	}
+	return hashes, nil
+}
+
+func (backend *redisBackend) LoadConfig(key string) (bool, error) {
+	value, err := backend.redisClient.HGet(backend.key("relay:config"), key).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return value == "1", err
+}
+
+func (backend *redisBackend) SaveConfig(key string, value bool) error {
+	strValue := 0
+	if value {
+		strValue = 1
+	}
+	return backend.redisClient.HSet(backend.key("relay:config"), key, strValue).Err()
+}
+
+func (backend *redisBackend) ListDomains(list DomainList) ([]string, error) {
+	return backend.redisClient.HKeys(backend.key("relay:config:" + string(list))).Result()
+}
+
+func (backend *redisBackend) SetDomain(list DomainList, domain string, value bool) error {
+	if value {
+		return backend.redisClient.HSet(backend.key("relay:config:"+string(list)), domain, "1").Err()
+	}
+	return backend.redisClient.HDel(backend.key("relay:config:"+string(list)), domain).Err()
+}
+
+func (backend *redisBackend) ListSubscriptions() ([]Subscription, error) {
+	hashes, err := backend.loadIndexedHashes(subscriptionIndexKey, "relay:subscription:")
+	if err != nil {
+		return nil, err
+	}
+	var subscriptions []Subscription
+	for domain, hash := range hashes {
+		subscription := Subscription{
+			Domain:     domain,
+			InboxURL:   hash["inbox_url"],
+			ActivityID: hash["activity_id"],
+			ActorID:    hash["actor_id"],
+			Protocol:   hash["protocol"],
+		}
+		if hash["topics"] != "" {
+			subscription.Topics = strings.Split(hash["topics"], ",")
+		}
+		if hash["preferences"] != "" {
+			var prefs Preferences
+			if json.Unmarshal([]byte(hash["preferences"]), &prefs) == nil {
+				subscription.Preferences = &prefs
+			}
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Domain < subscriptions[j].Domain
+	})
+	return subscriptions, nil
+}
+
+func (backend *redisBackend) PutSubscription(subscription Subscription) error {
+	var prefs []byte
+	if subscription.Preferences != nil {
+		var err error
+		prefs, err = json.Marshal(subscription.Preferences)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(backend.key("relay:subscription:"+subscription.Domain), map[string]interface{}{
+			"inbox_url":   subscription.InboxURL,
+			"activity_id": subscription.ActivityID,
+			"actor_id":    subscription.ActorID,
+			"protocol":    subscription.Protocol,
+		})
+		if prefs != nil {
+			pipe.HSet(backend.key("relay:subscription:"+subscription.Domain), "preferences", prefs)
+		} else {
+			pipe.HDel(backend.key("relay:subscription:"+subscription.Domain), "preferences")
+		}
+		if len(subscription.Topics) > 0 {
+			pipe.HSet(backend.key("relay:subscription:"+subscription.Domain), "topics", strings.Join(subscription.Topics, ","))
+		} else {
+			pipe.HDel(backend.key("relay:subscription:"+subscription.Domain), "topics")
+		}
+		pipe.SAdd(backend.key(subscriptionIndexKey), subscription.Domain)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) DelSubscription(domain string) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key("relay:subscription:" + domain))
+		pipe.SRem(backend.key(subscriptionIndexKey), domain)
+		return nil
+	})
+	return err
+}
+
+func pendingFollowFromHash(domain string, hash map[string]string) PendingFollow {
+	return PendingFollow{
+		Domain:     domain,
+		InboxURL:   hash["inbox_url"],
+		ActivityID: hash["activity_id"],
+		Type:       hash["type"],
+		Actor:      hash["actor"],
+		Object:     hash["object"],
+		Topic:      hash["topic"],
+	}
+}
+
+func (backend *redisBackend) ListPendingFollows() ([]PendingFollow, error) {
+	hashes, err := backend.loadIndexedHashes(pendingIndexKey, "relay:pending:")
+	if err != nil {
+		return nil, err
+	}
+	var follows []PendingFollow
+	for domain, hash := range hashes {
+		follows = append(follows, pendingFollowFromHash(domain, hash))
+	}
+	sort.Slice(follows, func(i, j int) bool {
+		return follows[i].Domain < follows[j].Domain
+	})
+	return follows, nil
+}
+
+func (backend *redisBackend) SelectPendingFollow(domain string) (*PendingFollow, error) {
+	hash, err := backend.redisClient.HGetAll(backend.key("relay:pending:" + domain)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) == 0 {
+		return nil, nil
+	}
+	follow := pendingFollowFromHash(domain, hash)
+	return &follow, nil
+}
+
+func (backend *redisBackend) PutPendingFollow(follow PendingFollow) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(backend.key("relay:pending:"+follow.Domain), map[string]interface{}{
+			"inbox_url":   follow.InboxURL,
+			"activity_id": follow.ActivityID,
+			"type":        follow.Type,
+			"actor":       follow.Actor,
+			"object":      follow.Object,
+			"topic":       follow.Topic,
+		})
+		pipe.SAdd(backend.key(pendingIndexKey), follow.Domain)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) DelPendingFollow(domain string) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key("relay:pending:" + domain))
+		pipe.SRem(backend.key(pendingIndexKey), domain)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) ListPeers() ([]Peer, error) {
+	hashes, err := backend.loadIndexedHashes(peerIndexKey, "relay:peer:")
+	if err != nil {
+		return nil, err
+	}
+	var peers []Peer
+	for domain, hash := range hashes {
+		peers = append(peers, Peer{domain, hash["inbox_url"], hash["actor_id"], hash["follow_id"], hash["status"]})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Domain < peers[j].Domain
+	})
+	return peers, nil
+}
+
+func (backend *redisBackend) PutPeer(peer Peer) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(backend.key("relay:peer:"+peer.Domain), map[string]interface{}{
+			"inbox_url": peer.InboxURL,
+			"actor_id":  peer.ActorID,
+			"follow_id": peer.FollowID,
+			"status":    peer.Status,
+		})
+		pipe.SAdd(backend.key(peerIndexKey), peer.Domain)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) DelPeer(domain string) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key("relay:peer:" + domain))
+		pipe.SRem(backend.key(peerIndexKey), domain)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) ListFilters() ([]FilterRule, error) {
+	hashes, err := backend.loadIndexedHashes(filterIndexKey, "relay:filter:rule:")
+	if err != nil {
+		return nil, err
+	}
+	var rules []FilterRule
+	for name, hash := range hashes {
+		rules = append(rules, FilterRule{name, hash["field"], hash["pattern"], hash["action"]})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name < rules[j].Name
+	})
+	return rules, nil
+}
+
+func (backend *redisBackend) PutFilter(rule FilterRule) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(backend.key("relay:filter:rule:"+rule.Name), map[string]interface{}{
+			"field":   rule.Field,
+			"pattern": rule.Pattern,
+			"action":  rule.Action,
+		})
+		pipe.SAdd(backend.key(filterIndexKey), rule.Name)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) DelFilter(name string) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key("relay:filter:rule:" + name))
+		pipe.SRem(backend.key(filterIndexKey), name)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) ListTopics() ([]Topic, error) {
+	hashes, err := backend.loadIndexedHashes(topicIndexKey, "relay:topic:")
+	if err != nil {
+		return nil, err
+	}
+	var topics []Topic
+	for name, hash := range hashes {
+		topic := Topic{Name: name, PrivateKey: hash["private_key"]}
+		err = json.Unmarshal([]byte(hash["criteria"]), &topic.Criteria)
+		if err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics, nil
+}
+
+func (backend *redisBackend) PutTopic(topic Topic) error {
+	criteria, err := json.Marshal(&topic.Criteria)
+	if err != nil {
+		return err
+	}
+	_, err = backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(backend.key("relay:topic:"+topic.Name), map[string]interface{}{
+			"criteria":    criteria,
+			"private_key": topic.PrivateKey,
+		})
+		pipe.SAdd(backend.key(topicIndexKey), topic.Name)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) DelTopic(name string) error {
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key("relay:topic:" + name))
+		pipe.SRem(backend.key(topicIndexKey), name)
+		return nil
+	})
+	return err
+}
+
+func healthFromHash(domain string, hash map[string]string) Health {
+	failures, _ := strconv.Atoi(hash["failures"])
+	return Health{
+		Domain:           domain,
+		Failures:         failures,
+		LastSuccess:      unixTime(hash["last_success"]),
+		LastFailure:      unixTime(hash["last_failure"]),
+		FailingSince:     unixTime(hash["failing_since"]),
+		UnreachableSince: unixTime(hash["unreachable_since"]),
+	}
+}
+
+func (backend *redisBackend) SelectHealth(domain string) (Health, error) {
+	hash, err := backend.redisClient.HGetAll(backend.key(healthKey(domain))).Result()
+	if err != nil {
+		return Health{Domain: domain}, err
+	}
+	return healthFromHash(domain, hash), nil
+}
+
+func (backend *redisBackend) ListHealth(domains []string) ([]Health, error) {
+	pipe := backend.redisClient.Pipeline()
+	commands := make([]*redis.StringStringMapCmd, len(domains))
+	for i, domain := range domains {
+		commands[i] = pipe.HGetAll(backend.key(healthKey(domain)))
+	}
+	if len(domains) > 0 {
+		_, err := pipe.Exec()
+		if err != nil {
+			return nil, err
+		}
+	}
+	healths := make([]Health, len(domains))
+	for i, domain := range domains {
+		healths[i] = healthFromHash(domain, commands[i].Val())
+	}
+	return healths, nil
+}
+
+func (backend *redisBackend) PutHealth(health Health) error {
+	fields := map[string]interface{}{
+		"failures": health.Failures,
+	}
+	for field, value := range map[string]int64{
+		"last_success":      health.LastSuccess.Unix(),
+		"last_failure":      health.LastFailure.Unix(),
+		"failing_since":     health.FailingSince.Unix(),
+		"unreachable_since": health.UnreachableSince.Unix(),
+	} {
+		if value > 0 {
+			fields[field] = value
+		}
+	}
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(backend.key(healthKey(health.Domain)))
+		pipe.HMSet(backend.key(healthKey(health.Domain)), fields)
+		return nil
+	})
+	return err
+}
+
+func (backend *redisBackend) IncrHealthFailure(domain string, at time.Time) (Health, error) {
+	key := backend.key(healthKey(domain))
+	var hash *redis.StringStringMapCmd
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(key, "failures", 1)
+		pipe.HSet(key, "last_failure", at.Unix())
+		pipe.HSetNX(key, "failing_since", at.Unix())
+		hash = pipe.HGetAll(key)
+		return nil
+	})
+	if err != nil {
+		return Health{Domain: domain}, err
+	}
+	return healthFromHash(domain, hash.Val()), nil
+}
+
+func (backend *redisBackend) MarkHealthUnreachable(domain string, at time.Time) (bool, error) {
+	return backend.redisClient.HSetNX(backend.key(healthKey(domain)), "unreachable_since", at.Unix()).Result()
+}
+
+func (backend *redisBackend) ResetHealthFailure(domain string, at time.Time) (Health, error) {
+	key := backend.key(healthKey(domain))
+	var hash *redis.StringStringMapCmd
+	_, err := backend.redisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		hash = pipe.HGetAll(key)
+		pipe.HMSet(key, map[string]interface{}{
+			"failures":     0,
+			"last_success": at.Unix(),
+		})
+		pipe.HDel(key, "failing_since", "unreachable_since")
+		return nil
+	})
+	if err != nil {
+		return Health{Domain: domain}, err
+	}
+	return healthFromHash(domain, hash.Val()), nil
+}
+
+func (backend *redisBackend) DelHealth(domain string) error {
+	return backend.redisClient.Del(backend.key(healthKey(domain))).Err()
+}
+
+func (backend *redisBackend) AppendDroppedSubscription(droppedSubscription DroppedSubscription) error {
+	jsonData, err := json.Marshal(&droppedSubscription)
+	if err != nil {
+		return err
+	}
+	return backend.redisClient.RPush(backend.key(droppedSubscriptionKey), jsonData).Err()
+}
+
+func (backend *redisBackend) ListDroppedSubscriptions() ([]DroppedSubscription, error) {
+	entries, err := backend.redisClient.LRange(backend.key(droppedSubscriptionKey), 0, -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	var droppedSubscriptions []DroppedSubscription
+	for _, entry := range entries {
+		var droppedSubscription DroppedSubscription
+		err = json.Unmarshal([]byte(entry), &droppedSubscription)
+		if err != nil {
+			continue
+		}
+		droppedSubscriptions = append(droppedSubscriptions, droppedSubscription)
+	}
+	return droppedSubscriptions, nil
+}
+
+func (backend *redisBackend) Notify() error {
+	return backend.redisClient.Publish(backend.key("relay_refresh"), "Config refreshing request.").Err()
+}
+
+func (backend *redisBackend) Listen() (<-chan struct{}, error) {
+	pubsub := backend.redisClient.Subscribe(backend.key("relay_refresh"))
+	_, err := pubsub.Receive()
+	if err != nil {
+		return nil, err
+	}
+	notify := make(chan struct{})
+	go func() {
+		for range pubsub.Channel() {
+			notify <- struct{}{}
+		}
+	}()
+	return notify, nil
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMigrateIndex(t *testing.T) {
+	redisClient.FlushAll().Result()
+
+	redisClient.HMSet("relay:subscription:example.com", map[string]interface{}{
+		"inbox_url":   "https://example.com/inbox",
+		"activity_id": "https://example.com/UUID",
+		"actor_id":    "https://example.com/users/example",
+	})
+	redisClient.HMSet("relay:pending:example.org", map[string]interface{}{
+		"inbox_url":   "https://example.org/inbox",
+		"activity_id": "https://example.org/UUID",
+		"type":        "Follow",
+		"actor":       "https://example.org/users/example",
+		"object":      "https://www.w3.org/ns/activitystreams#Public",
+	})
+
+	testState := NewState(redisClient, false)
+
+	if testState.SelectSubscription("example.com") == nil {
+		t.Fatalf("Failed migrate subscription index.")
+	}
+	follows, _ := testState.ListPendingFollows()
+	if len(follows) != 1 || follows[0].Domain != "example.org" {
+		t.Fatalf("Failed migrate pending follow index.")
+	}
+	if members, _ := redisClient.SMembers(subscriptionIndexKey).Result(); len(members) != 1 {
+		t.Fatalf("Failed build subscription index.")
+	}
+
+	testState.DelSubscription("example.com")
+	if members, _ := redisClient.SMembers(subscriptionIndexKey).Result(); len(members) != 0 {
+		t.Fatalf("Failed remove subscription from index.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestLoadStaleIndex(t *testing.T) {
+	redisClient.FlushAll().Result()
+	testState := NewState(redisClient, false)
+
+	testState.AddSubscription(Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	redisClient.Del("relay:subscription:example.com")
+	testState.Load()
+
+	if len(testState.Subscriptions) != 0 {
+		t.Fatalf("Failed skip stale index member.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestNamespacedState(t *testing.T) {
+	redisClient.FlushAll().Result()
+	defaultState := NewState(redisClient, false)
+	tenantState := NewStateWithBackend(NewNamespacedRedisBackend(redisClient, "tenant:relay.example.org:"), false)
+	tenantState.RedisClient = redisClient
+	tenantState.Namespace = "tenant:relay.example.org:"
+
+	tenantState.AddSubscription(Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	tenantState.SetConfig(ManuallyAccept, true)
+	tenantState.CreateAdminToken("moderator")
+	defaultState.Load()
+
+	if defaultState.SelectSubscription("example.com") != nil || defaultState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Tenant state is leaked into default namespace.")
+	}
+	if names, _ := defaultState.ListAdminTokens(); len(names) != 0 {
+		t.Fatalf("Tenant admin token is leaked into default namespace.")
+	}
+	if tenantState.SelectSubscription("example.com") == nil || !tenantState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Failed store tenant state.")
+	}
+	if exists, _ := redisClient.Exists("tenant:relay.example.org:relay:subscription:example.com").Result(); exists != 1 {
+		t.Fatalf("Tenant state is not stored in namespace.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestNamespacedHealth(t *testing.T) {
+	redisClient.FlushAll().Result()
+	defaultState := NewState(redisClient, false)
+	tenantState := NewStateWithBackend(NewNamespacedRedisBackend(redisClient, "tenant:relay.example.org:"), false)
+
+	tenantState.AddSubscription(Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+	tenantState.Backend.PutHealth(Health{Domain: "example.com", Failures: 1, LastFailure: time.Now()})
+	if !tenantState.RecordDeliveryFailure("example.com", 2, 0) {
+		t.Fatalf("Failed count tenant health.")
+	}
+	if tenantState.SelectHealth("example.com").Failures != 2 || !tenantState.IsUnreachable("example.com") {
+		t.Fatalf("Failed read tenant health.")
+	}
+	if defaultState.SelectHealth("example.com").Failures != 0 {
+		t.Fatalf("Tenant health is leaked into default namespace.")
+	}
+	if exists, _ := redisClient.Exists("tenant:relay.example.org:relay:health:example.com").Result(); exists != 1 {
+		t.Fatalf("Tenant health is not stored in namespace.")
+	}
+
+	redisClient.FlushAll().Result()
+}
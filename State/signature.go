@@ -0,0 +1,34 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// signatureSchemeKey : Hash of HTTP signature scheme which each inbox accepted, shared by tenants
+const signatureSchemeKey = "relay:signature_scheme"
+
+// SelectSignatureScheme : Signature scheme which inbox accepted last time. Empty when not known yet.
+func SelectSignatureScheme(redisClient *redis.Client, inboxURL string) string {
+	scheme, _ := redisClient.HGet(signatureSchemeKey, inboxURL).Result()
+	return scheme
+}
+
+// SetSignatureScheme : Remember signature scheme which inbox accepted
+func SetSignatureScheme(redisClient *redis.Client, inboxURL string, scheme string) error {
+	return redisClient.HSet(signatureSchemeKey, inboxURL, scheme).Err()
+}
+
+func seenSignatureKey(signature []byte) string {
+	hash := sha256.Sum256(signature)
+	return "relay:seen:signature:" + hex.EncodeToString(hash[:])
+}
+
+// MarkSignatureSeen : Mark HTTP signature value as used within window.
+// Returns false when the signature is already used, which means the request is replayed.
+func MarkSignatureSeen(redisClient *redis.Client, signature []byte, window time.Duration) (bool, error) {
+	return redisClient.SetNX(seenSignatureKey(signature), 1, window).Result()
+}
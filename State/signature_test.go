@@ -0,0 +1,39 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignatureScheme(t *testing.T) {
+	redisClient.FlushAll().Result()
+
+	if SelectSignatureScheme(redisClient, "https://example.com/inbox") != "" {
+		t.Fatalf("Unknown inbox has signature scheme.")
+	}
+	SetSignatureScheme(redisClient, "https://example.com/inbox", "rfc9421")
+	if SelectSignatureScheme(redisClient, "https://example.com/inbox") != "rfc9421" {
+		t.Fatalf("Signature scheme is not remembered.")
+	}
+
+	redisClient.FlushAll().Result()
+}
+
+func TestMarkSignatureSeen(t *testing.T) {
+	redisClient.FlushAll().Result()
+
+	fresh, err := MarkSignatureSeen(redisClient, []byte("signature"), time.Minute)
+	if err != nil || !fresh {
+		t.Fatalf("Failed mark signature as seen.")
+	}
+	fresh, _ = MarkSignatureSeen(redisClient, []byte("signature"), time.Minute)
+	if fresh {
+		t.Fatalf("Replayed signature is not detected.")
+	}
+	fresh, _ = MarkSignatureSeen(redisClient, []byte("other signature"), time.Minute)
+	if !fresh {
+		t.Fatalf("Other signature is detected as replayed.")
+	}
+
+	redisClient.FlushAll().Result()
+}
@@ -1,10 +1,8 @@
 package state
 
 import (
-	"fmt"
-	"strings"
-
 	"github.com/go-redis/redis"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
 )
 
 // Config : Enum for RelayConfig
@@ -19,21 +17,43 @@ const (
 	CreateAsAnnounce
 )
 
+const (
+	// MastodonProtocol : Subscriber follows as:Public and receives activities as is
+	MastodonProtocol = "mastodon"
+	// LitePubProtocol : Subscriber follows relay actor and receives activities as Announce
+	LitePubProtocol = "litepub"
+)
+
 // RelayState : Store subscriptions and relay configurations
 type RelayState struct {
+	// RedisClient : Redis client for job related data such as payloads and dead letters
 	RedisClient *redis.Client
-	notifiable  bool
+	// Namespace : Prefix of redis keys owned by tenant, such as admin tokens and seen activities
+	Namespace          string  `json:"-"`
+	Backend            Backend `json:"-"`
+	notifiable         bool
+	unreachableDomains map[string]bool
 
 	RelayConfig    relayConfig    `json:"relayConfig,omitempty"`
 	LimitedDomains []string       `json:"limitedDomains,omitempty"`
 	BlockedDomains []string       `json:"blockedDomains,omitempty"`
 	Subscriptions  []Subscription `json:"subscriptions,omitempty"`
+	Peers          []Peer         `json:"peers,omitempty"`
+	Filters        []FilterRule   `json:"filters,omitempty"`
+	Topics         []Topic        `json:"topics,omitempty"`
 }
 
 // NewState : Create new RelayState instance with redis client
 func NewState(redisClient *redis.Client, notifiable bool) RelayState {
-	var config RelayState
+	config := NewStateWithBackend(NewRedisBackend(redisClient), notifiable)
 	config.RedisClient = redisClient
+	return config
+}
+
+// NewStateWithBackend : Create new RelayState instance with storage backend
+func NewStateWithBackend(backend Backend, notifiable bool) RelayState {
+	var config RelayState
+	config.Backend = backend
 	config.notifiable = notifiable
 
 	config.Load()
@@ -41,16 +61,15 @@ func NewState(redisClient *redis.Client, notifiable bool) RelayState {
 }
 
 func (config *RelayState) ListenNotify(c chan<- bool) {
-	_, err := config.RedisClient.Subscribe("relay_refresh").Receive()
+	ch, err := config.Backend.Listen()
 	if err != nil {
 		panic(err)
 	}
-	ch := config.RedisClient.Subscribe("relay_refresh").Channel()
 
 	cNotify := c != nil
 	go func() {
 		for range ch {
-			fmt.Println("Config refreshed from state changed notify.")
+			logger.Debug("Config refreshed from state changed notify.")
 			config.Load()
 			if cNotify {
 				c <- true
@@ -59,52 +78,37 @@ func (config *RelayState) ListenNotify(c chan<- bool) {
 	}()
 }
 
-// Load : Refrash content from redis
+// Load : Refrash content from storage backend
 func (config *RelayState) Load() {
-	config.RelayConfig.load(config.RedisClient)
-	var limitedDomains []string
-	var blockedDomains []string
-	var subscriptions []Subscription
-	domains, _ := config.RedisClient.HKeys("relay:config:limitedDomain").Result()
-	for _, domain := range domains {
-		limitedDomains = append(limitedDomains, domain)
-	}
-	domains, _ = config.RedisClient.HKeys("relay:config:blockedDomain").Result()
-	for _, domain := range domains {
-		blockedDomains = append(blockedDomains, domain)
-	}
-	domains, _ = config.RedisClient.Keys("relay:subscription:*").Result()
-	for _, domain := range domains {
-		domainName := strings.Replace(domain, "relay:subscription:", "", 1)
-		inboxURL, _ := config.RedisClient.HGet(domain, "inbox_url").Result()
-		activityID, err := config.RedisClient.HGet(domain, "activity_id").Result()
-		if err != nil {
-			activityID = ""
-		}
-		actorID, err := config.RedisClient.HGet(domain, "actor_id").Result()
-		if err != nil {
-			actorID = ""
-		}
-		subscriptions = append(subscriptions, Subscription{domainName, inboxURL, activityID, actorID})
-	}
+	config.RelayConfig.load(config.Backend)
+	limitedDomains, _ := config.Backend.ListDomains(LimitedDomainList)
+	blockedDomains, _ := config.Backend.ListDomains(BlockedDomainList)
+	subscriptions, _ := config.Backend.ListSubscriptions()
+	peers, _ := config.Backend.ListPeers()
+	filters, _ := config.Backend.ListFilters()
+	topics, _ := config.Backend.ListTopics()
 	config.LimitedDomains = limitedDomains
 	config.BlockedDomains = blockedDomains
 	config.Subscriptions = subscriptions
+	config.Peers = peers
+	config.Filters = filters
+	config.Topics = topics
+	config.loadUnreachableDomains()
+}
+
+func (config *RelayState) key(name string) string {
+	return config.Namespace + name
 }
 
 // SetConfig : Set relay configration
 func (config *RelayState) SetConfig(key Config, value bool) {
-	strValue := 0
-	if value {
-		strValue = 1
-	}
 	switch key {
 	case BlockService:
-		config.RedisClient.HSet("relay:config", "block_service", strValue).Result()
+		config.Backend.SaveConfig("block_service", value)
 	case ManuallyAccept:
-		config.RedisClient.HSet("relay:config", "manually_accept", strValue).Result()
+		config.Backend.SaveConfig("manually_accept", value)
 	case CreateAsAnnounce:
-		config.RedisClient.HSet("relay:config", "create_as_announce", strValue).Result()
+		config.Backend.SaveConfig("create_as_announce", value)
 	}
 
 	config.refresh()
@@ -112,19 +116,17 @@ func (config *RelayState) SetConfig(key Config, value bool) {
 
 // AddSubscription : Add new instance for subscription list
 func (config *RelayState) AddSubscription(domain Subscription) {
-	config.RedisClient.HMSet("relay:subscription:"+domain.Domain, map[string]interface{}{
-		"inbox_url":   domain.InboxURL,
-		"activity_id": domain.ActivityID,
-		"actor_id":    domain.ActorID,
-	})
+	config.Backend.PutSubscription(domain)
+	config.Backend.DelHealth(domain.Domain)
 
 	config.refresh()
 }
 
 // DelSubscription : Delete instance from subscription list
 func (config *RelayState) DelSubscription(domain string) {
-	config.RedisClient.Del("relay:subscription:" + domain).Result()
-	config.RedisClient.Del("relay:pending:" + domain).Result()
+	config.Backend.DelSubscription(domain)
+	config.Backend.DelPendingFollow(domain)
+	config.Backend.DelHealth(domain)
 
 	config.refresh()
 }
@@ -139,31 +141,53 @@ func (config *RelayState) SelectSubscription(domain string) *Subscription {
 	return nil
 }
 
+// SelectSubscriptionByInbox : Select instance from inbox URL
+func (config *RelayState) SelectSubscriptionByInbox(inboxURL string) *Subscription {
+	for _, subscription := range config.Subscriptions {
+		if inboxURL == subscription.InboxURL {
+			return &subscription
+		}
+	}
+	return nil
+}
+
+// AddPendingFollow : Add follow request waiting for manual accept
+func (config *RelayState) AddPendingFollow(follow PendingFollow) error {
+	return config.Backend.PutPendingFollow(follow)
+}
+
+// SelectPendingFollow : Select follow request by domain. Returns nil when not found.
+func (config *RelayState) SelectPendingFollow(domain string) (*PendingFollow, error) {
+	return config.Backend.SelectPendingFollow(domain)
+}
+
+// ListPendingFollows : List follow requests waiting for manual accept
+func (config *RelayState) ListPendingFollows() ([]PendingFollow, error) {
+	return config.Backend.ListPendingFollows()
+}
+
+// DelPendingFollow : Delete follow request
+func (config *RelayState) DelPendingFollow(domain string) error {
+	return config.Backend.DelPendingFollow(domain)
+}
+
 // SetBlockedDomain : Set/Unset instance for blocked domain
 func (config *RelayState) SetBlockedDomain(domain string, value bool) {
-	if value {
-		config.RedisClient.HSet("relay:config:blockedDomain", domain, "1").Result()
-	} else {
-		config.RedisClient.HDel("relay:config:blockedDomain", domain).Result()
-	}
+	config.Backend.SetDomain(BlockedDomainList, domain, value)
 
 	config.refresh()
 }
 
 // SetLimitedDomain : Set/Unset instance for limited domain
 func (config *RelayState) SetLimitedDomain(domain string, value bool) {
-	if value {
-		config.RedisClient.HSet("relay:config:limitedDomain", domain, "1").Result()
-	} else {
-		config.RedisClient.HDel("relay:config:limitedDomain", domain).Result()
-	}
+	config.Backend.SetDomain(LimitedDomainList, domain, value)
 
 	config.refresh()
 }
 
 func (config *RelayState) refresh() {
 	if config.notifiable {
-		config.RedisClient.Publish("relay_refresh", "Config refreshing request.")
+		config.Backend.Notify()
 	} else {
 		config.Load()
 	}
@@ -175,6 +199,24 @@ type Subscription struct {
 	InboxURL   string `json:"inbox_url,omitempty"`
 	ActivityID string `json:"activity_id,omitempty"`
 	ActorID    string `json:"actor_id,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`
+	// Preferences : Delivery preferences, nil when subscriber receives everything
+	Preferences *Preferences `json:"preferences,omitempty"`
+	// Topics : Topic actors which subscriber follows. Empty when subscriber follows relay actor itself.
+	Topics []string `json:"topics,omitempty"`
+}
+
+// DeliverAnnounce : Check subscriber receives Announce of relayed object instead of activity itself
+func (subscription *Subscription) DeliverAnnounce() bool {
+	if subscription.Preferences != nil && subscription.Preferences.Format != "" {
+		return subscription.Preferences.Format == DeliverAnnounce
+	}
+	return subscription.IsLitePub()
+}
+
+// IsLitePub : Check subscriber uses LitePub style relay protocol
+func (subscription *Subscription) IsLitePub() bool {
+	return subscription.Protocol == LitePubProtocol
 }
 
 type relayConfig struct {
@@ -183,20 +225,8 @@ type relayConfig struct {
 	CreateAsAnnounce bool `json:"createAsAnnounce,omitempty"`
 }
 
-func (config *relayConfig) load(redisClient *redis.Client) {
-	blockService, err := redisClient.HGet("relay:config", "block_service").Result()
-	if err != nil {
-		blockService = "0"
-	}
-	manuallyAccept, err := redisClient.HGet("relay:config", "manually_accept").Result()
-	if err != nil {
-		manuallyAccept = "0"
-	}
-	createAsAnnounce, err := redisClient.HGet("relay:config", "create_as_announce").Result()
-	if err != nil {
-		createAsAnnounce = "0"
-	}
-	config.BlockService = blockService == "1"
-	config.ManuallyAccept = manuallyAccept == "1"
-	config.CreateAsAnnounce = createAsAnnounce == "1"
+func (config *relayConfig) load(backend Backend) {
+	config.BlockService, _ = backend.LoadConfig("block_service")
+	config.ManuallyAccept, _ = backend.LoadConfig("manually_accept")
+	config.CreateAsAnnounce, _ = backend.LoadConfig("create_as_announce")
 }
@@ -2,8 +2,12 @@ package state
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/spf13/viper"
@@ -24,200 +28,216 @@ func TestMain(m *testing.M) {
 		panic(err)
 	}
 	redisClient = redis.NewClient(redisOption)
+	boltListenInterval = 10 * time.Millisecond
 
 	code := m.Run()
 	os.Exit(code)
 	redisClient.FlushAll().Result()
 }
 
-func TestLoadEmpty(t *testing.T) {
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, false)
+// forEachBackend : Run test against every storage backend
+func forEachBackend(t *testing.T, test func(t *testing.T, backend Backend)) {
+	t.Run("redis", func(t *testing.T) {
+		redisClient.FlushAll().Result()
+		test(t, NewRedisBackend(redisClient))
+		redisClient.FlushAll().Result()
+	})
+	t.Run("bolt", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "relay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		backend, err := NewBoltBackend(filepath.Join(dir, "relay.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test(t, backend)
+	})
+}
 
-	if testState.RelayConfig.BlockService != false {
-		t.Fatalf("Failed read config.")
-	}
-	if testState.RelayConfig.CreateAsAnnounce != false {
-		t.Fatalf("Failed read config.")
-	}
-	if testState.RelayConfig.ManuallyAccept != false {
-		t.Fatalf("Failed read config.")
-	}
+func TestLoadEmpty(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
 
-	redisClient.FlushAll().Result()
+		if testState.RelayConfig.BlockService != false {
+			t.Fatalf("Failed read config.")
+		}
+		if testState.RelayConfig.CreateAsAnnounce != false {
+			t.Fatalf("Failed read config.")
+		}
+		if testState.RelayConfig.ManuallyAccept != false {
+			t.Fatalf("Failed read config.")
+		}
+	})
 }
 
 func TestSetConfig(t *testing.T) {
-	ch := make(chan bool)
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, true)
-	testState.ListenNotify(ch)
-
-	testState.SetConfig(BlockService, true)
-	<-ch
-	if testState.RelayConfig.BlockService != true {
-		t.Fatalf("Failed enable config.")
-	}
-	testState.SetConfig(CreateAsAnnounce, true)
-	<-ch
-	if testState.RelayConfig.CreateAsAnnounce != true {
-		t.Fatalf("Failed enable config.")
-	}
-	testState.SetConfig(ManuallyAccept, true)
-	<-ch
-	if testState.RelayConfig.ManuallyAccept != true {
-		t.Fatalf("Failed enable config.")
-	}
-
-	testState.SetConfig(BlockService, false)
-	<-ch
-	if testState.RelayConfig.BlockService != false {
-		t.Fatalf("Failed disable config.")
-	}
-	testState.SetConfig(CreateAsAnnounce, false)
-	<-ch
-	if testState.RelayConfig.CreateAsAnnounce != false {
-		t.Fatalf("Failed disable config.")
-	}
-	testState.SetConfig(ManuallyAccept, false)
-	<-ch
-	if testState.RelayConfig.ManuallyAccept != false {
-		t.Fatalf("Failed disable config.")
-	}
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ch := make(chan bool)
+		testState := NewStateWithBackend(backend, true)
+		testState.ListenNotify(ch)
+
+		testState.SetConfig(BlockService, true)
+		<-ch
+		if testState.RelayConfig.BlockService != true {
+			t.Fatalf("Failed enable config.")
+		}
+		testState.SetConfig(CreateAsAnnounce, true)
+		<-ch
+		if testState.RelayConfig.CreateAsAnnounce != true {
+			t.Fatalf("Failed enable config.")
+		}
+		testState.SetConfig(ManuallyAccept, true)
+		<-ch
+		if testState.RelayConfig.ManuallyAccept != true {
+			t.Fatalf("Failed enable config.")
+		}
 
-	redisClient.FlushAll().Result()
+		testState.SetConfig(BlockService, false)
+		<-ch
+		if testState.RelayConfig.BlockService != false {
+			t.Fatalf("Failed disable config.")
+		}
+		testState.SetConfig(CreateAsAnnounce, false)
+		<-ch
+		if testState.RelayConfig.CreateAsAnnounce != false {
+			t.Fatalf("Failed disable config.")
+		}
+		testState.SetConfig(ManuallyAccept, false)
+		<-ch
+		if testState.RelayConfig.ManuallyAccept != false {
+			t.Fatalf("Failed disable config.")
+		}
+	})
 }
 
 func TestTreatSubscriptionNotify(t *testing.T) {
-	ch := make(chan bool)
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, true)
-	testState.ListenNotify(ch)
-
-	testState.AddSubscription(Subscription{
-		Domain:   "example.com",
-		InboxURL: "https://example.com/inbox",
-	})
-	<-ch
-
-	valid := false
-	for _, domain := range testState.Subscriptions {
-		if domain.Domain == "example.com" && domain.InboxURL == "https://example.com/inbox" {
-			valid = true
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ch := make(chan bool)
+		testState := NewStateWithBackend(backend, true)
+		testState.ListenNotify(ch)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		})
+		<-ch
+
+		valid := false
+		for _, domain := range testState.Subscriptions {
+			if domain.Domain == "example.com" && domain.InboxURL == "https://example.com/inbox" {
+				valid = true
+			}
+		}
+		if !valid {
+			t.Fatalf("Failed write config.")
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
 
-	testState.DelSubscription("example.com")
-	<-ch
+		testState.DelSubscription("example.com")
+		<-ch
 
-	for _, domain := range testState.Subscriptions {
-		if domain.Domain == "example.com" {
-			valid = false
+		for _, domain := range testState.Subscriptions {
+			if domain.Domain == "example.com" {
+				valid = false
+			}
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
-
-	redisClient.FlushAll().Result()
+		if !valid {
+			t.Fatalf("Failed write config.")
+		}
+	})
 }
 
 func TestSelectDomain(t *testing.T) {
-	ch := make(chan bool)
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, true)
-	testState.ListenNotify(ch)
-
-	exampleSubscription := Subscription{
-		Domain:   "example.com",
-		InboxURL: "https://example.com/inbox",
-	}
-
-	testState.AddSubscription(exampleSubscription)
-	<-ch
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ch := make(chan bool)
+		testState := NewStateWithBackend(backend, true)
+		testState.ListenNotify(ch)
+
+		exampleSubscription := Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		}
 
-	subscription := testState.SelectSubscription("example.com")
-	if *subscription != exampleSubscription {
-		t.Fatalf("Failed select domain.")
-	}
+		testState.AddSubscription(exampleSubscription)
+		<-ch
 
-	subscription = testState.SelectSubscription("example.org")
-	if subscription != nil {
-		t.Fatalf("Failed select domain.")
-	}
+		subscription := testState.SelectSubscription("example.com")
+		if !reflect.DeepEqual(*subscription, exampleSubscription) {
+			t.Fatalf("Failed select domain.")
+		}
 
-	redisClient.FlushAll().Result()
+		subscription = testState.SelectSubscription("example.org")
+		if subscription != nil {
+			t.Fatalf("Failed select domain.")
+		}
+	})
 }
 
 func TestBlockedDomain(t *testing.T) {
-	ch := make(chan bool)
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, true)
-	testState.ListenNotify(ch)
-
-	testState.SetBlockedDomain("example.com", true)
-	<-ch
-
-	valid := false
-	for _, domain := range testState.BlockedDomains {
-		if domain == "example.com" {
-			valid = true
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ch := make(chan bool)
+		testState := NewStateWithBackend(backend, true)
+		testState.ListenNotify(ch)
+
+		testState.SetBlockedDomain("example.com", true)
+		<-ch
+
+		valid := false
+		for _, domain := range testState.BlockedDomains {
+			if domain == "example.com" {
+				valid = true
+			}
+		}
+		if !valid {
+			t.Fatalf("Failed write config.")
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
 
-	testState.SetBlockedDomain("example.com", false)
-	<-ch
+		testState.SetBlockedDomain("example.com", false)
+		<-ch
 
-	for _, domain := range testState.BlockedDomains {
-		if domain == "example.com" {
-			valid = false
+		for _, domain := range testState.BlockedDomains {
+			if domain == "example.com" {
+				valid = false
+			}
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
-
-	redisClient.FlushAll().Result()
+		if !valid {
+			t.Fatalf("Failed write config.")
+		}
+	})
 }
 
 func TestLimitedDomain(t *testing.T) {
-	ch := make(chan bool)
-	redisClient.FlushAll().Result()
-	testState := NewState(redisClient, true)
-	testState.ListenNotify(ch)
-
-	testState.SetLimitedDomain("example.com", true)
-	<-ch
-
-	valid := false
-	for _, domain := range testState.LimitedDomains {
-		if domain == "example.com" {
-			valid = true
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		ch := make(chan bool)
+		testState := NewStateWithBackend(backend, true)
+		testState.ListenNotify(ch)
+
+		testState.SetLimitedDomain("example.com", true)
+		<-ch
+
+		valid := false
+		for _, domain := range testState.LimitedDomains {
+			if domain == "example.com" {
+				valid = true
+			}
+		}
+		if !valid {
+			t.Fatalf("Failed write config.")
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
 
-	testState.SetLimitedDomain("example.com", false)
-	<-ch
+		testState.SetLimitedDomain("example.com", false)
+		<-ch
 
-	for _, domain := range testState.LimitedDomains {
-		if domain == "example.com" {
-			valid = false
+		for _, domain := range testState.LimitedDomains {
+			if domain == "example.com" {
+				valid = false
+			}
 		}
-	}
-	if !valid {
-		t.Fatalf("Failed write config.")
-	}
-
-	redisClient.FlushAll().Result()
+		if !valid {
+			t.Fatalf("Failed write config.")
+		}
+	})
 }
 
 func TestLoadCompatiSubscription(t *testing.T) {
@@ -244,3 +264,85 @@ func TestLoadCompatiSubscription(t *testing.T) {
 
 	redisClient.FlushAll().Result()
 }
+
+func TestLoadSubscriptionProtocol(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		testState.AddSubscription(Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+			Protocol: LitePubProtocol,
+		})
+
+		subscription := testState.SelectSubscription("example.com")
+		if subscription == nil || !subscription.IsLitePub() {
+			t.Fatalf("Failed load subscription protocol.")
+		}
+	})
+}
+
+func TestPendingFollow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		exampleFollow := PendingFollow{
+			Domain:     "example.com",
+			InboxURL:   "https://example.com/inbox",
+			ActivityID: "https://example.com/UUID",
+			Type:       "Follow",
+			Actor:      "https://example.com/users/example",
+			Object:     "https://www.w3.org/ns/activitystreams#Public",
+		}
+		testState.AddPendingFollow(exampleFollow)
+
+		follow, err := testState.SelectPendingFollow("example.com")
+		if err != nil || follow == nil || *follow != exampleFollow {
+			t.Fatalf("Failed select pending follow.")
+		}
+		follows, err := testState.ListPendingFollows()
+		if err != nil || len(follows) != 1 || follows[0] != exampleFollow {
+			t.Fatalf("Failed list pending follows.")
+		}
+
+		testState.DelPendingFollow("example.com")
+		follow, err = testState.SelectPendingFollow("example.com")
+		if err != nil || follow != nil {
+			t.Fatalf("Failed delete pending follow.")
+		}
+	})
+}
+
+func TestPeer(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		examplePeer := Peer{
+			Domain:   "relay.example.com",
+			InboxURL: "https://relay.example.com/inbox",
+			ActorID:  "https://relay.example.com/actor",
+			FollowID: "https://relay.yukimochi.example.org/activities/UUID",
+			Status:   PeerPending,
+		}
+		testState.AddPeer(examplePeer)
+
+		peer := testState.SelectPeer("relay.example.com")
+		if peer == nil || *peer != examplePeer {
+			t.Fatalf("Failed select peer.")
+		}
+		if testState.IsAcceptedPeer("relay.example.com") {
+			t.Fatalf("Pending peer should not be accepted.")
+		}
+
+		testState.SetPeerStatus("relay.example.com", PeerAccepted)
+		loadState := NewStateWithBackend(backend, false)
+		if !loadState.IsAcceptedPeer("relay.example.com") {
+			t.Fatalf("Failed set peer status.")
+		}
+
+		testState.DelPeer("relay.example.com")
+		if testState.SelectPeer("relay.example.com") != nil {
+			t.Fatalf("Failed delete peer.")
+		}
+	})
+}
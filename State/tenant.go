@@ -0,0 +1,61 @@
+package state
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// TenantJobHeader : Job header which names tenant of job by relay domain
+const TenantJobHeader = "relay_tenant"
+
+// Tenant : Relay hosted on its own relay domain by shared server and worker
+type Tenant struct {
+	Domain      string `mapstructure:"relay_domain"`
+	ActorPem    string `mapstructure:"actor_pem"`
+	ServiceName string `mapstructure:"relay_servicename"`
+	Summary     string `mapstructure:"relay_summary"`
+	Icon        string `mapstructure:"relay_icon"`
+	Image       string `mapstructure:"relay_image"`
+	// StoragePath : BoltDB file of tenant. Defaults to file named by relay domain.
+	StoragePath string `mapstructure:"storage_path"`
+	// AdminAPIToken : Token used by relay-cli in admin API mode
+	AdminAPIToken string `mapstructure:"admin_api_token"`
+	// Namespace : Prefix of redis keys. Empty for relay configured by top level keys.
+	Namespace string `mapstructure:"-"`
+}
+
+// TenantNamespace : Redis namespace of tenant
+func TenantNamespace(domain string) string {
+	return "tenant:" + domain + ":"
+}
+
+// NormalizeTenants : Make tenant list led by default tenant, which keeps empty namespace for compatibility.
+// Other tenants get namespace and BoltDB file named by their relay domain.
+func NormalizeTenants(defaultTenant Tenant, tenants []Tenant) ([]Tenant, error) {
+	defaultTenant.Namespace = ""
+	normalized := []Tenant{defaultTenant}
+	for _, tenant := range tenants {
+		if tenant.Domain == "" || tenant.ActorPem == "" {
+			return nil, errors.New("Tenant needs relay_domain and actor_pem")
+		}
+		if SelectTenant(normalized, tenant.Domain) != nil {
+			return nil, errors.New("Tenant [" + tenant.Domain + "] is duplicated")
+		}
+		tenant.Namespace = TenantNamespace(tenant.Domain)
+		if tenant.StoragePath == "" {
+			tenant.StoragePath = filepath.Join(filepath.Dir(defaultTenant.StoragePath), tenant.Domain+".db")
+		}
+		normalized = append(normalized, tenant)
+	}
+	return normalized, nil
+}
+
+// SelectTenant : Select tenant by relay domain. Returns nil when not found.
+func SelectTenant(tenants []Tenant, domain string) *Tenant {
+	for _, tenant := range tenants {
+		if tenant.Domain == domain {
+			return &tenant
+		}
+	}
+	return nil
+}
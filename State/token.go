@@ -0,0 +1,79 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+)
+
+const adminTokenKey = "relay:admin:token"
+
+func hashAdminToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateAdminToken : Issue bearer token for admin API. Only hash of token is stored.
+func (config *RelayState) CreateAdminToken(name string) (string, error) {
+	exists, err := config.RedisClient.HExists(config.key(adminTokenKey), name).Result()
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", errors.New("Token [" + name + "] is already exists")
+	}
+	random := make([]byte, 32)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(random)
+	err = config.RedisClient.HSet(config.key(adminTokenKey), name, hashAdminToken(token)).Err()
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyAdminToken : Find name of bearer token. Returns false when token is not issued.
+func (config *RelayState) VerifyAdminToken(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	tokens, err := config.RedisClient.HGetAll(config.key(adminTokenKey)).Result()
+	if err != nil {
+		return "", false
+	}
+	hash := []byte(hashAdminToken(token))
+	for name, storedHash := range tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(storedHash)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// ListAdminTokens : List names of issued tokens
+func (config *RelayState) ListAdminTokens() ([]string, error) {
+	names, err := config.RedisClient.HKeys(config.key(adminTokenKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RevokeAdminToken : Revoke token by name
+func (config *RelayState) RevokeAdminToken(name string) error {
+	deleted, err := config.RedisClient.HDel(config.key(adminTokenKey), name).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return errors.New("Token [" + name + "] is not found")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestAdminToken(t *testing.T) {
+	redisClient.FlushAll().Result()
+	testState := NewState(redisClient, false)
+
+	token, err := testState.CreateAdminToken("moderator")
+	if err != nil || token == "" {
+		t.Fatalf("Failed create admin token.")
+	}
+	_, err = testState.CreateAdminToken("moderator")
+	if err == nil {
+		t.Fatalf("Create admin token with duplicated name.")
+	}
+	if stored, _ := redisClient.HGet(adminTokenKey, "moderator").Result(); stored == token {
+		t.Fatalf("Admin token stored as plain text.")
+	}
+
+	name, ok := testState.VerifyAdminToken(token)
+	if !ok || name != "moderator" {
+		t.Fatalf("Failed verify admin token.")
+	}
+	if _, ok = testState.VerifyAdminToken("invalid"); ok {
+		t.Fatalf("Verify invalid admin token.")
+	}
+
+	names, _ := testState.ListAdminTokens()
+	if len(names) != 1 || names[0] != "moderator" {
+		t.Fatalf("Failed list admin tokens.")
+	}
+
+	testState.RevokeAdminToken("moderator")
+	if _, ok = testState.VerifyAdminToken(token); ok {
+		t.Fatalf("Verify revoked admin token.")
+	}
+	if testState.RevokeAdminToken("moderator") == nil {
+		t.Fatalf("Revoke unknown admin token.")
+	}
+
+	redisClient.FlushAll().Result()
+}
@@ -0,0 +1,172 @@
+package state
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"regexp"
+	"strings"
+
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+)
+
+var topicNamePattern = regexp.MustCompile("^[a-z0-9_-]+(/[a-z0-9_-]+)*$")
+
+// reservedTopicSegments : Path segments used by relay actor itself
+var reservedTopicSegments = map[string]bool{
+	"actor": true, "inbox": true, "api": true, "metrics": true, "nodeinfo": true, "admin": true, "activities": true,
+}
+
+// Topic : Virtual relay actor served under /<name>/actor, which relays objects matched with its criteria
+type Topic struct {
+	Name string `json:"name,omitempty"`
+	// Criteria : Conditions of relayed objects. Format is not used.
+	Criteria   Preferences `json:"criteria"`
+	PrivateKey string      `json:"private_key,omitempty"`
+}
+
+// Username : Preferred username of topic actor
+func (topic *Topic) Username() string {
+	return strings.Replace(topic.Name, "/", "_", -1)
+}
+
+// ValidateTopicName : Check topic name is usable as URL path
+func ValidateTopicName(name string) error {
+	if !topicNamePattern.MatchString(name) {
+		return errors.New("Invalid topic name [" + name + "] given")
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if reservedTopicSegments[segment] {
+			return errors.New("Topic name [" + name + "] uses reserved path")
+		}
+	}
+	return nil
+}
+
+// AddTopic : Add or update topic actor. Keypair is generated for new topic, and kept for existing one.
+func (config *RelayState) AddTopic(topic Topic) error {
+	err := ValidateTopicName(topic.Name)
+	if err != nil {
+		return err
+	}
+	topic.Criteria.Format = ""
+	if topic.PrivateKey == "" {
+		if existing := config.SelectTopic(topic.Name); existing != nil && existing.PrivateKey != "" {
+			topic.PrivateKey = existing.PrivateKey
+		} else {
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				return err
+			}
+			topic.PrivateKey = keyloader.GeneratePrivateKeyPEMString(privateKey)
+		}
+	}
+	err = config.Backend.PutTopic(topic)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
+
+// DelTopic : Delete topic actor. Subscribers of deleted topic receive nothing from it.
+func (config *RelayState) DelTopic(name string) error {
+	err := config.Backend.DelTopic(name)
+	if err != nil {
+		return err
+	}
+	config.refresh()
+	return nil
+}
+
+// SelectTopic : Select topic by name. Returns nil when not found.
+func (config *RelayState) SelectTopic(name string) *Topic {
+	for _, topic := range config.Topics {
+		if name == topic.Name {
+			return &topic
+		}
+	}
+	return nil
+}
+
+// RedactedTopics : Topics without private keys, which are safe to expose
+func (config *RelayState) RedactedTopics() []Topic {
+	topics := []Topic{}
+	for _, topic := range config.Topics {
+		topic.PrivateKey = ""
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Redacted : Copy of state without topic private keys, for export and admin API
+func (config *RelayState) Redacted() *RelayState {
+	redacted := *config
+	redacted.Topics = config.RedactedTopics()
+	return &redacted
+}
+
+// SubscribedTopic : Check subscription receives activity, and select topic which it is relayed by.
+// Topic is nil for subscriber of relay actor itself.
+func (config *RelayState) SubscribedTopic(subscription *Subscription, activityType string, object interface{}) (*Topic, bool) {
+	if len(subscription.Topics) == 0 {
+		return nil, true
+	}
+	for _, name := range subscription.Topics {
+		topic := config.SelectTopic(name)
+		if topic != nil && topic.Criteria.Accept(activityType, object) {
+			return topic, true
+		}
+	}
+	return nil, false
+}
+
+// AddTopicSubscription : Add subscription for topic actor. Empty topic is relay actor itself.
+// Subscriber of relay actor already receives every topic.
+func (config *RelayState) AddTopicSubscription(subscription Subscription, topic string) {
+	subscription.Topics = nil
+	if topic != "" {
+		existing := config.SelectSubscription(subscription.Domain)
+		if existing != nil && len(existing.Topics) == 0 {
+			return
+		}
+		if existing != nil {
+			subscription.Preferences = existing.Preferences
+			for _, name := range existing.Topics {
+				if name != topic {
+					subscription.Topics = append(subscription.Topics, name)
+				}
+			}
+		}
+		subscription.Topics = append(subscription.Topics, topic)
+	}
+	config.AddSubscription(subscription)
+}
+
+// DelTopicSubscription : Delete subscription for topic actor. Empty topic is relay actor itself.
+// Subscription is deleted when no topic is left.
+func (config *RelayState) DelTopicSubscription(domain string, topic string) {
+	subscription := config.SelectSubscription(domain)
+	if subscription == nil {
+		return
+	}
+	if topic == "" || len(subscription.Topics) == 0 {
+		if topic == "" && len(subscription.Topics) == 0 {
+			config.DelSubscription(domain)
+		}
+		return
+	}
+	var topics []string
+	for _, name := range subscription.Topics {
+		if name != topic {
+			topics = append(topics, name)
+		}
+	}
+	if len(topics) == 0 {
+		config.DelSubscription(domain)
+		return
+	}
+	subscription.Topics = topics
+	config.Backend.PutSubscription(*subscription)
+	config.refresh()
+}
@@ -0,0 +1,101 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateTopicName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"tags/art":   true,
+		"lang/ja":    true,
+		"music":      true,
+		"Tags/Art":   false,
+		"tags/":      false,
+		"/tags/art":  false,
+		"actor":      false,
+		"tags/inbox": false,
+	} {
+		if (ValidateTopicName(name) == nil) != valid {
+			t.Fatalf("Failed validate topic name [%s].", name)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+
+		err := testState.AddTopic(Topic{Name: "tags/art", Criteria: Preferences{Hashtags: []string{"art"}, Format: DeliverRaw}})
+		if err != nil {
+			t.Fatalf("Failed add topic.")
+		}
+		topic := testState.SelectTopic("tags/art")
+		if topic == nil || topic.PrivateKey == "" || topic.Criteria.Format != "" || topic.Username() != "tags_art" {
+			t.Fatalf("Failed generate topic.")
+		}
+		privateKey := topic.PrivateKey
+
+		testState.AddTopic(Topic{Name: "tags/art", Criteria: Preferences{Hashtags: []string{"art", "drawing"}}})
+		loadState := NewStateWithBackend(backend, false)
+		topic = loadState.SelectTopic("tags/art")
+		if topic == nil || topic.PrivateKey != privateKey || !reflect.DeepEqual(topic.Criteria.Hashtags, []string{"art", "drawing"}) {
+			t.Fatalf("Failed update topic with keeping keypair.")
+		}
+
+		testState.DelTopic("tags/art")
+		if testState.SelectTopic("tags/art") != nil {
+			t.Fatalf("Failed delete topic.")
+		}
+	})
+}
+
+func TestTopicSubscription(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend Backend) {
+		testState := NewStateWithBackend(backend, false)
+		testState.AddTopic(Topic{Name: "tags/art", Criteria: Preferences{Hashtags: []string{"art"}}, PrivateKey: "-"})
+		testState.AddTopic(Topic{Name: "lang/ja", Criteria: Preferences{Languages: []string{"ja"}}, PrivateKey: "-"})
+		exampleSubscription := Subscription{
+			Domain:   "example.com",
+			InboxURL: "https://example.com/inbox",
+		}
+
+		testState.AddTopicSubscription(exampleSubscription, "tags/art")
+		testState.AddTopicSubscription(exampleSubscription, "lang/ja")
+		subscription := testState.SelectSubscription("example.com")
+		if subscription == nil || !reflect.DeepEqual(subscription.Topics, []string{"tags/art", "lang/ja"}) {
+			t.Fatalf("Failed add topic subscription.")
+		}
+
+		object := map[string]interface{}{"contentMap": map[string]interface{}{"ja": "てすてす"}}
+		topic, subscribed := testState.SubscribedTopic(subscription, "Create", object)
+		if !subscribed || topic.Name != "lang/ja" {
+			t.Fatalf("Failed select subscribed topic.")
+		}
+		if _, subscribed = testState.SubscribedTopic(subscription, "Create", map[string]interface{}{}); subscribed {
+			t.Fatalf("Unmatched object is subscribed.")
+		}
+
+		testState.DelTopicSubscription("example.com", "lang/ja")
+		subscription = testState.SelectSubscription("example.com")
+		if subscription == nil || !reflect.DeepEqual(subscription.Topics, []string{"tags/art"}) {
+			t.Fatalf("Failed delete topic subscription.")
+		}
+		testState.DelTopicSubscription("example.com", "")
+		if testState.SelectSubscription("example.com") == nil {
+			t.Fatalf("Unfollow of relay actor deletes topic subscription.")
+		}
+
+		testState.AddTopicSubscription(exampleSubscription, "")
+		testState.AddTopicSubscription(exampleSubscription, "lang/ja")
+		subscription = testState.SelectSubscription("example.com")
+		if len(subscription.Topics) != 0 {
+			t.Fatalf("Subscriber of relay actor should receive every topic.")
+		}
+		testState.DelTopicSubscription("example.com", "lang/ja")
+		testState.DelTopicSubscription("example.com", "")
+		if testState.SelectSubscription("example.com") != nil {
+			t.Fatalf("Failed delete subscription.")
+		}
+	})
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+const adminAPIPrefix = "/api/v1/"
+
+var errAdminNotFound = errors.New("Not found")
+
+// adminConfigKeys : Relay configs which can be changed by admin API
+var adminConfigKeys = map[string]state.Config{
+	"block_service":      state.BlockService,
+	"manually_accept":    state.ManuallyAccept,
+	"create_as_announce": state.CreateAsAnnounce,
+}
+
+// handleAdminAPI : Route admin API request after bearer token authentication.
+func (tenant *Tenant) handleAdminAPI(writer http.ResponseWriter, request *http.Request) {
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	name, ok := tenant.relayState.VerifyAdminToken(token)
+	if !ok {
+		writeAdminError(writer, 401, errors.New("Invalid bearer token"))
+		return
+	}
+
+	path := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, adminAPIPrefix), "/"), "/")
+	var result interface{}
+	var err error
+	switch path[0] {
+	case "state":
+		result, err = tenant.handleAdminState(request, path[1:])
+	case "config":
+		result, err = tenant.handleAdminConfig(request, path[1:])
+	case "domains":
+		result, err = tenant.handleAdminDomains(request, path[1:])
+	case "subscriptions":
+		result, err = tenant.handleAdminSubscriptions(request, path[1:])
+	case "dropped":
+		if request.Method != "GET" || len(path) != 1 {
+			err = errAdminNotFound
+		} else {
+			result, err = tenant.relayState.ListDroppedSubscriptions()
+		}
+	case "health":
+		result, err = tenant.handleAdminHealth(request, path[1:])
+	case "follows":
+		result, err = tenant.handleAdminFollows(request, path[1:])
+	case "peers":
+		result, err = tenant.handleAdminPeers(request, path[1:])
+	case "filters":
+		result, err = tenant.handleAdminFilters(request, path[1:])
+	case "topics":
+		result, err = tenant.handleAdminTopics(request, path[1:])
+	default:
+		err = errAdminNotFound
+	}
+
+	switch {
+	case err == errAdminNotFound:
+		writeAdminError(writer, 404, err)
+	case err != nil:
+		writeAdminError(writer, 400, err)
+	default:
+		if request.Method != "GET" {
+			logger.WithFields(logger.Fields{"token": name, "method": request.Method, "path": request.URL.Path}).Info("Admin API")
+		}
+		writeAdminResult(writer, result)
+	}
+}
+
+func writeAdminResult(writer http.ResponseWriter, result interface{}) {
+	if result == nil {
+		result = map[string]string{"result": "ok"}
+	}
+	jsonData, _ := json.Marshal(result)
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(jsonData)
+}
+
+func writeAdminError(writer http.ResponseWriter, status int, err error) {
+	jsonData, _ := json.Marshal(map[string]string{"error": err.Error()})
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(jsonData)
+}
+
+func decodeAdminBody(request *http.Request, value interface{}) error {
+	jsonData, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, value)
+}
+
+// handleAdminState : Export or import whole relay state.
+func (tenant *Tenant) handleAdminState(request *http.Request, path []string) (interface{}, error) {
+	if len(path) != 0 {
+		return nil, errAdminNotFound
+	}
+	switch request.Method {
+	case "GET":
+		return tenant.relayState.Redacted(), nil
+	case "POST":
+		var data state.RelayState
+		err := decodeAdminBody(request, &data)
+		if err != nil {
+			return nil, err
+		}
+		if data.RelayConfig.BlockService {
+			tenant.relayState.SetConfig(state.BlockService, true)
+		}
+		if data.RelayConfig.ManuallyAccept {
+			tenant.relayState.SetConfig(state.ManuallyAccept, true)
+		}
+		if data.RelayConfig.CreateAsAnnounce {
+			tenant.relayState.SetConfig(state.CreateAsAnnounce, true)
+		}
+		for _, domain := range data.LimitedDomains {
+			tenant.relayState.SetLimitedDomain(domain, true)
+		}
+		for _, domain := range data.BlockedDomains {
+			tenant.relayState.SetBlockedDomain(domain, true)
+		}
+		for _, subscription := range data.Subscriptions {
+			tenant.relayState.AddSubscription(subscription)
+		}
+		return nil, nil
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminConfig : List or set relay configs.
+func (tenant *Tenant) handleAdminConfig(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		return map[string]bool{
+			"block_service":      tenant.relayState.RelayConfig.BlockService,
+			"manually_accept":    tenant.relayState.RelayConfig.ManuallyAccept,
+			"create_as_announce": tenant.relayState.RelayConfig.CreateAsAnnounce,
+		}, nil
+	case request.Method == "PUT" && len(path) == 1:
+		key, ok := adminConfigKeys[path[0]]
+		if !ok {
+			return nil, errAdminNotFound
+		}
+		var data struct {
+			Value bool `json:"value"`
+		}
+		err := decodeAdminBody(request, &data)
+		if err != nil {
+			return nil, err
+		}
+		tenant.relayState.SetConfig(key, data.Value)
+		return nil, nil
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminDomains : List, set or unset limited and blocked domains.
+func (tenant *Tenant) handleAdminDomains(request *http.Request, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errAdminNotFound
+	}
+	var domains []string
+	var setDomain func(domain string, value bool)
+	switch state.DomainList(path[0]) {
+	case state.LimitedDomainList:
+		domains = tenant.relayState.LimitedDomains
+		setDomain = tenant.relayState.SetLimitedDomain
+	case state.BlockedDomainList:
+		domains = tenant.relayState.BlockedDomains
+		setDomain = tenant.relayState.SetBlockedDomain
+	default:
+		return nil, errAdminNotFound
+	}
+
+	switch {
+	case request.Method == "GET" && len(path) == 1:
+		if domains == nil {
+			domains = []string{}
+		}
+		return domains, nil
+	case request.Method == "PUT" && len(path) == 2:
+		setDomain(path[1], true)
+		return nil, nil
+	case request.Method == "DELETE" && len(path) == 2:
+		setDomain(path[1], false)
+		return nil, nil
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminSubscriptions : List, add, delete or unfollow subscriptions.
+func (tenant *Tenant) handleAdminSubscriptions(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		subscriptions := tenant.relayState.Subscriptions
+		if subscriptions == nil {
+			subscriptions = []state.Subscription{}
+		}
+		return subscriptions, nil
+	case request.Method == "PUT" && len(path) == 1:
+		var subscription state.Subscription
+		err := decodeAdminBody(request, &subscription)
+		if err != nil {
+			return nil, err
+		}
+		subscription.Domain = path[0]
+		tenant.relayState.AddSubscription(subscription)
+		return nil, nil
+	case request.Method == "DELETE" && len(path) == 1:
+		tenant.relayState.DelSubscription(path[0])
+		return nil, nil
+	case request.Method == "POST" && len(path) == 2 && path[1] == "unfollow":
+		subscription := tenant.relayState.SelectSubscription(path[0])
+		if subscription == nil {
+			return nil, errors.New("Invalid domain [" + path[0] + "] given")
+		}
+		object := "https://www.w3.org/ns/activitystreams#Public"
+		if subscription.IsLitePub() {
+			object = tenant.hostURL.String() + "/actor"
+		}
+		activity := activitypub.Activity{
+			Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+			ID:      subscription.ActivityID,
+			Actor:   subscription.ActorID,
+			Type:    "Follow",
+			Object:  object,
+		}
+		resp := activity.GenerateResponse(tenant.hostURL, "Reject")
+		jsonData, _ := json.Marshal(&resp)
+		tenant.pushRegistorJob(subscription.InboxURL, jsonData)
+		tenant.relayState.DelSubscription(subscription.Domain)
+		return nil, nil
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminHealth : Select delivery health of domains, or forget it.
+func (tenant *Tenant) handleAdminHealth(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		healths, err := tenant.relayState.Backend.ListHealth(request.URL.Query()["domain"])
+		if healths == nil {
+			healths = []state.Health{}
+		}
+		return healths, err
+	case request.Method == "DELETE" && len(path) == 1:
+		tenant.relayState.ResetHealth(path[0])
+		return nil, nil
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminFollows : List, select, add, delete, accept or reject pending follow requests.
+func (tenant *Tenant) handleAdminFollows(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		follows, err := tenant.relayState.ListPendingFollows()
+		if follows == nil {
+			follows = []state.PendingFollow{}
+		}
+		return follows, err
+	case request.Method == "GET" && len(path) == 1:
+		follow, err := tenant.relayState.SelectPendingFollow(path[0])
+		if err == nil && follow == nil {
+			err = errAdminNotFound
+		}
+		return follow, err
+	case request.Method == "PUT" && len(path) == 1:
+		var follow state.PendingFollow
+		err := decodeAdminBody(request, &follow)
+		if err != nil {
+			return nil, err
+		}
+		follow.Domain = path[0]
+		return nil, tenant.relayState.AddPendingFollow(follow)
+	case request.Method == "DELETE" && len(path) == 1:
+		return nil, tenant.relayState.DelPendingFollow(path[0])
+	case request.Method == "POST" && len(path) == 2 && (path[1] == "accept" || path[1] == "reject"):
+		response := "Accept"
+		if path[1] == "reject" {
+			response = "Reject"
+		}
+		return nil, tenant.respondPendingFollow(path[0], response)
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminPeers : List, follow, put, delete or unfollow peer relays.
+func (tenant *Tenant) handleAdminPeers(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		peers := tenant.relayState.Peers
+		if peers == nil {
+			peers = []state.Peer{}
+		}
+		return peers, nil
+	case request.Method == "POST" && len(path) == 0:
+		var data struct {
+			Actor string `json:"actor"`
+		}
+		err := decodeAdminBody(request, &data)
+		if err != nil {
+			return nil, err
+		}
+		return tenant.followPeer(data.Actor)
+	case request.Method == "PUT" && len(path) == 1:
+		var peer state.Peer
+		err := decodeAdminBody(request, &peer)
+		if err != nil {
+			return nil, err
+		}
+		peer.Domain = path[0]
+		return nil, tenant.relayState.AddPeer(peer)
+	case request.Method == "DELETE" && len(path) == 1:
+		return nil, tenant.relayState.DelPeer(path[0])
+	case request.Method == "POST" && len(path) == 2 && path[1] == "unfollow":
+		return nil, tenant.unfollowPeer(path[0])
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminFilters : List, put or delete content filter rules, or show their match counts.
+func (tenant *Tenant) handleAdminFilters(request *http.Request, path []string) (interface{}, error) {
+	switch {
+	case request.Method == "GET" && len(path) == 0:
+		rules := tenant.relayState.Filters
+		if rules == nil {
+			rules = []state.FilterRule{}
+		}
+		return rules, nil
+	case request.Method == "GET" && len(path) == 1 && path[0] == "matches":
+		return tenant.relayState.FilterMatches()
+	case request.Method == "PUT" && len(path) == 1:
+		var rule state.FilterRule
+		err := decodeAdminBody(request, &rule)
+		if err != nil {
+			return nil, err
+		}
+		rule.Name = path[0]
+		return nil, tenant.relayState.AddFilter(rule)
+	case request.Method == "DELETE" && len(path) == 1:
+		return nil, tenant.relayState.DelFilter(path[0])
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// handleAdminTopics : List, put or delete topic actors. Topic name may contain slashes, and private keys are not exposed.
+func (tenant *Tenant) handleAdminTopics(request *http.Request, path []string) (interface{}, error) {
+	name := strings.Join(path, "/")
+	switch {
+	case request.Method == "GET" && name == "":
+		return tenant.relayState.RedactedTopics(), nil
+	case request.Method == "PUT" && name != "":
+		var topic state.Topic
+		err := decodeAdminBody(request, &topic)
+		if err != nil {
+			return nil, err
+		}
+		topic.Name = name
+		topic.PrivateKey = ""
+		return nil, tenant.relayState.AddTopic(topic)
+	case request.Method == "DELETE" && name != "":
+		return nil, tenant.relayState.DelTopic(name)
+	default:
+		return nil, errAdminNotFound
+	}
+}
+
+// respondPendingFollow : Send Accept or Reject for pending follow request.
+func (tenant *Tenant) respondPendingFollow(domain string, response string) error {
+	follow, err := tenant.relayState.SelectPendingFollow(domain)
+	if err != nil {
+		return err
+	}
+	if follow == nil {
+		return errors.New("Invalid domain [" + domain + "] given")
+	}
+	activity := activitypub.Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
+		ID:      follow.ActivityID,
+		Actor:   follow.Actor,
+		Type:    follow.Type,
+		Object:  follow.Object,
+	}
+	resp := activity.GenerateResponse(tenant.relayURL(follow.Topic), response)
+	jsonData, err := json.Marshal(&resp)
+	if err != nil {
+		return err
+	}
+	tenant.pushRegistorJob(follow.InboxURL, jsonData)
+	tenant.relayState.DelPendingFollow(domain)
+	if response == "Accept" {
+		protocol := tenant.followProtocol(&activity)
+		if protocol == state.LitePubProtocol {
+			followActor := activitypub.Actor{ID: follow.Actor}
+			followBack := followActor.GenerateFollow(tenant.relayURL(follow.Topic))
+			jsonData, err := json.Marshal(&followBack)
+			if err != nil {
+				return err
+			}
+			tenant.pushRegistorJob(follow.InboxURL, jsonData)
+		}
+		tenant.relayState.AddTopicSubscription(state.Subscription{
+			Domain:     domain,
+			InboxURL:   follow.InboxURL,
+			ActivityID: follow.ActivityID,
+			ActorID:    follow.Actor,
+			Protocol:   protocol,
+		}, follow.Topic)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func adminRequest(t *testing.T, s *httptest.Server, token string, method string, path string, body string) (int, []byte) {
+	req, _ := http.NewRequest(method, s.URL+adminAPIPrefix+path, bytes.NewBufferString(body))
+	if token != "" {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	defer r.Body.Close()
+	data, _ := ioutil.ReadAll(r.Body)
+	return r.StatusCode, data
+}
+
+func TestHandleAdminAPIUnauthorized(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleAdminAPI))
+	defer s.Close()
+
+	status, _ := adminRequest(t, s, "", "GET", "config", "")
+	if status != 401 {
+		t.Fatalf("Failed - Accepted request without token.")
+	}
+	status, _ = adminRequest(t, s, "invalid", "GET", "config", "")
+	if status != 401 {
+		t.Fatalf("Failed - Accepted request with invalid token.")
+	}
+}
+
+func TestHandleAdminAPIDomains(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleAdminAPI))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+
+	status, _ := adminRequest(t, s, token, "PUT", "domains/blockedDomain/example.com", "")
+	if status != 200 {
+		t.Fatalf("Failed - StatusCode is not 200.")
+	}
+	if !contains(defaultTenant.relayState.BlockedDomains, "example.com") {
+		t.Fatalf("Failed - Domain not blocked.")
+	}
+
+	status, data := adminRequest(t, s, token, "GET", "domains/blockedDomain", "")
+	var domains []string
+	json.Unmarshal(data, &domains)
+	if status != 200 || len(domains) != 1 || domains[0] != "example.com" {
+		t.Fatalf("Failed - Blocked domains not listed.")
+	}
+
+	adminRequest(t, s, token, "DELETE", "domains/blockedDomain/example.com", "")
+	if contains(defaultTenant.relayState.BlockedDomains, "example.com") {
+		t.Fatalf("Failed - Domain not unblocked.")
+	}
+
+	status, _ = adminRequest(t, s, token, "GET", "domains/unknownDomain", "")
+	if status != 404 {
+		t.Fatalf("Failed - StatusCode is not 404.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHandleAdminAPIStateRedactsTopicKeys(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleAdminAPI))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "tags/art", Criteria: state.Preferences{Hashtags: []string{"art"}}})
+
+	for _, path := range []string{"state", "topics"} {
+		status, data := adminRequest(t, s, token, "GET", path, "")
+		if status != 200 || !bytes.Contains(data, []byte("tags/art")) || bytes.Contains(data, []byte("PRIVATE KEY")) {
+			t.Fatalf("Failed - Topic private key is exposed by %s.", path)
+		}
+	}
+	if defaultTenant.relayState.SelectTopic("tags/art").PrivateKey == "" {
+		t.Fatalf("Failed - Topic private key is removed from state.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHandleAdminAPIConfig(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleAdminAPI))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+
+	status, _ := adminRequest(t, s, token, "PUT", "config/manually_accept", `{"value":true}`)
+	if status != 200 || !defaultTenant.relayState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Failed - Config not changed.")
+	}
+
+	status, data := adminRequest(t, s, token, "GET", "config", "")
+	var config map[string]bool
+	json.Unmarshal(data, &config)
+	if status != 200 || !config["manually_accept"] {
+		t.Fatalf("Failed - Config not listed.")
+	}
+
+	status, _ = adminRequest(t, s, token, "PUT", "config/unknown", `{"value":true}`)
+	if status != 404 {
+		t.Fatalf("Failed - StatusCode is not 404.")
+	}
+
+	defaultTenant.relayState.SetConfig(ManuallyAccept, false)
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHandleAdminAPIAcceptFollow(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleAdminAPI))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+
+	defaultTenant.relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/user/example",
+		Object:     "https://www.w3.org/ns/activitystreams#Public",
+	})
+
+	status, _ := adminRequest(t, s, token, "POST", "follows/example.com/accept", "")
+	if status != 200 {
+		t.Fatalf("Failed - StatusCode is not 200.")
+	}
+	follow, _ := defaultTenant.relayState.SelectPendingFollow("example.com")
+	if follow != nil {
+		t.Fatalf("Failed - Follow request not removed.")
+	}
+	if defaultTenant.relayState.SelectSubscription("example.com") == nil {
+		t.Fatalf("Failed - Subscription not created.")
+	}
+
+	status, _ = adminRequest(t, s, token, "POST", "follows/unknown.example.com/accept", "")
+	if status != 400 {
+		t.Fatalf("Failed - StatusCode is not 400.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+var errNotSupportedByAPI = errors.New("Not supported in admin API mode")
+
+// apiClient : Client of relay server admin API.
+type apiClient struct {
+	baseURL string
+	token   string
+	// host : Host header which selects tenant. Empty for relay configured by top level keys.
+	host       string
+	httpClient *http.Client
+}
+
+func newAPIClient(baseURL string, token string) *apiClient {
+	return &apiClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/v1/",
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call : Send request to admin API and decode JSON response into result.
+func (client *apiClient) call(method string, path string, body interface{}, result interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(jsonData)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, client.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if client.host != "" {
+		req.Host = client.host
+	}
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiError) == nil && apiError.Error != "" {
+			return errors.New(apiError.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
+
+// apiBackend : Storage backend which operates relay state through admin API.
+// Server notifies state changes to other processes by itself.
+type apiBackend struct {
+	client *apiClient
+}
+
+func (backend *apiBackend) LoadConfig(key string) (bool, error) {
+	var config map[string]bool
+	err := backend.client.call("GET", "config", nil, &config)
+	return config[key], err
+}
+
+func (backend *apiBackend) SaveConfig(key string, value bool) error {
+	return backend.client.call("PUT", "config/"+key, map[string]bool{"value": value}, nil)
+}
+
+func (backend *apiBackend) ListDomains(list state.DomainList) ([]string, error) {
+	var domains []string
+	err := backend.client.call("GET", "domains/"+string(list), nil, &domains)
+	return domains, err
+}
+
+func (backend *apiBackend) SetDomain(list state.DomainList, domain string, value bool) error {
+	if value {
+		return backend.client.call("PUT", "domains/"+string(list)+"/"+url.PathEscape(domain), nil, nil)
+	}
+	return backend.client.call("DELETE", "domains/"+string(list)+"/"+url.PathEscape(domain), nil, nil)
+}
+
+func (backend *apiBackend) ListSubscriptions() ([]state.Subscription, error) {
+	var subscriptions []state.Subscription
+	err := backend.client.call("GET", "subscriptions", nil, &subscriptions)
+	return subscriptions, err
+}
+
+func (backend *apiBackend) PutSubscription(subscription state.Subscription) error {
+	return backend.client.call("PUT", "subscriptions/"+url.PathEscape(subscription.Domain), &subscription, nil)
+}
+
+func (backend *apiBackend) DelSubscription(domain string) error {
+	return backend.client.call("DELETE", "subscriptions/"+url.PathEscape(domain), nil, nil)
+}
+
+func (backend *apiBackend) ListPendingFollows() ([]state.PendingFollow, error) {
+	var follows []state.PendingFollow
+	err := backend.client.call("GET", "follows", nil, &follows)
+	return follows, err
+}
+
+func (backend *apiBackend) SelectPendingFollow(domain string) (*state.PendingFollow, error) {
+	follows, err := backend.ListPendingFollows()
+	if err != nil {
+		return nil, err
+	}
+	for _, follow := range follows {
+		if follow.Domain == domain {
+			return &follow, nil
+		}
+	}
+	return nil, nil
+}
+
+func (backend *apiBackend) PutPendingFollow(follow state.PendingFollow) error {
+	return backend.client.call("PUT", "follows/"+url.PathEscape(follow.Domain), &follow, nil)
+}
+
+func (backend *apiBackend) DelPendingFollow(domain string) error {
+	return backend.client.call("DELETE", "follows/"+url.PathEscape(domain), nil, nil)
+}
+
+func (backend *apiBackend) ListPeers() ([]state.Peer, error) {
+	var peers []state.Peer
+	err := backend.client.call("GET", "peers", nil, &peers)
+	return peers, err
+}
+
+func (backend *apiBackend) PutPeer(peer state.Peer) error {
+	return backend.client.call("PUT", "peers/"+url.PathEscape(peer.Domain), &peer, nil)
+}
+
+func (backend *apiBackend) DelPeer(domain string) error {
+	return backend.client.call("DELETE", "peers/"+url.PathEscape(domain), nil, nil)
+}
+
+func (backend *apiBackend) ListFilters() ([]state.FilterRule, error) {
+	var rules []state.FilterRule
+	err := backend.client.call("GET", "filters", nil, &rules)
+	return rules, err
+}
+
+func (backend *apiBackend) PutFilter(rule state.FilterRule) error {
+	return backend.client.call("PUT", "filters/"+url.PathEscape(rule.Name), &rule, nil)
+}
+
+func (backend *apiBackend) DelFilter(name string) error {
+	return backend.client.call("DELETE", "filters/"+url.PathEscape(name), nil, nil)
+}
+
+func (backend *apiBackend) ListTopics() ([]state.Topic, error) {
+	var topics []state.Topic
+	err := backend.client.call("GET", "topics", nil, &topics)
+	return topics, err
+}
+
+// PutTopic : Private key is kept or generated by server.
+func (backend *apiBackend) PutTopic(topic state.Topic) error {
+	topic.PrivateKey = ""
+	return backend.client.call("PUT", "topics/"+topic.Name, &topic, nil)
+}
+
+func (backend *apiBackend) DelTopic(name string) error {
+	return backend.client.call("DELETE", "topics/"+name, nil, nil)
+}
+
+func (backend *apiBackend) SelectHealth(domain string) (state.Health, error) {
+	healths, err := backend.ListHealth([]string{domain})
+	if err != nil || len(healths) == 0 {
+		return state.Health{Domain: domain}, err
+	}
+	return healths[0], nil
+}
+
+func (backend *apiBackend) ListHealth(domains []string) ([]state.Health, error) {
+	if len(domains) == 0 {
+		return nil, nil
+	}
+	query := url.Values{"domain": domains}
+	var healths []state.Health
+	err := backend.client.call("GET", "health?"+query.Encode(), nil, &healths)
+	return healths, err
+}
+
+func (backend *apiBackend) PutHealth(health state.Health) error {
+	return errNotSupportedByAPI
+}
+
+func (backend *apiBackend) IncrHealthFailure(domain string, at time.Time) (state.Health, error) {
+	return state.Health{Domain: domain}, errNotSupportedByAPI
+}
+
+func (backend *apiBackend) MarkHealthUnreachable(domain string, at time.Time) (bool, error) {
+	return false, errNotSupportedByAPI
+}
+
+func (backend *apiBackend) ResetHealthFailure(domain string, at time.Time) (state.Health, error) {
+	return state.Health{Domain: domain}, errNotSupportedByAPI
+}
+
+func (backend *apiBackend) DelHealth(domain string) error {
+	return backend.client.call("DELETE", "health/"+url.PathEscape(domain), nil, nil)
+}
+
+func (backend *apiBackend) AppendDroppedSubscription(droppedSubscription state.DroppedSubscription) error {
+	return errNotSupportedByAPI
+}
+
+func (backend *apiBackend) ListDroppedSubscriptions() ([]state.DroppedSubscription, error) {
+	var droppedSubscriptions []state.DroppedSubscription
+	err := backend.client.call("GET", "dropped", nil, &droppedSubscriptions)
+	return droppedSubscriptions, err
+}
+
+func (backend *apiBackend) Notify() error {
+	return nil
+}
+
+func (backend *apiBackend) Listen() (<-chan struct{}, error) {
+	return nil, errNotSupportedByAPI
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestAPIMode(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != "Bearer secret" {
+			writer.WriteHeader(401)
+			writer.Write([]byte(`{"error":"Invalid bearer token"}`))
+			return
+		}
+		requests = append(requests, request.Method+" "+request.URL.Path)
+		writer.Header().Add("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(request.URL.Path, "/config"):
+			writer.Write([]byte(`{"block_service":false,"manually_accept":true,"create_as_announce":false}`))
+		case strings.HasSuffix(request.URL.Path, "/domains/blockedDomain"), strings.HasSuffix(request.URL.Path, "/domains/limitedDomain"):
+			writer.Write([]byte(`[]`))
+		case request.Method == "GET":
+			writer.Write([]byte(`[]`))
+		default:
+			writer.Write([]byte(`{"result":"ok"}`))
+		}
+	}))
+	defer s.Close()
+
+	savedState := relayState
+	adminAPI = newAPIClient(s.URL, "secret")
+	relayState = state.NewStateWithBackend(&apiBackend{adminAPI}, false)
+	defer func() {
+		adminAPI = nil
+		relayState = savedState
+	}()
+
+	if !relayState.RelayConfig.ManuallyAccept {
+		t.Fatalf("Failed - Config not loaded through API.")
+	}
+
+	app := buildNewCmd()
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+	app.SetArgs([]string{"domain", "set", "-t", "blocked", "example.com"})
+	app.Execute()
+
+	if !contains(requests, "PUT /api/v1/domains/blockedDomain/example.com") {
+		t.Fatalf("Failed - Domain not blocked through API.")
+	}
+
+	app = buildNewCmd()
+	app.SetOutput(ioutil.Discard)
+	app.SetArgs([]string{"queue", "list"})
+	err := app.Execute()
+	if err != errNotSupportedByAPI {
+		t.Fatalf("Failed - Queue command not rejected in API mode.")
+	}
+}
+
+func TestAPIModeInvalidToken(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(401)
+		writer.Write([]byte(`{"error":"Invalid bearer token"}`))
+	}))
+	defer s.Close()
+
+	backend := &apiBackend{newAPIClient(s.URL, "invalid")}
+	_, err := backend.ListSubscriptions()
+	if err == nil || err.Error() != "Invalid bearer token" {
+		t.Fatalf("Failed - API error not returned.")
+	}
+}
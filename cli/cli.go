@@ -2,7 +2,7 @@ package main
 
 import (
 	"crypto/rsa"
-	"fmt"
+	"errors"
 	"net/url"
 
 	"github.com/RichardKnop/machinery/v1"
@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/viper"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
 	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
@@ -24,60 +25,162 @@ var (
 	hostname        *url.URL
 	hostkey         *rsa.PrivateKey
 	relayState      state.RelayState
+	redisClient     *redis.Client
 	machineryServer *machinery.Server
+	adminAPI        *apiClient
+
+	// tenantConfigs : Hosted tenants, led by relay configured by top level keys
+	tenantConfigs []state.Tenant
 )
 
 func initConfig() {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
+	viper.SetDefault("payload_ttl", "24h")
+	viper.SetDefault("actor_cache_ttl", "1h")
+	viper.SetDefault("actor_cache_negative_ttl", "1h")
+	viper.SetDefault("http_timeout", "5s")
+	viper.SetDefault("http_max_body_size", 1048576)
+	viper.SetDefault("http_max_redirects", 3)
+	viper.SetDefault("storage_backend", "redis")
+	viper.SetDefault("storage_path", "relay.db")
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "text")
 	err := viper.ReadInConfig()
 	if err != nil {
-		fmt.Println("Config file is not exists. Use environment variables.")
+		logger.Info("Config file is not exists. Use environment variables.")
 		viper.BindEnv("actor_pem")
 		viper.BindEnv("redis_url")
 		viper.BindEnv("relay_bind")
 		viper.BindEnv("relay_domain")
 		viper.BindEnv("relay_servicename")
-	} else {
-		Actor.Summary = viper.GetString("relay_summary")
-		Actor.Icon = activitypub.Image{URL: viper.GetString("relay_icon")}
-		Actor.Image = activitypub.Image{URL: viper.GetString("relay_image")}
+		viper.BindEnv("payload_ttl")
+		viper.BindEnv("actor_cache_ttl")
+		viper.BindEnv("actor_cache_negative_ttl")
+		viper.BindEnv("http_timeout")
+		viper.BindEnv("http_max_body_size")
+		viper.BindEnv("http_max_redirects")
+		viper.BindEnv("http_allowed_networks")
+		viper.BindEnv("storage_backend")
+		viper.BindEnv("storage_path")
+		viper.BindEnv("admin_api_url")
+		viper.BindEnv("admin_api_token")
+		viper.BindEnv("log_level")
+		viper.BindEnv("log_format")
 	}
-	Actor.Name = viper.GetString("relay_servicename")
-
-	hostname, err = url.Parse("https://" + viper.GetString("relay_domain"))
+	err = logger.Configure(viper.GetString("log_level"), viper.GetString("log_format"))
 	if err != nil {
 		panic(err)
 	}
-	hostkey, err := keyloader.ReadPrivateKeyRSAfromPath(viper.GetString("actor_pem"))
+	logger.BridgeMachinery()
+
+	err = viper.UnmarshalKey("tenants", &tenantConfigs)
 	if err != nil {
 		panic(err)
 	}
-	redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
+	tenantConfigs, err = state.NormalizeTenants(state.Tenant{
+		Domain:        viper.GetString("relay_domain"),
+		ActorPem:      viper.GetString("actor_pem"),
+		ServiceName:   viper.GetString("relay_servicename"),
+		Summary:       viper.GetString("relay_summary"),
+		Icon:          viper.GetString("relay_icon"),
+		Image:         viper.GetString("relay_image"),
+		StoragePath:   viper.GetString("storage_path"),
+		AdminAPIToken: viper.GetString("admin_api_token"),
+	}, tenantConfigs)
 	if err != nil {
 		panic(err)
 	}
-	redisClient := redis.NewClient(redisOption)
-	relayState = state.NewState(redisClient, false)
-	var machineryConfig = &config.Config{
-		Broker:          viper.GetString("redis_url"),
-		DefaultQueue:    "relay",
-		ResultBackend:   viper.GetString("redis_url"),
-		ResultsExpireIn: 5,
+
+	if viper.GetString("admin_api_url") == "" {
+		redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
+		if err != nil {
+			panic(err)
+		}
+		redisClient = redis.NewClient(redisOption)
+		var machineryConfig = &config.Config{
+			Broker:          viper.GetString("redis_url"),
+			DefaultQueue:    "relay",
+			ResultBackend:   viper.GetString("redis_url"),
+			ResultsExpireIn: 5,
+		}
+		machineryServer, err = machinery.NewServer(machineryConfig)
+		if err != nil {
+			panic(err)
+		}
 	}
-	machineryServer, err = machinery.NewServer(machineryConfig)
+
+	err = loadTenant(tenantConfigs[0])
 	if err != nil {
 		panic(err)
 	}
+}
 
+// loadTenant : Operate relay actor and state of tenant.
+// In admin API mode, requests are sent with Host header of tenant, so server routes them to the tenant.
+func loadTenant(tenant state.Tenant) error {
+	var err error
+	hostname, err = url.Parse("https://" + tenant.Domain)
+	if err != nil {
+		return err
+	}
+	Actor = activitypub.Actor{
+		Name:    tenant.ServiceName,
+		Summary: tenant.Summary,
+		Icon:    activitypub.Image{URL: tenant.Icon},
+		Image:   activitypub.Image{URL: tenant.Image},
+	}
+
+	if viper.GetString("admin_api_url") != "" {
+		adminAPI = newAPIClient(viper.GetString("admin_api_url"), tenant.AdminAPIToken)
+		if tenant.Namespace != "" {
+			adminAPI.host = tenant.Domain
+		}
+		relayState = state.NewStateWithBackend(&apiBackend{adminAPI}, false)
+		return nil
+	}
+
+	hostkey, err = keyloader.ReadPrivateKeyRSAfromPath(tenant.ActorPem)
+	if err != nil {
+		return err
+	}
+	backend, err := state.OpenBackend(viper.GetString("storage_backend"), redisClient, tenant.StoragePath, tenant.Namespace)
+	if err != nil {
+		return err
+	}
+	relayState = state.NewStateWithBackend(backend, false)
+	relayState.RedisClient = redisClient
+	relayState.Namespace = tenant.Namespace
 	Actor.GenerateSelfKey(hostname, &hostkey.PublicKey)
+	return nil
+}
+
+// selectTenant : Switch to tenant given by --tenant flag
+func selectTenant(cmd *cobra.Command, args []string) error {
+	domain, _ := cmd.Flags().GetString("tenant")
+	if domain == "" {
+		return nil
+	}
+	tenant := state.SelectTenant(tenantConfigs, domain)
+	if tenant == nil {
+		return errors.New("Unknown tenant [" + domain + "]")
+	}
+	return loadTenant(*tenant)
 }
 
 func buildNewCmd() *cobra.Command {
-	var app = &cobra.Command{}
+	var app = &cobra.Command{
+		PersistentPreRunE: selectTenant,
+	}
+	app.PersistentFlags().String("tenant", "", "Relay domain of tenant to operate")
 	app.AddCommand(domainCmdInit())
 	app.AddCommand(followCmdInit())
 	app.AddCommand(configCmdInit())
+	app.AddCommand(queueCmdInit())
+	app.AddCommand(tokenCmdInit())
+	app.AddCommand(peerCmdInit())
+	app.AddCommand(filterCmdInit())
+	app.AddCommand(topicCmdInit())
 	return app
 }
 
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/spf13/viper"
+	state "github.com/yukimochi/Activity-Relay/State"
 )
 
 func TestMain(m *testing.M) {
@@ -17,3 +20,57 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 	relayState.RedisClient.FlushAll().Result()
 }
+
+func TestTenantFlag(t *testing.T) {
+	tenantConfigs = append(tenantConfigs, state.Tenant{
+		Domain:    "relay2.yukimochi.example.org",
+		ActorPem:  "../misc/testKey.pem",
+		Namespace: state.TenantNamespace("relay2.yukimochi.example.org"),
+	})
+	defer func() {
+		tenantConfigs = tenantConfigs[:1]
+		loadTenant(tenantConfigs[0])
+	}()
+
+	app := buildNewCmd()
+	app.SetArgs([]string{"--tenant", "relay2.yukimochi.example.org", "domain", "set", "-t", "limited", "limitedDomain.example.jp"})
+	app.Execute()
+
+	if Actor.ID != "https://relay2.yukimochi.example.org/actor" {
+		t.Fatalf("Failed - Tenant actor is not loaded.")
+	}
+	res, _ := relayState.RedisClient.HExists("tenant:relay2.yukimochi.example.org:relay:config:limitedDomain", "limitedDomain.example.jp").Result()
+	if !res {
+		t.Fatalf("Failed - Domain is not limited in tenant namespace.")
+	}
+	res, _ = relayState.RedisClient.HExists("relay:config:limitedDomain", "limitedDomain.example.jp").Result()
+	if res {
+		t.Fatalf("Failed - Domain is limited in default tenant.")
+	}
+
+	app = buildNewCmd()
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+	app.SetArgs([]string{"--tenant", "relay2.yukimochi.example.org", "token", "create", "ops"})
+	app.Execute()
+
+	if _, ok := relayState.VerifyAdminToken(strings.TrimSpace(strings.TrimPrefix(buffer.String(), "Token [ops] : "))); !ok {
+		t.Fatalf("Failed - Token is not created in tenant namespace. " + buffer.String())
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+}
+
+func TestTenantFlagUnknown(t *testing.T) {
+	app := buildNewCmd()
+	app.SetOutput(new(bytes.Buffer))
+	app.SetArgs([]string{"--tenant", "unknown.example.org", "domain", "list"})
+	err := app.Execute()
+
+	if err == nil {
+		t.Fatalf("Failed - Unknown tenant is accepted.")
+	}
+	if Actor.ID != "https://relay.yukimochi.example.org/actor" {
+		t.Fatalf("Failed - Default tenant is replaced.")
+	}
+}
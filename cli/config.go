@@ -2,11 +2,11 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
 
 	"github.com/spf13/cobra"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
@@ -111,25 +111,25 @@ func listConfig(cmd *cobra.Command, args []string) {
 }
 
 func exportConfig(cmd *cobra.Command, args []string) {
-	jsonData, _ := json.Marshal(&relayState)
+	jsonData, _ := json.Marshal(relayState.Redacted())
 	cmd.Println(string(jsonData))
 }
 
 func importConfig(cmd *cobra.Command, args []string) {
 	file, err := os.Open(cmd.Flag("json").Value.String())
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).Error("Failed import config")
 		return
 	}
 	jsonData, err := ioutil.ReadAll(file)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).Error("Failed import config")
 		return
 	}
 	var data state.RelayState
 	err = json.Unmarshal(jsonData, &data)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).Error("Failed import config")
 		return
 	}
 
@@ -159,6 +159,7 @@ func importConfig(cmd *cobra.Command, args []string) {
 			InboxURL:   Subscription.InboxURL,
 			ActivityID: Subscription.ActivityID,
 			ActorID:    Subscription.ActorID,
+			Protocol:   Subscription.Protocol,
 		})
 		cmd.Println("Regist [" + Subscription.Domain + "] as subscriber")
 	}
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/spf13/cobra"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
@@ -22,7 +24,7 @@ func domainCmdInit() *cobra.Command {
 		Long:  "List domain which filtered given type.",
 		RunE:  listDomains,
 	}
-	domainList.Flags().StringP("type", "t", "subscriber", "domain type [subscriber,limited,blocked]")
+	domainList.Flags().StringP("type", "t", "subscriber", "domain type [subscriber,limited,blocked,dropped]")
 	domain.AddCommand(domainList)
 
 	var domainSet = &cobra.Command{
@@ -37,6 +39,20 @@ func domainCmdInit() *cobra.Command {
 	domainSet.Flags().BoolP("undo", "u", false, "Unset domain as limited or blocked")
 	domain.AddCommand(domainSet)
 
+	var domainPreferences = &cobra.Command{
+		Use:   "preferences [flags]",
+		Short: "Show or set delivery preferences of subscriber",
+		Long:  "Show delivery preferences of subscriber, or set them when flags are given.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  setDomainPreferences,
+	}
+	domainPreferences.Flags().StringSliceP("languages", "l", nil, "Deliver only objects in these languages")
+	domainPreferences.Flags().StringSlice("hashtags", nil, "Deliver only objects with these hashtags")
+	domainPreferences.Flags().Bool("exclude-sensitive", false, "Do not deliver sensitive objects")
+	domainPreferences.Flags().StringP("format", "f", "", "Delivery format [announce,raw], protocol default when empty")
+	domainPreferences.Flags().Bool("clear", false, "Clear all preferences")
+	domain.AddCommand(domainPreferences)
+
 	var domainUnfollow = &cobra.Command{
 		Use:   "unfollow [flags]",
 		Short: "Send Unfollow request for given domains",
@@ -49,12 +65,19 @@ func domainCmdInit() *cobra.Command {
 }
 
 func createUnfollowRequestResponse(subscription state.Subscription) error {
+	if adminAPI != nil {
+		return adminAPI.call("POST", "subscriptions/"+url.PathEscape(subscription.Domain)+"/unfollow", nil, nil)
+	}
+	object := "https://www.w3.org/ns/activitystreams#Public"
+	if subscription.IsLitePub() {
+		object = hostname.String() + "/actor"
+	}
 	activity := activitypub.Activity{
 		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
 		ID:      subscription.ActivityID,
 		Actor:   subscription.ActorID,
 		Type:    "Follow",
-		Object:  "https://www.w3.org/ns/activitystreams#Public",
+		Object:  object,
 	}
 
 	resp := activity.GenerateResponse(hostname, "Reject")
@@ -73,11 +96,21 @@ func listDomains(cmd *cobra.Command, args []string) error {
 	case "blocked":
 		cmd.Println(" - Blocked domain :")
 		domains = relayState.BlockedDomains
+	case "dropped":
+		cmd.Println(" - Dropped domain :")
+		droppedSubscriptions, err := relayState.ListDroppedSubscriptions()
+		if err != nil {
+			return err
+		}
+		for _, droppedSubscription := range droppedSubscriptions {
+			domains = append(domains, droppedSubscription.Subscription.Domain+" : dropped at "+droppedSubscription.DroppedAt.Format("2006-01-02T15:04:05Z")+", "+droppedSubscription.Reason)
+		}
 	default:
 		cmd.Println(" - Subscriber domain :")
 		temp := relayState.Subscriptions
 		for _, domain := range temp {
-			domains = append(domains, domain.Domain)
+			health := relayState.SelectHealth(domain.Domain)
+			domains = append(domains, domain.Domain+" : "+describeHealth(health))
 		}
 	}
 	for _, domain := range domains {
@@ -88,6 +121,78 @@ func listDomains(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func describeHealth(health state.Health) string {
+	lastSuccess := "never"
+	if !health.LastSuccess.IsZero() {
+		lastSuccess = health.LastSuccess.Format("2006-01-02T15:04:05Z")
+	}
+	switch health.Status() {
+	case state.UnreachableStatus:
+		return fmt.Sprintf("unreachable since %s (%d failures, last success %s)", health.UnreachableSince.Format("2006-01-02T15:04:05Z"), health.Failures, lastSuccess)
+	case state.DegradedStatus:
+		return fmt.Sprintf("degraded (%d failures since %s, last success %s)", health.Failures, health.FailingSince.Format("2006-01-02T15:04:05Z"), lastSuccess)
+	default:
+		return state.HealthyStatus
+	}
+}
+
+func describePreferences(prefs *state.Preferences) string {
+	if prefs.IsEmpty() {
+		return "everything"
+	}
+	var conditions []string
+	if len(prefs.Languages) > 0 {
+		conditions = append(conditions, "languages "+strings.Join(prefs.Languages, ","))
+	}
+	if len(prefs.Hashtags) > 0 {
+		conditions = append(conditions, "hashtags "+strings.Join(prefs.Hashtags, ","))
+	}
+	if prefs.ExcludeSensitive {
+		conditions = append(conditions, "exclude sensitive")
+	}
+	if prefs.Format != "" {
+		conditions = append(conditions, "format "+prefs.Format)
+	}
+	return strings.Join(conditions, ", ")
+}
+
+func setDomainPreferences(cmd *cobra.Command, args []string) error {
+	subscription := relayState.SelectSubscription(args[0])
+	if subscription == nil {
+		cmd.Println("Invalid domain [" + args[0] + "] given")
+		return nil
+	}
+	if !cmd.Flags().Changed("languages") && !cmd.Flags().Changed("hashtags") && !cmd.Flags().Changed("exclude-sensitive") && !cmd.Flags().Changed("format") && !cmd.Flags().Changed("clear") {
+		cmd.Println(subscription.Domain + " : " + describePreferences(subscription.Preferences))
+		return nil
+	}
+
+	prefs := new(state.Preferences)
+	if subscription.Preferences != nil && !cmd.Flags().Changed("clear") {
+		*prefs = *subscription.Preferences
+	}
+	if cmd.Flags().Changed("languages") {
+		prefs.Languages, _ = cmd.Flags().GetStringSlice("languages")
+	}
+	if cmd.Flags().Changed("hashtags") {
+		prefs.Hashtags, _ = cmd.Flags().GetStringSlice("hashtags")
+	}
+	if cmd.Flags().Changed("exclude-sensitive") {
+		prefs.ExcludeSensitive, _ = cmd.Flags().GetBool("exclude-sensitive")
+	}
+	if cmd.Flags().Changed("format") {
+		prefs.Format = cmd.Flag("format").Value.String()
+	}
+	err := relayState.SetPreferences(subscription.Domain, prefs)
+	if err != nil {
+		cmd.Println(err.Error())
+		return nil
+	}
+	cmd.Println(subscription.Domain + " : " + describePreferences(prefs))
+
+	return nil
+}
+
 func setDomainType(cmd *cobra.Command, args []string) error {
 	undo := cmd.Flag("undo").Value.String() == "true"
 	switch cmd.Flag("type").Value.String() {
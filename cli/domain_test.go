@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"time"
+
+	state "github.com/yukimochi/Activity-Relay/State"
 )
 
 func TestListDomainSubscriber(t *testing.T) {
@@ -20,7 +23,7 @@ func TestListDomainSubscriber(t *testing.T) {
 
 	output := buffer.String()
 	valid := ` - Subscriber domain :
-subscription.example.jp
+subscription.example.jp : healthy
 Total : 1
 `
 	if output != valid {
@@ -236,3 +239,98 @@ func TestInvalidUnfollowDomain(t *testing.T) {
 	relayState.RedisClient.FlushAll().Result()
 	relayState.Load()
 }
+
+func TestListDomainSubscriberHealth(t *testing.T) {
+	app := buildNewCmd()
+
+	app.SetArgs([]string{"config", "import", "--json", "../misc/exampleConfig.json"})
+	app.Execute()
+
+	relayState.RecordDeliveryFailure("subscription.example.jp", 10, time.Hour)
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	app.SetArgs([]string{"domain", "list"})
+	app.Execute()
+
+	output := strings.Split(buffer.String(), "\n")
+	if !strings.HasPrefix(output[1], "subscription.example.jp : degraded (1 failures since ") || !strings.HasSuffix(output[1], "last success never)") {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RecordDeliveryFailure("subscription.example.jp", 2, 0)
+
+	buffer.Reset()
+	app.SetArgs([]string{"domain", "list"})
+	app.Execute()
+
+	output = strings.Split(buffer.String(), "\n")
+	if !strings.HasPrefix(output[1], "subscription.example.jp : unreachable since ") {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestListDomainDropped(t *testing.T) {
+	app := buildNewCmd()
+
+	app.SetArgs([]string{"config", "import", "--json", "../misc/exampleConfig.json"})
+	app.Execute()
+
+	relayState.DropSubscription("subscription.example.jp", "unreachable")
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	app.SetArgs([]string{"domain", "list", "-t", "dropped"})
+	app.Execute()
+
+	output := strings.Split(buffer.String(), "\n")
+	if output[0] != " - Dropped domain :" || !strings.HasPrefix(output[1], "subscription.example.jp : dropped at ") || !strings.HasSuffix(output[1], ", unreachable") {
+		t.Fatalf("Invalid Response.")
+	}
+	if relayState.SelectSubscription("subscription.example.jp") != nil {
+		t.Fatalf("Subscription not dropped.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestDomainPreferences(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	relayState.AddSubscription(state.Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	})
+
+	app.SetArgs([]string{"domain", "preferences", "example.com", "-l", "en,ja", "--exclude-sensitive"})
+	app.Execute()
+	app = buildNewCmd()
+	app.SetArgs([]string{"domain", "preferences", "example.com", "-f", "announce"})
+	app.Execute()
+
+	prefs := relayState.SelectSubscription("example.com").Preferences
+	if prefs == nil || len(prefs.Languages) != 2 || !prefs.ExcludeSensitive || prefs.Format != state.DeliverAnnounce {
+		t.Fatalf("Not set preferences.")
+	}
+
+	app = buildNewCmd()
+	app.SetOutput(buffer)
+	buffer.Reset()
+	app.SetArgs([]string{"domain", "preferences", "example.com", "--clear"})
+	app.Execute()
+	if buffer.String() != "example.com : everything\n" || relayState.SelectSubscription("example.com").Preferences != nil {
+		t.Fatalf("Not cleared preferences.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func filterCmdInit() *cobra.Command {
+	var filter = &cobra.Command{
+		Use:   "filter",
+		Short: "Manage content filter rules",
+		Long:  "List, add and remove content filter rules which match content of relayed objects.",
+	}
+
+	var filterList = &cobra.Command{
+		Use:   "list",
+		Short: "List filter rules",
+		Long:  "List filter rules with match counts.",
+		RunE:  listFilters,
+	}
+	filter.AddCommand(filterList)
+
+	var filterAdd = &cobra.Command{
+		Use:   "add [flags]",
+		Short: "Add or update filter rule",
+		Long:  "Add or update filter rule by name.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  addFilter,
+	}
+	filterAdd.Flags().StringP("field", "f", "", "Matched field ["+strings.Join(state.FilterFields, ",")+"]")
+	filterAdd.MarkFlagRequired("field")
+	filterAdd.Flags().StringP("pattern", "p", "", "Keyword, hashtag, language code or [true,false] for sensitive")
+	filterAdd.Flags().StringP("action", "a", state.FilterDrop, "Action for matched object [drop,cw,relay]")
+	filter.AddCommand(filterAdd)
+
+	var filterRemove = &cobra.Command{
+		Use:   "remove",
+		Short: "Remove filter rule",
+		Long:  "Remove filter rule by name.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  removeFilter,
+	}
+	filter.AddCommand(filterRemove)
+
+	return filter
+}
+
+func filterMatches() (map[string]int64, error) {
+	if adminAPI != nil {
+		var matches map[string]int64
+		err := adminAPI.call("GET", "filters/matches", nil, &matches)
+		return matches, err
+	}
+	return relayState.FilterMatches()
+}
+
+func listFilters(cmd *cobra.Command, args []string) error {
+	matches, err := filterMatches()
+	if err != nil {
+		return err
+	}
+	cmd.Println(" - Filter rules :")
+	for _, rule := range relayState.Filters {
+		cmd.Println(fmt.Sprintf("%s : %s [%s] %s (matches : %d)", rule.Name, rule.Field, rule.Pattern, rule.Action, matches[rule.Name]))
+	}
+	cmd.Println(fmt.Sprintf("Total : %d", len(relayState.Filters)))
+
+	return nil
+}
+
+func addFilter(cmd *cobra.Command, args []string) error {
+	rule := state.FilterRule{
+		Name:    args[0],
+		Field:   cmd.Flag("field").Value.String(),
+		Pattern: cmd.Flag("pattern").Value.String(),
+		Action:  cmd.Flag("action").Value.String(),
+	}
+	err := relayState.AddFilter(rule)
+	if err != nil {
+		cmd.Println(err.Error())
+		return nil
+	}
+	cmd.Println("Set [" + rule.Name + "] filter rule")
+
+	return nil
+}
+
+func removeFilter(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		if relayState.SelectFilter(name) == nil {
+			cmd.Println("Invalid filter [" + name + "] given")
+			continue
+		}
+		err := relayState.DelFilter(name)
+		if err != nil {
+			cmd.Println(err.Error())
+			continue
+		}
+		cmd.Println("Removed [" + name + "] filter rule")
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestAddFilter(t *testing.T) {
+	app := buildNewCmd()
+
+	app.SetArgs([]string{"filter", "add", "spam", "-f", "tag", "-p", "spam", "-a", "drop"})
+	app.Execute()
+
+	rule := relayState.SelectFilter("spam")
+	if rule == nil || *rule != (state.FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: "drop"}) {
+		t.Fatalf("Not added filter rule.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestInvalidFilter(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	app.SetArgs([]string{"filter", "add", "spam", "-f", "author", "-p", "spam"})
+	app.Execute()
+
+	output := buffer.String()
+	if output != "Invalid filter field [author] given\n" {
+		t.Fatalf("Invalid Response.")
+	}
+	if relayState.SelectFilter("spam") != nil {
+		t.Fatalf("Invalid filter rule is added.")
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	relayState.AddFilter(state.FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: "drop"})
+	relayState.CountFilterMatch("spam")
+
+	app.SetArgs([]string{"filter", "list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Filter rules :
+spam : tag [spam] drop (matches : 1)
+Total : 1
+`
+	if output != valid {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestRemoveFilter(t *testing.T) {
+	app := buildNewCmd()
+
+	relayState.AddFilter(state.FilterRule{Name: "spam", Field: "tag", Pattern: "spam", Action: "drop"})
+
+	app.SetArgs([]string{"filter", "remove", "spam"})
+	app.Execute()
+
+	if relayState.SelectFilter("spam") != nil {
+		t.Fatalf("Not removed filter rule.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
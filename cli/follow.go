@@ -2,14 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"net/url"
 	"strings"
 
 	"github.com/RichardKnop/machinery/v1/tasks"
 	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/cobra"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
@@ -61,6 +63,7 @@ func pushRegistorJob(inboxURL string, body []byte) {
 	job := &tasks.Signature{
 		Name:       "registor",
 		RetryCount: 25,
+		Headers:    tasks.Headers{state.TenantJobHeader: hostname.Host},
 		Args: []tasks.Arg{
 			{
 				Name:  "inboxURL",
@@ -76,37 +79,55 @@ func pushRegistorJob(inboxURL string, body []byte) {
 	}
 	_, err := machineryServer.SendTask(job)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).WithField(logger.Inbox, inboxURL).Error("Failed enqueue registor job")
 	}
 }
 
 func createFollowRequestResponse(domain string, response string) error {
-	data, err := relayState.RedisClient.HGetAll("relay:pending:" + domain).Result()
+	if adminAPI != nil {
+		return adminAPI.call("POST", "follows/"+url.PathEscape(domain)+"/"+strings.ToLower(response), nil, nil)
+	}
+	follow, err := relayState.SelectPendingFollow(domain)
 	if err != nil {
 		return err
 	}
+	if follow == nil {
+		return errors.New("Follow request [" + domain + "] is not found")
+	}
 	activity := activitypub.Activity{
 		Context: []string{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/v1"},
-		ID:      data["activity_id"],
-		Actor:   data["actor"],
-		Type:    data["type"],
-		Object:  data["object"],
+		ID:      follow.ActivityID,
+		Actor:   follow.Actor,
+		Type:    follow.Type,
+		Object:  follow.Object,
 	}
 
-	resp := activity.GenerateResponse(hostname, response)
+	resp := activity.GenerateResponse(relayURL(follow.Topic), response)
 	jsonData, err := json.Marshal(&resp)
 	if err != nil {
 		return err
 	}
-	pushRegistorJob(data["inbox_url"], jsonData)
-	relayState.RedisClient.Del("relay:pending:" + domain)
+	pushRegistorJob(follow.InboxURL, jsonData)
+	relayState.DelPendingFollow(domain)
 	if response == "Accept" {
-		relayState.AddSubscription(state.Subscription{
+		protocol := state.MastodonProtocol
+		if follow.Object == relayURL(follow.Topic).String()+"/actor" {
+			protocol = state.LitePubProtocol
+			followActor := activitypub.Actor{ID: follow.Actor}
+			followBack := followActor.GenerateFollow(relayURL(follow.Topic))
+			jsonData, err := json.Marshal(&followBack)
+			if err != nil {
+				return err
+			}
+			pushRegistorJob(follow.InboxURL, jsonData)
+		}
+		relayState.AddTopicSubscription(state.Subscription{
 			Domain:     domain,
-			InboxURL:   data["inbox_url"],
-			ActivityID: data["activity_id"],
-			ActorID:    data["actor"],
-		})
+			InboxURL:   follow.InboxURL,
+			ActivityID: follow.ActivityID,
+			ActorID:    follow.Actor,
+			Protocol:   protocol,
+		}, follow.Topic)
 	}
 
 	return nil
@@ -134,12 +155,12 @@ func createUpdateActorActivity(subscription state.Subscription) error {
 func listFollows(cmd *cobra.Command, args []string) error {
 	var domains []string
 	cmd.Println(" - Follow request :")
-	follows, err := relayState.RedisClient.Keys("relay:pending:*").Result()
+	follows, err := relayState.ListPendingFollows()
 	if err != nil {
 		return err
 	}
 	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+		domains = append(domains, follow.Domain)
 	}
 	for _, domain := range domains {
 		cmd.Println(domain)
@@ -152,12 +173,12 @@ func listFollows(cmd *cobra.Command, args []string) error {
 func acceptFollow(cmd *cobra.Command, args []string) error {
 	var err error
 	var domains []string
-	follows, err := relayState.RedisClient.Keys("relay:pending:*").Result()
+	follows, err := relayState.ListPendingFollows()
 	if err != nil {
 		return err
 	}
 	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+		domains = append(domains, follow.Domain)
 	}
 
 	for _, domain := range args {
@@ -175,12 +196,12 @@ func acceptFollow(cmd *cobra.Command, args []string) error {
 func rejectFollow(cmd *cobra.Command, args []string) error {
 	var err error
 	var domains []string
-	follows, err := relayState.RedisClient.Keys("relay:pending:*").Result()
+	follows, err := relayState.ListPendingFollows()
 	if err != nil {
 		return err
 	}
 	for _, follow := range follows {
-		domains = append(domains, strings.Replace(follow, "relay:pending:", "", 1))
+		domains = append(domains, follow.Domain)
 	}
 
 	for _, domain := range args {
@@ -198,6 +219,9 @@ func rejectFollow(cmd *cobra.Command, args []string) error {
 }
 
 func updateActor(cmd *cobra.Command, args []string) error {
+	if adminAPI != nil {
+		return errNotSupportedByAPI
+	}
 	for _, subscription := range relayState.Subscriptions {
 		err := createUpdateActorActivity(subscription)
 		if err != nil {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
 )
 
 func TestListFollows(t *testing.T) {
@@ -12,12 +14,13 @@ func TestListFollows(t *testing.T) {
 	buffer := new(bytes.Buffer)
 	app.SetOutput(buffer)
 
-	relayState.RedisClient.HMSet("relay:pending:example.com", map[string]interface{}{
-		"inbox_url":   "https://example.com/inbox",
-		"activity_id": "https://example.com/UUID",
-		"type":        "Follow",
-		"actor":       "https://example.com/user/example",
-		"object":      "https://" + hostname.Host + "/actor",
+	relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/user/example",
+		Object:     "https://" + hostname.Host + "/actor",
 	})
 
 	app.SetArgs([]string{"follow", "list"})
@@ -39,12 +42,13 @@ Total : 1
 func TestAcceptFollow(t *testing.T) {
 	app := buildNewCmd()
 
-	relayState.RedisClient.HMSet("relay:pending:example.com", map[string]interface{}{
-		"inbox_url":   "https://example.com/inbox",
-		"activity_id": "https://example.com/UUID",
-		"type":        "Follow",
-		"actor":       "https://example.com/user/example",
-		"object":      "https://" + hostname.Host + "/actor",
+	relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/user/example",
+		Object:     "https://" + hostname.Host + "/actor",
 	})
 
 	app.SetArgs([]string{"follow", "accept", "example.com"})
@@ -64,15 +68,40 @@ func TestAcceptFollow(t *testing.T) {
 	relayState.Load()
 }
 
+func TestAcceptLitePubFollow(t *testing.T) {
+	app := buildNewCmd()
+
+	relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/relay",
+		Object:     "https://" + hostname.Host + "/actor",
+	})
+
+	app.SetArgs([]string{"follow", "accept", "example.com"})
+	app.Execute()
+
+	valid, _ := relayState.RedisClient.HGet("relay:subscription:example.com", "protocol").Result()
+	if valid != state.LitePubProtocol {
+		t.Fatalf("Not created LitePub subscription.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
 func TestRejectFollow(t *testing.T) {
 	app := buildNewCmd()
 
-	relayState.RedisClient.HMSet("relay:pending:example.com", map[string]interface{}{
-		"inbox_url":   "https://example.com/inbox",
-		"activity_id": "https://example.com/UUID",
-		"type":        "Follow",
-		"actor":       "https://example.com/user/example",
-		"object":      "https://" + hostname.Host + "/actor",
+	relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/user/example",
+		Object:     "https://" + hostname.Host + "/actor",
 	})
 
 	app.SetArgs([]string{"follow", "reject", "example.com"})
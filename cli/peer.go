@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	actorcache "github.com/yukimochi/Activity-Relay/ActorCache"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+	httpsignature "github.com/yukimochi/Activity-Relay/HTTPSignature"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func peerCmdInit() *cobra.Command {
+	var peer = &cobra.Command{
+		Use:   "peer",
+		Short: "Manage peer relays",
+		Long:  "List, add and remove peer relays. Relay follows actor of peer relay to receive its activities.",
+	}
+
+	var peerList = &cobra.Command{
+		Use:   "list",
+		Short: "List peer relays",
+		Long:  "List peer relays with follow status.",
+		RunE:  listPeers,
+	}
+	peer.AddCommand(peerList)
+
+	var peerAdd = &cobra.Command{
+		Use:   "add",
+		Short: "Add peer relay",
+		Long:  "Send follow request to actor of peer relay.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  addPeer,
+	}
+	peer.AddCommand(peerAdd)
+
+	var peerRemove = &cobra.Command{
+		Use:   "remove",
+		Short: "Remove peer relay",
+		Long:  "Unfollow peer relay by domain.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  removePeer,
+	}
+	peer.AddCommand(peerRemove)
+
+	return peer
+}
+
+// signFetch : Sign GET request by relay actor key
+func signFetch(request *http.Request) error {
+	return httpsignature.Sign(request, nil, Actor.PublicKey.ID, hostkey, httpsignature.Cavage)
+}
+
+func createPeerFollow(actorURL string) (*state.Peer, error) {
+	if adminAPI != nil {
+		var peer state.Peer
+		err := adminAPI.call("POST", "peers", map[string]string{"actor": actorURL}, &peer)
+		return &peer, err
+	}
+	var peerActor activitypub.Actor
+	uaString := fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", viper.GetString("relay_servicename"), version, hostname.Host)
+	// Actor cache is shared with server through Redis.
+	actorCache := actorcache.New(1, redisClient, viper.GetDuration("actor_cache_ttl"), viper.GetDuration("actor_cache_negative_ttl"))
+	client, err := httpclient.FromConfig()
+	if err != nil {
+		return nil, err
+	}
+	actorCache.Client = client
+	_, err = peerActor.RetrieveRemoteActor(actorURL, uaString, actorCache, signFetch)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := url.Parse(peerActor.ID)
+	if err != nil || domain.Host == "" {
+		return nil, errors.New("Invalid actor [" + actorURL + "] given")
+	}
+	if domain.Host == hostname.Host {
+		return nil, errors.New("Relay can not peer with itself")
+	}
+	inboxURL := peerActor.Inbox
+	if peerActor.Endpoints.SharedInbox != "" {
+		inboxURL = peerActor.Endpoints.SharedInbox
+	}
+
+	follow := peerActor.GenerateFollow(hostname)
+	jsonData, err := json.Marshal(&follow)
+	if err != nil {
+		return nil, err
+	}
+	pushRegistorJob(inboxURL, jsonData)
+	peer := state.Peer{
+		Domain:   domain.Host,
+		InboxURL: inboxURL,
+		ActorID:  peerActor.ID,
+		FollowID: follow.ID,
+		Status:   state.PeerPending,
+	}
+	return &peer, relayState.AddPeer(peer)
+}
+
+func createPeerUnfollow(domain string) error {
+	if adminAPI != nil {
+		return adminAPI.call("POST", "peers/"+url.PathEscape(domain)+"/unfollow", nil, nil)
+	}
+	peer := relayState.SelectPeer(domain)
+	if peer == nil {
+		return errors.New("Invalid domain [" + domain + "] given")
+	}
+	follow := activitypub.Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams"},
+		ID:      peer.FollowID,
+		Actor:   hostname.String() + "/actor",
+		Type:    "Follow",
+		Object:  peer.ActorID,
+	}
+	undo := follow.GenerateResponse(hostname, "Undo")
+	jsonData, err := json.Marshal(&undo)
+	if err != nil {
+		return err
+	}
+	pushRegistorJob(peer.InboxURL, jsonData)
+	return relayState.DelPeer(domain)
+}
+
+func listPeers(cmd *cobra.Command, args []string) error {
+	cmd.Println(" - Peer relays :")
+	for _, peer := range relayState.Peers {
+		cmd.Println(peer.Domain + " (" + peer.Status + ")")
+	}
+	cmd.Println(fmt.Sprintf("Total : %d", len(relayState.Peers)))
+
+	return nil
+}
+
+func addPeer(cmd *cobra.Command, args []string) error {
+	for _, actorURL := range args {
+		peer, err := createPeerFollow(actorURL)
+		if err != nil {
+			cmd.Println("Failed follow [" + actorURL + "] : " + err.Error())
+			continue
+		}
+		cmd.Println("Follow request sent to [" + peer.Domain + "]")
+	}
+
+	return nil
+}
+
+func removePeer(cmd *cobra.Command, args []string) error {
+	for _, domain := range args {
+		err := createPeerUnfollow(domain)
+		if err != nil {
+			cmd.Println(err.Error())
+			continue
+		}
+		cmd.Println("Unfollowed [" + domain + "]")
+	}
+
+	return nil
+}
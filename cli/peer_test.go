@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestListPeers(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	relayState.AddPeer(state.Peer{
+		Domain:   "relay.example.com",
+		InboxURL: "https://relay.example.com/inbox",
+		ActorID:  "https://relay.example.com/actor",
+		FollowID: "https://" + hostname.Host + "/activities/UUID",
+		Status:   state.PeerAccepted,
+	})
+
+	app.SetArgs([]string{"peer", "list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Peer relays :
+relay.example.com (accepted)
+Total : 1
+`
+	if output != valid {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestRemovePeer(t *testing.T) {
+	app := buildNewCmd()
+
+	relayState.AddPeer(state.Peer{
+		Domain:   "relay.example.com",
+		InboxURL: "https://relay.example.com/inbox",
+		ActorID:  "https://relay.example.com/actor",
+		FollowID: "https://" + hostname.Host + "/activities/UUID",
+		Status:   state.PeerAccepted,
+	})
+
+	app.SetArgs([]string{"peer", "remove", "relay.example.com"})
+	app.Execute()
+
+	valid, _ := relayState.RedisClient.Exists("relay:peer:relay.example.com").Result()
+	if valid != 0 {
+		t.Fatalf("Not removed peer.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
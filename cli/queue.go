@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func queueCmdInit() *cobra.Command {
+	var queue = &cobra.Command{
+		Use:               "queue",
+		Short:             "Manage dead-letter queue",
+		Long:              "List, inspect and replay relay jobs which exhausted their retries.",
+		PersistentPreRunE: requireRedis,
+	}
+
+	var queueList = &cobra.Command{
+		Use:   "list",
+		Short: "List dead-letter jobs",
+		Long:  "List relay jobs in dead-letter queue.",
+		RunE:  listDeadLetters,
+	}
+	queue.AddCommand(queueList)
+
+	var queueInspect = &cobra.Command{
+		Use:   "inspect",
+		Short: "Inspect dead-letter job",
+		Long:  "Show detail of relay job in dead-letter queue by job ID.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  inspectDeadLetter,
+	}
+	queue.AddCommand(queueInspect)
+
+	var queueReplay = &cobra.Command{
+		Use:   "replay [flags]",
+		Short: "Replay dead-letter jobs",
+		Long:  "Enqueue relay jobs in dead-letter queue again by job ID.",
+		RunE:  replayDeadLetters,
+	}
+	queueReplay.Flags().BoolP("all", "a", false, "Replay all jobs in dead-letter queue")
+	queue.AddCommand(queueReplay)
+
+	return queue
+}
+
+func pushRelayJob(tenant string, inboxURL string, body string) error {
+	payloadKey, err := state.StorePayload(relayState.RedisClient, []byte(body), viper.GetDuration("payload_ttl"))
+	if err != nil {
+		return err
+	}
+	job := &tasks.Signature{
+		Name:       "relay",
+		RetryCount: 0,
+		Headers:    tasks.Headers{state.TenantJobHeader: tenant},
+		Args: []tasks.Arg{
+			{
+				Name:  "inboxURL",
+				Type:  "string",
+				Value: inboxURL,
+			},
+			{
+				Name:  "payloadKey",
+				Type:  "string",
+				Value: payloadKey,
+			},
+		},
+	}
+	_, err = machineryServer.SendTask(job)
+	return err
+}
+
+// deadLetterReason : Reason of dead-letter job. Jobs moved before reasons were recorded exhausted retries.
+func deadLetterReason(deadLetter *state.DeadLetter) string {
+	if deadLetter.Reason == "" {
+		return state.RetryExhaustedReason
+	}
+	return deadLetter.Reason
+}
+
+func listDeadLetters(cmd *cobra.Command, args []string) error {
+	deadLetters, err := state.ListDeadLetters(relayState.RedisClient)
+	if err != nil {
+		return err
+	}
+	cmd.Println(" - Dead-letter job :")
+	for _, deadLetter := range deadLetters {
+		cmd.Println(fmt.Sprintf("%s %s %s (%d attempts, %s) : %s", deadLetter.ID, deadLetter.FailedAt.Format("2006-01-02T15:04:05Z"), deadLetter.InboxURL, deadLetter.Attempts, deadLetterReason(&deadLetter), deadLetter.Error))
+	}
+	cmd.Println(fmt.Sprintf("Total : %d", len(deadLetters)))
+
+	return nil
+}
+
+func inspectDeadLetter(cmd *cobra.Command, args []string) error {
+	deadLetter, err := state.SelectDeadLetter(relayState.RedisClient, args[0])
+	if err != nil {
+		cmd.Println(err.Error())
+		return nil
+	}
+	cmd.Println("ID : " + deadLetter.ID)
+	if deadLetter.Tenant != "" {
+		cmd.Println("Tenant : " + deadLetter.Tenant)
+	}
+	cmd.Println("Inbox URL : " + deadLetter.InboxURL)
+	cmd.Println(fmt.Sprintf("Attempts : %d", deadLetter.Attempts))
+	cmd.Println("First Attempt : " + deadLetter.FirstAttempt.Format("2006-01-02T15:04:05Z"))
+	cmd.Println("Failed At : " + deadLetter.FailedAt.Format("2006-01-02T15:04:05Z"))
+	cmd.Println("Reason : " + deadLetterReason(deadLetter))
+	cmd.Println("Error : " + deadLetter.Error)
+	cmd.Println("Body : " + deadLetter.Body)
+
+	return nil
+}
+
+func replayDeadLetters(cmd *cobra.Command, args []string) error {
+	ids := args
+	if cmd.Flag("all").Value.String() == "true" {
+		deadLetters, err := state.ListDeadLetters(relayState.RedisClient)
+		if err != nil {
+			return err
+		}
+		ids = nil
+		for _, deadLetter := range deadLetters {
+			ids = append(ids, deadLetter.ID)
+		}
+	}
+
+	for _, id := range ids {
+		deadLetter, err := state.SelectDeadLetter(relayState.RedisClient, id)
+		if err != nil {
+			cmd.Println("Invalid job [" + id + "] given")
+			continue
+		}
+		if deadLetter.Body == "" {
+			cmd.Println("Job [" + id + "] has no payload to replay")
+			continue
+		}
+		err = pushRelayJob(deadLetter.Tenant, deadLetter.InboxURL, deadLetter.Body)
+		if err != nil {
+			cmd.Println("Failed replay job [" + id + "] : " + err.Error())
+			continue
+		}
+		state.DelDeadLetter(relayState.RedisClient, id)
+		cmd.Println("Replay job [" + id + "] for " + deadLetter.InboxURL)
+	}
+
+	return nil
+}
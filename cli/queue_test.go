@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func mockDeadLetter(id string) {
+	state.PushDeadLetter(relayState.RedisClient, state.DeadLetter{
+		ID:           id,
+		InboxURL:     "https://example.com/inbox",
+		Body:         "data",
+		Error:        "Post https://example.com/inbox: 503 Service Unavailable",
+		Attempts:     8,
+		FirstAttempt: time.Now().Add(-time.Hour).UTC(),
+		FailedAt:     time.Now().UTC(),
+	})
+}
+
+func TestListDeadLetters(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	mockDeadLetter("task_example")
+
+	app.SetArgs([]string{"queue", "list"})
+	app.Execute()
+
+	output := strings.Split(buffer.String(), "\n")
+	if output[0] != " - Dead-letter job :" || !strings.HasPrefix(output[1], "task_example ") || output[2] != "Total : 1" {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestInspectDeadLetter(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	mockDeadLetter("task_example")
+
+	app.SetArgs([]string{"queue", "inspect", "task_example"})
+	app.Execute()
+
+	output := buffer.String()
+	if !strings.Contains(output, "Inbox URL : https://example.com/inbox") || !strings.Contains(output, "Body : data") {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	app := buildNewCmd()
+
+	mockDeadLetter("task_example")
+	mockDeadLetter("task_example2")
+
+	app.SetArgs([]string{"queue", "replay", "task_example"})
+	app.Execute()
+
+	deadLetters, _ := state.ListDeadLetters(relayState.RedisClient)
+	if len(deadLetters) != 1 || deadLetters[0].ID != "task_example2" {
+		t.Fatalf("Not replayed dead-letter job.")
+	}
+
+	app.SetArgs([]string{"queue", "replay", "--all"})
+	app.Execute()
+
+	deadLetters, _ = state.ListDeadLetters(relayState.RedisClient)
+	if len(deadLetters) != 0 {
+		t.Fatalf("Not replayed all dead-letter jobs.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestReplayInvalidDeadLetter(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	app.SetArgs([]string{"queue", "replay", "unknown"})
+	app.Execute()
+
+	output := buffer.String()
+	if strings.Split(output, "\n")[0] != "Invalid job [unknown] given" {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestReplayExpiredDeadLetter(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	state.PushDeadLetter(relayState.RedisClient, state.DeadLetter{
+		ID:       "task_expired",
+		InboxURL: "https://example.com/inbox",
+		Error:    "Payload is expired or not found",
+		Reason:   state.PayloadExpiredReason,
+	})
+	app.SetArgs([]string{"queue", "replay", "task_expired"})
+	app.Execute()
+
+	output := buffer.String()
+	if strings.Split(output, "\n")[0] != "Job [task_expired] has no payload to replay" {
+		t.Fatalf("Invalid Response.")
+	}
+	if _, err := state.SelectDeadLetter(relayState.RedisClient, "task_expired"); err != nil {
+		t.Fatalf("Dead-letter job without payload is removed.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+func tokenCmdInit() *cobra.Command {
+	var token = &cobra.Command{
+		Use:               "token",
+		Short:             "Manage admin API token",
+		Long:              "Create, list and revoke bearer token for admin API.",
+		PersistentPreRunE: requireRedis,
+	}
+
+	var tokenCreate = &cobra.Command{
+		Use:   "create",
+		Short: "Create admin API token",
+		Long:  "Create bearer token for admin API with given name. Token is shown only once.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  createToken,
+	}
+	token.AddCommand(tokenCreate)
+
+	var tokenList = &cobra.Command{
+		Use:   "list",
+		Short: "List admin API token",
+		Long:  "List names of admin API token.",
+		RunE:  listTokens,
+	}
+	token.AddCommand(tokenList)
+
+	var tokenRevoke = &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke admin API token",
+		Long:  "Revoke admin API token by name.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  revokeTokens,
+	}
+	token.AddCommand(tokenRevoke)
+
+	return token
+}
+
+// requireRedis : Reject command which operates redis directly in admin API mode.
+func requireRedis(cmd *cobra.Command, args []string) error {
+	err := selectTenant(cmd, args)
+	if err != nil {
+		return err
+	}
+	if adminAPI != nil {
+		return errNotSupportedByAPI
+	}
+	return nil
+}
+
+func createToken(cmd *cobra.Command, args []string) error {
+	token, err := relayState.CreateAdminToken(args[0])
+	if err != nil {
+		cmd.Println(err.Error())
+		return nil
+	}
+	cmd.Println("Token [" + args[0] + "] : " + token)
+
+	return nil
+}
+
+func listTokens(cmd *cobra.Command, args []string) error {
+	names, err := relayState.ListAdminTokens()
+	if err != nil {
+		return err
+	}
+	cmd.Println(" - Admin API token :")
+	for _, name := range names {
+		cmd.Println(name)
+	}
+	cmd.Println(fmt.Sprintf("Total : %d", len(names)))
+
+	return nil
+}
+
+func revokeTokens(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		err := relayState.RevokeAdminToken(name)
+		if err != nil {
+			cmd.Println(err.Error())
+			continue
+		}
+		cmd.Println("Revoke token [" + name + "]")
+	}
+
+	return nil
+}
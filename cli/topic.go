@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func topicCmdInit() *cobra.Command {
+	var topic = &cobra.Command{
+		Use:   "topic",
+		Short: "Manage topic relay actors",
+		Long:  "List, add and remove topic relay actors. Each topic actor has own keypair and followers, and relays objects matched with its criteria.",
+	}
+
+	var topicList = &cobra.Command{
+		Use:   "list",
+		Short: "List topic actors",
+		Long:  "List topic actors with criteria and followers.",
+		RunE:  listTopics,
+	}
+	topic.AddCommand(topicList)
+
+	var topicAdd = &cobra.Command{
+		Use:   "add [flags]",
+		Short: "Add or update topic actor",
+		Long:  "Add or update topic actor served under /<name>/actor. Without criteria flags, tags/<hashtag> and lang/<language> names make criteria by themselves.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  addTopic,
+	}
+	topicAdd.Flags().StringSliceP("languages", "l", nil, "Relay only objects in these languages")
+	topicAdd.Flags().StringSlice("hashtags", nil, "Relay only objects with these hashtags")
+	topicAdd.Flags().Bool("exclude-sensitive", false, "Do not relay sensitive objects")
+	topic.AddCommand(topicAdd)
+
+	var topicRemove = &cobra.Command{
+		Use:   "remove",
+		Short: "Remove topic actor",
+		Long:  "Remove topic actor by name.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  removeTopic,
+	}
+	topic.AddCommand(topicRemove)
+
+	return topic
+}
+
+// relayURL : Base URL of relay actor. Empty topic is relay actor itself.
+func relayURL(topic string) *url.URL {
+	if topic == "" {
+		return hostname
+	}
+	base := *hostname
+	base.Path = "/" + topic
+	return &base
+}
+
+func listTopics(cmd *cobra.Command, args []string) error {
+	cmd.Println(" - Topic actors :")
+	for _, topic := range relayState.Topics {
+		followers := 0
+		for _, subscription := range relayState.Subscriptions {
+			if contains(subscription.Topics, topic.Name) {
+				followers++
+			}
+		}
+		cmd.Println(fmt.Sprintf("%s : %s, %d followers", topic.Name, describePreferences(&topic.Criteria), followers))
+	}
+	cmd.Println(fmt.Sprintf("Total : %d", len(relayState.Topics)))
+
+	return nil
+}
+
+func addTopic(cmd *cobra.Command, args []string) error {
+	topic := state.Topic{Name: args[0]}
+	topic.Criteria.Languages, _ = cmd.Flags().GetStringSlice("languages")
+	topic.Criteria.Hashtags, _ = cmd.Flags().GetStringSlice("hashtags")
+	topic.Criteria.ExcludeSensitive, _ = cmd.Flags().GetBool("exclude-sensitive")
+	if topic.Criteria.IsEmpty() {
+		segments := strings.Split(topic.Name, "/")
+		switch {
+		case len(segments) == 2 && segments[0] == "tags":
+			topic.Criteria.Hashtags = []string{segments[1]}
+		case len(segments) == 2 && segments[0] == "lang":
+			topic.Criteria.Languages = []string{segments[1]}
+		}
+	}
+
+	var err error
+	if adminAPI != nil {
+		err = adminAPI.call("PUT", "topics/"+topic.Name, &topic, nil)
+	} else {
+		err = relayState.AddTopic(topic)
+	}
+	if err != nil {
+		cmd.Println(err.Error())
+		return nil
+	}
+	cmd.Println("Set [" + topic.Name + "] topic actor : " + relayURL(topic.Name).String() + "/actor")
+
+	return nil
+}
+
+func removeTopic(cmd *cobra.Command, args []string) error {
+	for _, name := range args {
+		if relayState.SelectTopic(name) == nil {
+			cmd.Println("Invalid topic [" + name + "] given")
+			continue
+		}
+		err := relayState.DelTopic(name)
+		if err != nil {
+			cmd.Println(err.Error())
+			continue
+		}
+		cmd.Println("Removed [" + name + "] topic actor")
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestAddTopic(t *testing.T) {
+	app := buildNewCmd()
+
+	app.SetArgs([]string{"topic", "add", "tags/art"})
+	app.Execute()
+
+	topic := relayState.SelectTopic("tags/art")
+	if topic == nil || topic.PrivateKey == "" || !reflect.DeepEqual(topic.Criteria.Hashtags, []string{"art"}) {
+		t.Fatalf("Not added topic actor.")
+	}
+
+	app = buildNewCmd()
+	app.SetArgs([]string{"topic", "add", "music", "-l", "en"})
+	app.Execute()
+
+	topic = relayState.SelectTopic("music")
+	if topic == nil || !reflect.DeepEqual(topic.Criteria.Languages, []string{"en"}) {
+		t.Fatalf("Not added topic actor with criteria.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestListTopics(t *testing.T) {
+	app := buildNewCmd()
+
+	buffer := new(bytes.Buffer)
+	app.SetOutput(buffer)
+
+	relayState.AddTopic(state.Topic{Name: "lang/ja", Criteria: state.Preferences{Languages: []string{"ja"}}})
+	relayState.AddTopicSubscription(state.Subscription{
+		Domain:   "example.com",
+		InboxURL: "https://example.com/inbox",
+	}, "lang/ja")
+
+	app.SetArgs([]string{"topic", "list"})
+	app.Execute()
+
+	output := buffer.String()
+	valid := ` - Topic actors :
+lang/ja : languages ja, 1 followers
+Total : 1
+`
+	if output != valid {
+		t.Fatalf("Invalid Response.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
+
+func TestRemoveTopic(t *testing.T) {
+	app := buildNewCmd()
+
+	relayState.AddTopic(state.Topic{Name: "lang/ja", Criteria: state.Preferences{Languages: []string{"ja"}}})
+
+	app.SetArgs([]string{"topic", "remove", "lang/ja"})
+	app.Execute()
+
+	if relayState.SelectTopic("lang/ja") != nil {
+		t.Fatalf("Not removed topic actor.")
+	}
+
+	relayState.RedisClient.FlushAll().Result()
+	relayState.Load()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// dashboardPath : Path which web admin dashboard is served under. Always ends with slash.
+var dashboardPath = "/admin/"
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"timestamp": func(value time.Time) string {
+		if value.IsZero() {
+			return "-"
+		}
+		return value.UTC().Format(time.RFC3339)
+	},
+	"domainList": func(title string, list string, domains []string, data *dashboardData) dashboardDomainList {
+		return dashboardDomainList{title, list, domains, data.Path, data.CSRF}
+	},
+}).Parse(dashboardHTML))
+
+// dashboardSubscriber : Subscription and its delivery health shown in dashboard
+type dashboardSubscriber struct {
+	Subscription state.Subscription
+	Health       state.Health
+}
+
+// dashboardDomainList : Blocked or limited domain list shown in dashboard
+type dashboardDomainList struct {
+	Title   string
+	List    string
+	Domains []string
+	Path    string
+	CSRF    string
+}
+
+type dashboardData struct {
+	Path           string
+	CSRF           string
+	Name           string
+	Version        string
+	Config         map[string]bool
+	Subscribers    []dashboardSubscriber
+	Follows        []state.PendingFollow
+	BlockedDomains []string
+	LimitedDomains []string
+}
+
+// normalizeDashboardPath : Make configured dashboard path to start and end with slash. Root path is not allowed.
+func normalizeDashboardPath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return "/admin/"
+	}
+	return "/" + path + "/"
+}
+
+// dashboardCSRF : Derive form token from admin token, so forms can not be posted from other sites.
+func dashboardCSRF(token string) string {
+	hash := sha256.Sum256([]byte("dashboard:" + token))
+	return hex.EncodeToString(hash[:])
+}
+
+// handleDashboard : Serve web admin dashboard. Moderators sign in with admin token as basic auth password.
+func (tenant *Tenant) handleDashboard(writer http.ResponseWriter, request *http.Request) {
+	_, token, _ := request.BasicAuth()
+	name, ok := tenant.relayState.VerifyAdminToken(token)
+	if !ok {
+		writer.Header().Add("WWW-Authenticate", `Basic realm="Activity-Relay admin", charset="UTF-8"`)
+		writer.WriteHeader(401)
+		writer.Write([]byte("Unauthorized"))
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(request.URL.Path, dashboardPath), "/")
+	switch request.Method {
+	case "GET":
+		if action != "" {
+			writer.WriteHeader(404)
+			writer.Write([]byte("404 Not Found"))
+			return
+		}
+		tenant.renderDashboard(writer, name, token)
+	case "POST":
+		csrf := request.PostFormValue("csrf")
+		if subtle.ConstantTimeCompare([]byte(csrf), []byte(dashboardCSRF(token))) != 1 {
+			writer.WriteHeader(403)
+			writer.Write([]byte("Invalid form token"))
+			return
+		}
+		var err error
+		switch action {
+		case "config":
+			err = tenant.postDashboardConfig(request)
+		case "domains":
+			err = tenant.postDashboardDomains(request)
+		case "follows":
+			err = tenant.postDashboardFollows(request)
+		default:
+			err = errAdminNotFound
+		}
+		switch {
+		case err == errAdminNotFound:
+			writer.WriteHeader(404)
+			writer.Write([]byte("404 Not Found"))
+		case err != nil:
+			writer.WriteHeader(400)
+			writer.Write([]byte(err.Error()))
+		default:
+			logger.WithFields(logger.Fields{"token": name, "action": action}).Info("Admin dashboard")
+			http.Redirect(writer, request, dashboardPath, http.StatusSeeOther)
+		}
+	default:
+		writer.WriteHeader(405)
+		writer.Write([]byte("405 Method Not Allowed"))
+	}
+}
+
+func (tenant *Tenant) renderDashboard(writer http.ResponseWriter, name string, token string) {
+	data := dashboardData{
+		Path:    dashboardPath,
+		CSRF:    dashboardCSRF(token),
+		Name:    name,
+		Version: version,
+		Config: map[string]bool{
+			"block_service":      tenant.relayState.RelayConfig.BlockService,
+			"manually_accept":    tenant.relayState.RelayConfig.ManuallyAccept,
+			"create_as_announce": tenant.relayState.RelayConfig.CreateAsAnnounce,
+		},
+		BlockedDomains: tenant.relayState.BlockedDomains,
+		LimitedDomains: tenant.relayState.LimitedDomains,
+	}
+
+	// Subscriptions are replaced by concurrent Load, so healths are paired with one snapshot.
+	subscriptions := tenant.relayState.Subscriptions
+	var domains []string
+	for _, subscription := range subscriptions {
+		domains = append(domains, subscription.Domain)
+	}
+	healths, err := tenant.relayState.Backend.ListHealth(domains)
+	if err != nil || len(healths) != len(domains) {
+		healths = make([]state.Health, len(domains))
+	}
+	for i, subscription := range subscriptions {
+		data.Subscribers = append(data.Subscribers, dashboardSubscriber{subscription, healths[i]})
+	}
+	data.Follows, err = tenant.relayState.ListPendingFollows()
+	if err != nil {
+		writer.WriteHeader(500)
+		writer.Write([]byte(err.Error()))
+		return
+	}
+
+	writer.Header().Add("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Add("Cache-Control", "no-store")
+	writer.Header().Add("X-Frame-Options", "DENY")
+	writer.Header().Add("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'")
+	err = dashboardTemplate.Execute(writer, &data)
+	if err != nil {
+		logger.WithError(err).Error("Failed render dashboard")
+	}
+}
+
+// postDashboardConfig : Toggle relay config
+func (tenant *Tenant) postDashboardConfig(request *http.Request) error {
+	key, ok := adminConfigKeys[request.PostFormValue("key")]
+	if !ok {
+		return errAdminNotFound
+	}
+	tenant.relayState.SetConfig(key, request.PostFormValue("value") == "true")
+	return nil
+}
+
+// postDashboardDomains : Set or unset domain as limited or blocked
+func (tenant *Tenant) postDashboardDomains(request *http.Request) error {
+	domain := strings.TrimSpace(request.PostFormValue("domain"))
+	if domain == "" {
+		return errors.New("Domain is empty")
+	}
+	value := request.PostFormValue("action") != "remove"
+	switch state.DomainList(request.PostFormValue("list")) {
+	case state.LimitedDomainList:
+		tenant.relayState.SetLimitedDomain(domain, value)
+	case state.BlockedDomainList:
+		tenant.relayState.SetBlockedDomain(domain, value)
+	default:
+		return errAdminNotFound
+	}
+	return nil
+}
+
+// postDashboardFollows : Accept or reject pending follow request
+func (tenant *Tenant) postDashboardFollows(request *http.Request) error {
+	switch request.PostFormValue("action") {
+	case "accept":
+		return tenant.respondPendingFollow(request.PostFormValue("domain"), "Accept")
+	case "reject":
+		return tenant.respondPendingFollow(request.PostFormValue("domain"), "Reject")
+	default:
+		return errAdminNotFound
+	}
+}
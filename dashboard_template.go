@@ -0,0 +1,115 @@
+package main
+
+// dashboardHTML : Template of web admin dashboard. It has no external assets.
+const dashboardHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Activity-Relay admin</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 960px; padding: 1em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.15em; border-bottom: 1px solid #ccc; padding-bottom: .2em; margin-top: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .3em .5em; border-bottom: 1px solid #eee; font-size: .9em; }
+form { display: inline; margin: 0; }
+button { cursor: pointer; }
+.healthy { color: #1a7f37; }
+.degraded { color: #9a6700; }
+.unreachable { color: #cf222e; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>Activity-Relay admin</h1>
+<p class="muted">Signed in with token [{{.Name}}] - {{.Version}}</p>
+
+<h2>Relay config</h2>
+<table>
+{{range $key, $value := .Config}}
+<tr>
+<td>{{$key}}</td>
+<td>{{if $value}}enabled{{else}}disabled{{end}}</td>
+<td>
+<form method="post" action="{{$.Path}}config">
+<input type="hidden" name="csrf" value="{{$.CSRF}}">
+<input type="hidden" name="key" value="{{$key}}">
+<input type="hidden" name="value" value="{{if $value}}false{{else}}true{{end}}">
+<button type="submit">{{if $value}}Disable{{else}}Enable{{end}}</button>
+</form>
+</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Follow requests ({{len .Follows}})</h2>
+{{if .Follows}}
+<table>
+<tr><th>Domain</th><th>Actor</th><th></th></tr>
+{{range .Follows}}
+<tr>
+<td>{{.Domain}}</td>
+<td>{{.Actor}}</td>
+<td>
+<form method="post" action="{{$.Path}}follows">
+<input type="hidden" name="csrf" value="{{$.CSRF}}">
+<input type="hidden" name="domain" value="{{.Domain}}">
+<button type="submit" name="action" value="accept">Accept</button>
+<button type="submit" name="action" value="reject">Reject</button>
+</form>
+</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p class="muted">No follow request.</p>
+{{end}}
+
+<h2>Subscribers ({{len .Subscribers}})</h2>
+{{if .Subscribers}}
+<table>
+<tr><th>Domain</th><th>Status</th><th>Failures</th><th>Last success</th><th>Last failure</th></tr>
+{{range .Subscribers}}
+<tr>
+<td>{{.Subscription.Domain}}</td>
+<td class="{{.Health.Status}}">{{.Health.Status}}</td>
+<td>{{.Health.Failures}}</td>
+<td>{{timestamp .Health.LastSuccess}}</td>
+<td>{{timestamp .Health.LastFailure}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p class="muted">No subscriber.</p>
+{{end}}
+
+{{template "domains" (domainList "Blocked domains" "blockedDomain" .BlockedDomains $)}}
+{{template "domains" (domainList "Limited domains" "limitedDomain" .LimitedDomains $)}}
+</body>
+</html>
+{{define "domains"}}
+<h2>{{.Title}} ({{len .Domains}})</h2>
+<table>
+{{range .Domains}}
+<tr>
+<td>{{.}}</td>
+<td>
+<form method="post" action="{{$.Path}}domains">
+<input type="hidden" name="csrf" value="{{$.CSRF}}">
+<input type="hidden" name="list" value="{{$.List}}">
+<input type="hidden" name="domain" value="{{.}}">
+<button type="submit" name="action" value="remove">Remove</button>
+</form>
+</td>
+</tr>
+{{end}}
+</table>
+<form method="post" action="{{.Path}}domains">
+<input type="hidden" name="csrf" value="{{.CSRF}}">
+<input type="hidden" name="list" value="{{.List}}">
+<input type="text" name="domain" placeholder="example.com" required>
+<button type="submit" name="action" value="add">Add</button>
+</form>
+{{end}}
+`
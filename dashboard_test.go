@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func dashboardRequest(t *testing.T, s *httptest.Server, token string, method string, path string, form url.Values) (int, string) {
+	req, _ := http.NewRequest(method, s.URL+dashboardPath+path, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		req.SetBasicAuth("admin", token)
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	defer r.Body.Close()
+	data, _ := ioutil.ReadAll(r.Body)
+	return r.StatusCode, string(data)
+}
+
+func TestNormalizeDashboardPath(t *testing.T) {
+	for path, valid := range map[string]string{
+		"":        "/admin/",
+		"/":       "/admin/",
+		"admin":   "/admin/",
+		"/admin":  "/admin/",
+		"/a/b/":   "/a/b/",
+		"/admin/": "/admin/",
+	} {
+		if normalizeDashboardPath(path) != valid {
+			t.Fatalf("Failed - Normalized path of [" + path + "] is not " + valid)
+		}
+	}
+}
+
+func TestHandleDashboardUnauthorized(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleDashboard))
+	defer s.Close()
+
+	status, _ := dashboardRequest(t, s, "invalid", "GET", "", nil)
+	if status != 401 {
+		t.Fatalf("Failed - Accepted request with invalid token.")
+	}
+}
+
+func TestHandleDashboardGet(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleDashboard))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:     "subscription.example.jp",
+		InboxURL:   "https://subscription.example.jp/inbox",
+		ActivityID: "https://subscription.example.jp/UUID",
+		ActorID:    "https://subscription.example.jp/users/example",
+	})
+	defaultTenant.relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "follow.example.jp",
+		InboxURL:   "https://follow.example.jp/inbox",
+		ActivityID: "https://follow.example.jp/UUID",
+		Type:       "Follow",
+		Actor:      "https://follow.example.jp/users/example",
+		Object:     "https://www.w3.org/ns/activitystreams#Public",
+	})
+	defaultTenant.relayState.SetBlockedDomain("blocked.example.jp", true)
+
+	status, body := dashboardRequest(t, s, token, "GET", "", nil)
+	if status != 200 {
+		t.Fatalf("Failed - StatusCode is not 200.")
+	}
+	for _, valid := range []string{"subscription.example.jp", "follow.example.jp", "blocked.example.jp", dashboardCSRF(token)} {
+		if !strings.Contains(body, valid) {
+			t.Fatalf("Failed - Dashboard not contains " + valid)
+		}
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHandleDashboardPost(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleDashboard))
+	defer s.Close()
+	token, _ := defaultTenant.relayState.CreateAdminToken("test")
+	csrf := dashboardCSRF(token)
+
+	status, _ := dashboardRequest(t, s, token, "POST", "domains", url.Values{"list": {"blockedDomain"}, "domain": {"example.com"}, "action": {"add"}})
+	if status != 403 {
+		t.Fatalf("Failed - Accepted form without form token.")
+	}
+
+	status, _ = dashboardRequest(t, s, token, "POST", "domains", url.Values{"csrf": {csrf}, "list": {"blockedDomain"}, "domain": {"example.com"}, "action": {"add"}})
+	if status != 303 || !contains(defaultTenant.relayState.BlockedDomains, "example.com") {
+		t.Fatalf("Failed - Domain not blocked.")
+	}
+	dashboardRequest(t, s, token, "POST", "domains", url.Values{"csrf": {csrf}, "list": {"blockedDomain"}, "domain": {"example.com"}, "action": {"remove"}})
+	if contains(defaultTenant.relayState.BlockedDomains, "example.com") {
+		t.Fatalf("Failed - Domain not unblocked.")
+	}
+
+	status, _ = dashboardRequest(t, s, token, "POST", "config", url.Values{"csrf": {csrf}, "key": {"create_as_announce"}, "value": {"true"}})
+	if status != 303 || !defaultTenant.relayState.RelayConfig.CreateAsAnnounce {
+		t.Fatalf("Failed - Config not changed.")
+	}
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, false)
+
+	defaultTenant.relayState.AddPendingFollow(state.PendingFollow{
+		Domain:     "example.com",
+		InboxURL:   "https://example.com/inbox",
+		ActivityID: "https://example.com/UUID",
+		Type:       "Follow",
+		Actor:      "https://example.com/user/example",
+		Object:     "https://www.w3.org/ns/activitystreams#Public",
+	})
+	status, _ = dashboardRequest(t, s, token, "POST", "follows", url.Values{"csrf": {csrf}, "domain": {"example.com"}, "action": {"reject"}})
+	follow, _ := defaultTenant.relayState.SelectPendingFollow("example.com")
+	if status != 303 || follow != nil || defaultTenant.relayState.SelectSubscription("example.com") != nil {
+		t.Fatalf("Failed - Follow request not rejected.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
@@ -1,58 +1,271 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	actorcache "github.com/yukimochi/Activity-Relay/ActorCache"
+	httpsignature "github.com/yukimochi/Activity-Relay/HTTPSignature"
 	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
-	"github.com/yukimochi/httpsig"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
 )
 
+var (
+	errKeyOwnerMismatch       = errors.New("HTTP signature key is not owned by retrieved actor")
+	errSignerActorMismatch    = errors.New("HTTP signer is neither activity actor nor on the same host")
+	errLinkedDataCreator      = errors.New("Linked Data Signature creator is not activity actor")
+	errLinkedDataVerification = errors.New("Linked Data Signature verification failed")
+	errDateNotSigned          = errors.New("HTTP signature covers neither Date header nor created time")
+	errDigestNotSigned        = errors.New("HTTP signature does not cover Digest header")
+	errDateOutOfWindow        = errors.New("HTTP signature is made outside of clock skew window")
+	errSignatureReplayed      = errors.New("HTTP signature is already used")
+)
+
+// keyRotationInterval : Minimum interval of refetching cached actor to pick up rotated key
+const keyRotationInterval = time.Minute
+
+// retrieveActor : Retrieve remote actor through actor cache, counting cache results.
+// Fetch is signed by tenant actor, so instances which require authorized fetch respond too.
+func (tenant *Tenant) retrieveActor(actor *activitypub.Actor, url string) error {
+	_, err := tenant.fetchActor(actor, url)
+	return err
+}
+
+// fetchActor : Retrieve remote actor like retrieveActor. It returns how actor is served by cache.
+func (tenant *Tenant) fetchActor(actor *activitypub.Actor, url string) (string, error) {
+	result, err := actor.RetrieveRemoteActor(url, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", viper.GetString("relay_servicename"), version, tenant.hostURL.Host), actorCache, tenant.signFetch)
+	metrics.ActorCacheRequests.WithLabelValues(result).Inc()
+	return result, err
+}
+
+// signFetch : Sign GET request by tenant actor key
+func (tenant *Tenant) signFetch(request *http.Request) error {
+	return httpsignature.Sign(request, nil, tenant.Actor.PublicKey.ID, tenant.hostPrivatekey, httpsignature.Cavage)
+}
+
+// signatureFailure : Count signature verification failure by reason, and pass through the error.
+func signatureFailure(reason string, err error) (*activitypub.Activity, *activitypub.Actor, []byte, error) {
+	metrics.SignatureFailures.WithLabelValues(reason).Inc()
+	return nil, nil, nil, err
+}
+
+// signerFailureReason : Reason label of error returned by verifySigner
+func signerFailureReason(err error) string {
+	switch err {
+	case errKeyOwnerMismatch:
+		return "key_owner_mismatch"
+	case errSignerActorMismatch:
+		return "signer_actor_mismatch"
+	case errLinkedDataCreator:
+		return "ld_creator_mismatch"
+	case errLinkedDataVerification:
+		return "ld_signature_invalid"
+	default:
+		return "ld_creator_fetch_failed"
+	}
+}
+
+// verifyFreshness : Check signature covers date and digest, and is made within clock skew window.
+// It returns reason of rejection with error.
+func verifyFreshness(verifier *httpsignature.Verifier, request *http.Request) (string, error) {
+	signedAt, ok := verifier.SignedAt(request)
+	if !ok {
+		return "date_not_signed", errDateNotSigned
+	}
+	if request.Method == "POST" && !verifier.Covers("digest") && !verifier.Covers("content-digest") {
+		return "digest_not_signed", errDigestNotSigned
+	}
+	skew := viper.GetDuration("signature_clock_skew")
+	if time.Since(signedAt) > skew || time.Until(signedAt) > skew {
+		return "date_out_of_window", errDateOutOfWindow
+	}
+	if expiresAt, ok := verifier.ExpiresAt(); ok && time.Now().After(expiresAt) {
+		return "date_out_of_window", errDateOutOfWindow
+	}
+	return "", nil
+}
+
+// markSignatureUsed : Reject signature which is already used within clock skew window.
+// Signature older than the window is rejected by verifyFreshness, so nonce is kept for twice of it.
+func markSignatureUsed(verifier *httpsignature.Verifier) error {
+	fresh, err := state.MarkSignatureSeen(defaultTenant.relayState.RedisClient, verifier.Signature(), 2*viper.GetDuration("signature_clock_skew"))
+	if err != nil {
+		logger.WithError(err).Warn("Failed check signature replay")
+		return nil
+	}
+	if !fresh {
+		return errSignatureReplayed
+	}
+	return nil
+}
+
+// soleKeyID : Key id of the only key actor publishes. Signers which use actor URI as keyId are signing with it.
+func soleKeyID(actor *activitypub.Actor) string {
+	switch {
+	case actor.PublicKey.ID != "" && len(actor.AssertionMethod) == 0:
+		return actor.PublicKey.ID
+	case actor.PublicKey.ID == "" && len(actor.AssertionMethod) == 1:
+		return actor.AssertionMethod[0].ID
+	default:
+		return ""
+	}
+}
+
+// actorPublicKey : Select key of keyID from publicKey or FEP-521a assertionMethod of actor.
+// keyID of actor URI selects the only key of actor.
+func actorPublicKey(actor *activitypub.Actor, keyID string) (crypto.PublicKey, error) {
+	if keyID == actor.ID && soleKeyID(actor) != "" {
+		keyID = soleKeyID(actor)
+	}
+	if actor.PublicKey.ID == keyID {
+		return httpsignature.ParsePublicKeyPEM(actor.PublicKey.PublicKeyPem)
+	}
+	for _, multikey := range actor.AssertionMethod {
+		if multikey.ID == keyID && multikey.Type == "Multikey" {
+			return httpsignature.ParseMultibaseKey(multikey.PublicKeyMultibase)
+		}
+	}
+	return nil, errKeyOwnerMismatch
+}
+
+// verifyKey : Verify signature by key of keyID which keyOwnerActor publishes.
+// It returns reason of rejection with error.
+func verifyKey(verifier *httpsignature.Verifier, keyOwnerActor *activitypub.Actor, keyID string) (string, error) {
+	PubKey, err := actorPublicKey(keyOwnerActor, keyID)
+	if err == errKeyOwnerMismatch {
+		return "key_owner_mismatch", err
+	}
+	if err != nil {
+		return "invalid_public_key", err
+	}
+	err = verifier.Verify(PubKey)
+	if err != nil {
+		return "signature_mismatch", err
+	}
+	return "", nil
+}
+
+// ownsKey : Check actor publishes key of keyID as its own.
+func ownsKey(actor *activitypub.Actor, keyID string) bool {
+	if keyID == actor.ID && soleKeyID(actor) != "" {
+		keyID = soleKeyID(actor)
+	}
+	if actor.PublicKey.ID == keyID {
+		return actor.PublicKey.Owner == "" || actor.PublicKey.Owner == actor.ID
+	}
+	for _, multikey := range actor.AssertionMethod {
+		if multikey.ID == keyID {
+			return multikey.Controller == "" || multikey.Controller == actor.ID
+		}
+	}
+	return false
+}
+
+// verifySigner : Check HTTP signature key owner is allowed to deliver the activity.
+func (tenant *Tenant) verifySigner(keyID string, keyOwnerActor *activitypub.Actor, activity *activitypub.Activity, body []byte) error {
+	if !ownsKey(keyOwnerActor, keyID) {
+		return errKeyOwnerMismatch
+	}
+
+	if keyOwnerActor.ID == activity.Actor {
+		return nil
+	}
+	signerURL, err := url.Parse(keyOwnerActor.ID)
+	if err != nil {
+		return errSignerActorMismatch
+	}
+	actorURL, err := url.Parse(activity.Actor)
+	if err != nil {
+		return errSignerActorMismatch
+	}
+	if signerURL.Host != "" && signerURL.Host == actorURL.Host {
+		return nil
+	}
+
+	signature, err := activitypub.LinkedDataSignature(body)
+	if err != nil {
+		return errSignerActorMismatch
+	}
+	return tenant.verifyLinkedDataSignature(signature, activity, body)
+}
+
+// verifyLinkedDataSignature : Check embedded signature is made by activity actor.
+func (tenant *Tenant) verifyLinkedDataSignature(signature *activitypub.Signature, activity *activitypub.Activity, body []byte) error {
+	creatorActor := new(activitypub.Actor)
+	err := tenant.retrieveActor(creatorActor, signature.Creator)
+	if err != nil {
+		return err
+	}
+	if creatorActor.PublicKey.ID != signature.Creator || creatorActor.ID != activity.Actor {
+		return errLinkedDataCreator
+	}
+	PubKey, err := keyloader.ReadPublicKeyRSAfromString(creatorActor.PublicKey.PublicKeyPem)
+	if PubKey == nil {
+		return errors.New("Failed parse PublicKey from string")
+	}
+	if err != nil {
+		return err
+	}
+	err = activitypub.VerifyLinkedDataSignature(body, PubKey)
+	if err != nil {
+		return errLinkedDataVerification
+	}
+	return nil
+}
+
 func decodeActivity(request *http.Request) (*activitypub.Activity, *activitypub.Actor, []byte, error) {
-	request.Header.Set("Host", request.Host)
+	tenant := tenantFromRequest(request)
 	dataLen, _ := strconv.Atoi(request.Header.Get("Content-Length"))
 	body := make([]byte, dataLen)
 	request.Body.Read(body)
 
 	// Verify HTTPSignature
-	verifier, err := httpsig.NewVerifier(request)
+	verifier, err := httpsignature.NewVerifier(request)
 	if err != nil {
-		return nil, nil, nil, err
+		return signatureFailure("invalid_header", err)
 	}
-	KeyID := verifier.KeyId()
-	keyOwnerActor := new(activitypub.Actor)
-	err = keyOwnerActor.RetrieveRemoteActor(KeyID, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", viper.GetString("relay_servicename"), version, hostURL.Host), actorCache)
+	reason, err := verifyFreshness(verifier, request)
 	if err != nil {
-		return nil, nil, nil, err
-	}
-	PubKey, err := keyloader.ReadPublicKeyRSAfromString(keyOwnerActor.PublicKey.PublicKeyPem)
-	if PubKey == nil {
-		return nil, nil, nil, errors.New("Failed parse PublicKey from string")
+		return signatureFailure(reason, err)
 	}
+	KeyID := verifier.KeyID()
+	keyOwnerActor := new(activitypub.Actor)
+	result, err := tenant.fetchActor(keyOwnerActor, KeyID)
 	if err != nil {
-		return nil, nil, nil, err
+		return signatureFailure("key_fetch_failed", err)
+	}
+	reason, err = verifyKey(verifier, keyOwnerActor, KeyID)
+	if err != nil && result == actorcache.Hit && actorCache.ClaimRevalidation(KeyID, keyRotationInterval) {
+		// Cached actor may have rotated its key, so verify once more by revalidated actor.
+		// Revalidation is claimed once per interval, so invalid signatures can not force fetching actor on every request.
+		actorCache.Expire(KeyID)
+		keyOwnerActor = new(activitypub.Actor)
+		if tenant.retrieveActor(keyOwnerActor, KeyID) == nil {
+			reason, err = verifyKey(verifier, keyOwnerActor, KeyID)
+		}
 	}
-	err = verifier.Verify(PubKey, httpsig.RSA_SHA256)
 	if err != nil {
-		return nil, nil, nil, err
+		return signatureFailure(reason, err)
 	}
 
 	// Verify Digest
-	givenDigest := request.Header.Get("Digest")
-	hash := sha256.New()
-	hash.Write(body)
-	b := hash.Sum(nil)
-	calcurateDigest := "SHA-256=" + base64.StdEncoding.EncodeToString(b)
-
-	if givenDigest != calcurateDigest {
-		return nil, nil, nil, errors.New("Digest header is mismatch")
+	err = httpsignature.VerifyDigest(request, body)
+	if err != nil {
+		return signatureFailure("digest_mismatch", err)
+	}
+	err = markSignatureUsed(verifier)
+	if err != nil {
+		return signatureFailure("replayed", err)
 	}
 
 	// Parse Activity
@@ -62,8 +275,13 @@ func decodeActivity(request *http.Request) (*activitypub.Activity, *activitypub.
 		return nil, nil, nil, err
 	}
 
+	err = tenant.verifySigner(KeyID, keyOwnerActor, &activity, body)
+	if err != nil {
+		return signatureFailure(signerFailureReason(err), err)
+	}
+
 	var remoteActor activitypub.Actor
-	err = remoteActor.RetrieveRemoteActor(activity.Actor, fmt.Sprintf("%s (golang net/http; Activity-Relay %s; %s)", viper.GetString("relay_servicename"), version, hostURL.Host), actorCache)
+	err = tenant.retrieveActor(&remoteActor, activity.Actor)
 	if err != nil {
 		return nil, nil, nil, err
 	}
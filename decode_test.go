@@ -2,18 +2,45 @@ package main
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	httpdate "github.com/Songmu/go-httpdate"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	actorcache "github.com/yukimochi/Activity-Relay/ActorCache"
+	httpsignature "github.com/yukimochi/Activity-Relay/HTTPSignature"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
+// allowRecordedRequest : Widen clock skew window to accept request recorded in 2018. Call returned func to restore.
+func allowRecordedRequest() func() {
+	skew := viper.GetDuration("signature_clock_skew")
+	viper.Set("signature_clock_skew", time.Since(time.Date(2018, 12, 23, 0, 0, 0, 0, time.UTC)))
+	return func() {
+		viper.Set("signature_clock_skew", skew)
+	}
+}
+
 func TestDecodeActivity(t *testing.T) {
-	relayState.AddSubscription(state.Subscription{
+	defer allowRecordedRequest()()
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "innocent.yukimochi.io",
 		InboxURL: "https://innocent.yukimochi.io/inbox",
 	})
@@ -39,11 +66,11 @@ func TestDecodeActivity(t *testing.T) {
 		t.Fatalf("Failed - retrieved actor is invalid")
 	}
 
-	relayState.DelSubscription("innocent.yukimochi.io")
+	defaultTenant.relayState.DelSubscription("innocent.yukimochi.io")
 }
 
 func TestDecodeActivityWithNoSignature(t *testing.T) {
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "innocent.yukimochi.io",
 		InboxURL: "https://innocent.yukimochi.io/inbox",
 	})
@@ -59,15 +86,16 @@ func TestDecodeActivityWithNoSignature(t *testing.T) {
 	req.Header.Add("digest", "SHA-256=mxgIzbPwBuNYxmjhQeH0vWeEedQGqR1R7zMwR/XTfX8=")
 
 	_, _, _, err := decodeActivity(req)
-	if err.Error() != "neither \"Signature\" nor \"Authorization\" have signature parameters" {
+	if err != httpsignature.ErrNoSignature {
 		t.Fatalf("Failed - Accept request without signature")
 	}
 
-	relayState.DelSubscription("innocent.yukimochi.io")
+	defaultTenant.relayState.DelSubscription("innocent.yukimochi.io")
 }
 
 func TestDecodeActivityWithNotFoundKeyId(t *testing.T) {
-	relayState.AddSubscription(state.Subscription{
+	defer allowRecordedRequest()()
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "innocent.yukimochi.io",
 		InboxURL: "https://innocent.yukimochi.io/inbox",
 	})
@@ -88,11 +116,12 @@ func TestDecodeActivityWithNotFoundKeyId(t *testing.T) {
 		t.Fatalf("Failed - Accept notfound KeyId")
 	}
 
-	relayState.DelSubscription("innocent.yukimochi.io")
+	defaultTenant.relayState.DelSubscription("innocent.yukimochi.io")
 }
 
 func TestDecodeActivityWithInvalidDigest(t *testing.T) {
-	relayState.AddSubscription(state.Subscription{
+	defer allowRecordedRequest()()
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "innocent.yukimochi.io",
 		InboxURL: "https://innocent.yukimochi.io/inbox",
 	})
@@ -109,9 +138,436 @@ func TestDecodeActivityWithInvalidDigest(t *testing.T) {
 	req.Header.Add("signature", `keyId="https://innocent.yukimochi.io/users/YUKIMOCHI#main-key",algorithm="rsa-sha256",headers="(request-target) host date digest content-type",signature="MhxXhL21RVp8VmALER2U/oJlWldJAB2COiU2QmwGopLD2pw1c32gQvg0PaBRHfMBBOsidZuRRnj43Kn488zW2xV3n3DYWcGscSh527/hhRzcpLVX2kBqbf/WeQzJmfJVuOX4SzivVhnnUB8PvlPj5LRHpw4n/ctMTq37strKDl9iZg9rej1op1YFJagDxm3iPzAhnv8lzO4RI9dstt2i/sN5EfjXai97oS7EgI//Kj1wJCRk9Pw1iTsGfPTkbk/aVZwDt7QGGvGDdO0JJjsCqtIyjojoyD9hFY9GzMqvTwVIYJrh54AUHq2i80veybaOBbCFcEaK0RpKoLs101r5Uw=="`)
 
 	_, _, _, err := decodeActivity(req)
-	if err.Error() != "crypto/rsa: verification error" {
+	if err != httpsignature.ErrSignatureMismatch {
 		t.Fatalf("Failed - Accept unvalid digest")
 	}
 
-	relayState.DelSubscription("innocent.yukimochi.io")
+	defaultTenant.relayState.DelSubscription("innocent.yukimochi.io")
+}
+
+func mockRemoteActor(actorID string, keyID string) {
+	publicKey, _ := x509.MarshalPKIXPublicKey(&defaultTenant.hostPrivatekey.PublicKey)
+	actor := activitypub.Actor{
+		ID:    actorID,
+		Type:  "Person",
+		Inbox: actorID + "/inbox",
+		PublicKey: activitypub.PublicKey{
+			ID:           keyID,
+			Owner:        actorID,
+			PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+		},
+	}
+	data, _ := json.Marshal(&actor)
+	actorCache.Store(actorID, data)
+	actorCache.Store(keyID, data)
+}
+
+func mockEd25519RemoteActor(actorID string, keyID string) ed25519.PrivateKey {
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	actor := activitypub.Actor{
+		ID:    actorID,
+		Type:  "Person",
+		Inbox: actorID + "/inbox",
+		AssertionMethod: activitypub.Multikeys{{
+			ID:                 keyID,
+			Type:               "Multikey",
+			Controller:         actorID,
+			PublicKeyMultibase: httpsignature.EncodeMultibaseKey(publicKey),
+		}},
+	}
+	data, _ := json.Marshal(&actor)
+	actorCache.Store(actorID, data)
+	actorCache.Store(keyID, data)
+	return privateKey
+}
+
+func mockSignedRequest(body []byte, keyID string) *http.Request {
+	return mockSignedRequestWithScheme(body, keyID, defaultTenant.hostPrivatekey, httpsignature.Cavage)
+}
+
+func mockSignedRequestWithScheme(body []byte, keyID string, key crypto.PrivateKey, scheme httpsignature.Scheme) *http.Request {
+	req, _ := http.NewRequest("POST", "/inbox", bytes.NewReader(body))
+	req.Host = defaultTenant.hostURL.Host
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Date", httpdate.Time2Str(time.Now()))
+
+	httpsignature.Sign(req, body, keyID, key, scheme)
+
+	// Requests signed in same second have same signature, so forget used signatures for each mocked request.
+	keys, _ := defaultTenant.relayState.RedisClient.Keys("relay:seen:signature:*").Result()
+	if len(keys) > 0 {
+		defaultTenant.relayState.RedisClient.Del(keys...)
+	}
+	return req
+}
+
+func cloneSignedRequest(req *http.Request, body []byte) *http.Request {
+	clone, _ := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(body))
+	clone.Host = req.Host
+	clone.Header = req.Header.Clone()
+	return clone
+}
+
+func mockSignedActivity(actor string, signature bool) []byte {
+	activity := map[string]interface{}{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"id":       actor + "/statuses/1/activity",
+		"type":     "Create",
+		"actor":    actor,
+		"to":       []string{"https://www.w3.org/ns/activitystreams#Public"},
+		"object": map[string]interface{}{
+			"id":      actor + "/statuses/1",
+			"type":    "Note",
+			"content": "Activity-Relay",
+		},
+	}
+	if signature {
+		activity["signature"] = activitypub.Signature{
+			Type:           "RsaSignature2017",
+			Creator:        actor + "#main-key",
+			Created:        "2018-12-23T07:39:37Z",
+			SignatureValue: "c2lnbmF0dXJl",
+		}
+	}
+	body, _ := json.Marshal(activity)
+	return body
+}
+
+func TestDecodeActivitySignerIsActor(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	activity, actor, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if activity.Actor != actor.ID {
+		t.Fatalf("Failed - retrieved actor is invalid")
+	}
+}
+
+func TestDecodeActivitySignerOnSameHost(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/actor", "https://signer.example.com/actor#main-key")
+	mockRemoteActor("https://signer.example.com/users/bob", "https://signer.example.com/users/bob#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/bob", false)
+	req := mockSignedRequest(body, "https://signer.example.com/actor#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+}
+
+func TestDecodeActivitySignerOnOtherHost(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	mockRemoteActor("https://victim.example.org/users/carol", "https://victim.example.org/users/carol#main-key")
+	body := mockSignedActivity("https://victim.example.org/users/carol", false)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != errSignerActorMismatch {
+		t.Fatalf("Failed - Accept activity signed by other host")
+	}
+}
+
+func TestDecodeActivitySignerOnOtherHostWithLinkedDataSignature(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	mockRemoteActor("https://victim.example.org/users/carol", "https://victim.example.org/users/carol#main-key")
+	body := mockSignedActivity("https://victim.example.org/users/carol", false)
+	body, err := activitypub.SignLinkedData(body, "https://victim.example.org/users/carol#main-key", defaultTenant.hostPrivatekey)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	_, _, _, err = decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+}
+
+func TestDecodeActivitySignerOnOtherHostWithInvalidLinkedDataSignature(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	mockRemoteActor("https://victim.example.org/users/carol", "https://victim.example.org/users/carol#main-key")
+	body := mockSignedActivity("https://victim.example.org/users/carol", true)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != errLinkedDataVerification {
+		t.Fatalf("Failed - Accept activity with forged Linked Data Signature")
+	}
+}
+
+func TestDecodeActivitySignerOnOtherHostWithOtherCreator(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	mockRemoteActor("https://victim.example.org/users/carol", "https://victim.example.org/users/carol#main-key")
+	body := mockSignedActivity("https://victim.example.org/users/carol", false)
+	body, _ = activitypub.SignLinkedData(body, "https://signer.example.com/users/alice#main-key", defaultTenant.hostPrivatekey)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != errLinkedDataCreator {
+		t.Fatalf("Failed - Accept activity signed by other actor")
+	}
+}
+
+func TestDecodeActivitySchemes(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+
+	for _, scheme := range httpsignature.Schemes {
+		req := mockSignedRequestWithScheme(body, "https://signer.example.com/users/alice#main-key", defaultTenant.hostPrivatekey, scheme)
+		_, _, _, err := decodeActivity(req)
+		if err != nil {
+			t.Fatalf("Failed - Reject %s signature : %s", scheme, err.Error())
+		}
+	}
+}
+
+func TestDecodeActivityEd25519Multikey(t *testing.T) {
+	privateKey := mockEd25519RemoteActor("https://signer.example.com/users/bob", "https://signer.example.com/users/bob#ed25519-key")
+	body := mockSignedActivity("https://signer.example.com/users/bob", false)
+
+	for _, scheme := range []httpsignature.Scheme{httpsignature.HS2019, httpsignature.RFC9421} {
+		req := mockSignedRequestWithScheme(body, "https://signer.example.com/users/bob#ed25519-key", privateKey, scheme)
+		activity, actor, _, err := decodeActivity(req)
+		if err != nil {
+			t.Fatalf("Failed - Reject %s signature by Ed25519 key : %s", scheme, err.Error())
+		}
+		if activity.Actor != actor.ID {
+			t.Fatalf("Failed - retrieved actor is invalid")
+		}
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	req := mockSignedRequestWithScheme(body, "https://signer.example.com/users/bob#ed25519-key", otherKey, httpsignature.RFC9421)
+	_, _, _, err := decodeActivity(req)
+	if err != httpsignature.ErrSignatureMismatch {
+		t.Fatalf("Failed - Accept signature by other Ed25519 key")
+	}
+}
+
+func TestDecodeActivityOutOfClockSkew(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+
+	for _, date := range []time.Time{time.Now().Add(-2 * time.Hour), time.Now().Add(2 * time.Hour)} {
+		req, _ := http.NewRequest("POST", "/inbox", bytes.NewReader(body))
+		req.Host = defaultTenant.hostURL.Host
+		req.Header.Set("Content-Type", "application/activity+json")
+		req.Header.Set("Date", httpdate.Time2Str(date))
+		httpsignature.Sign(req, body, "https://signer.example.com/users/alice#main-key", defaultTenant.hostPrivatekey, httpsignature.Cavage)
+
+		before := testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("date_out_of_window"))
+		_, _, _, err := decodeActivity(req)
+		if err != errDateOutOfWindow {
+			t.Fatalf("Failed - Accept signature made at " + date.String())
+		}
+		if testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("date_out_of_window")) != before+1 {
+			t.Fatalf("Failed - Rejection is not counted.")
+		}
+	}
+}
+
+func TestDecodeActivityUnsignedDateOrDigest(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+
+	for headers, expected := range map[string]error{
+		"(request-target) host digest content-type": errDateNotSigned,
+		"(request-target) host date content-type":   errDigestNotSigned,
+	} {
+		req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+		req.Header.Set("Signature", strings.Replace(req.Header.Get("Signature"), "(request-target) host date digest content-type", headers, 1))
+		_, _, _, err := decodeActivity(req)
+		if err != expected {
+			t.Fatalf("Failed - Accept signature covering " + headers)
+		}
+	}
+}
+
+func TestDecodeActivityReplayed(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+	replayed := cloneSignedRequest(req, body)
+
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	before := testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("replayed"))
+	_, _, _, err = decodeActivity(replayed)
+	if err != errSignatureReplayed {
+		t.Fatalf("Failed - Accept replayed request")
+	}
+	if testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("replayed")) != before+1 {
+		t.Fatalf("Failed - Replay is not counted.")
+	}
+}
+
+func TestDecodeActivityActorURIKeyID(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/dave", "https://signer.example.com/users/dave#main-key")
+	body := mockSignedActivity("https://signer.example.com/users/dave", false)
+	req := mockSignedRequest(body, "https://signer.example.com/users/dave")
+
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - Reject actor URI keyId of actor with single key : " + err.Error())
+	}
+}
+
+func TestDecodeActivityKeyOwnerMismatch(t *testing.T) {
+	mockRemoteActor("https://signer.example.com/users/alice", "https://signer.example.com/users/alice#other-key")
+	actorCache.Delete("https://signer.example.com/users/alice#main-key")
+	entry, _ := actorCache.Get("https://signer.example.com/users/alice#other-key")
+	actorCache.Store("https://signer.example.com/users/alice#main-key", entry.Data)
+	body := mockSignedActivity("https://signer.example.com/users/alice", false)
+	req := mockSignedRequest(body, "https://signer.example.com/users/alice#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != errKeyOwnerMismatch {
+		t.Fatalf("Failed - Accept key which is not owned by retrieved actor")
+	}
+}
+
+func TestDecodeActivityRotatedKey(t *testing.T) {
+	var fetched int
+	var actorID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		publicKey, _ := x509.MarshalPKIXPublicKey(&defaultTenant.hostPrivatekey.PublicKey)
+		actor := activitypub.Actor{
+			ID:    actorID,
+			Type:  "Person",
+			Inbox: actorID + "/inbox",
+			PublicKey: activitypub.PublicKey{
+				ID:           actorID + "#main-key",
+				Owner:        actorID,
+				PublicKeyPem: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})),
+			},
+		}
+		w.Header().Set("Content-Type", "application/activity+json")
+		json.NewEncoder(w).Encode(&actor)
+	}))
+	defer server.Close()
+	actorID = server.URL + "/users/carol"
+
+	// Cached actor still publishes key before rotation.
+	mockEd25519RemoteActor(actorID, actorID+"#main-key")
+	body := mockSignedActivity(actorID, false)
+	req := mockSignedRequest(body, actorID+"#main-key")
+
+	_, _, _, err := decodeActivity(req)
+	if err != nil {
+		t.Fatalf("Failed - Reject signature by rotated key : " + err.Error())
+	}
+	if fetched != 1 {
+		t.Fatalf("Failed - Actor of rotated key is not refetched once")
+	}
+	actorCache.Delete(actorID)
+	actorCache.Delete(actorID + "#main-key")
+}
+
+func TestDecodeActivityInvalidSignatureRefetchOnce(t *testing.T) {
+	var fetched int
+	var actorData []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Header().Set("Content-Type", "application/activity+json")
+		w.Write(actorData)
+	}))
+	defer server.Close()
+	actorID := server.URL + "/users/dave"
+
+	// Actor publishes other key than signing key both in cache and remote.
+	mockEd25519RemoteActor(actorID, actorID+"#main-key")
+	entry, _ := actorCache.Get(actorID)
+	actorData = entry.Data
+	body := mockSignedActivity(actorID, false)
+
+	for i := 0; i < 3; i++ {
+		_, _, _, err := decodeActivity(mockSignedRequest(body, actorID+"#main-key"))
+		if err == nil {
+			t.Fatalf("Failed - Accept signature by unpublished key")
+		}
+	}
+	if fetched != 1 {
+		t.Fatalf("Failed - Actor is refetched on every invalid signature : " + strconv.Itoa(fetched))
+	}
+	actorCache.Delete(actorID)
+	actorCache.Delete(actorID + "#main-key")
+}
+
+// mockSecureModeServer : Stand-in of instance running authorized fetch (secure mode).
+// It serves actor only to GET signed by relay actor, which key it fetches from relay by its own signed GET.
+func mockSecureModeServer(relay *httptest.Server) *httptest.Server {
+	instanceKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verifier, err := httpsignature.NewVerifier(r)
+		if err != nil {
+			w.WriteHeader(401)
+			return
+		}
+
+		req, _ := http.NewRequest("GET", relay.URL, nil)
+		req.Header.Set("Accept", "application/activity+json")
+		httpsignature.Sign(req, nil, server.URL+"/actor#main-key", instanceKey, httpsignature.Cavage)
+		resp, err := new(http.Client).Do(req)
+		if err != nil || resp.StatusCode != 200 {
+			w.WriteHeader(500)
+			return
+		}
+		defer resp.Body.Close()
+		var signer activitypub.Actor
+		json.NewDecoder(resp.Body).Decode(&signer)
+		if signer.PublicKey.ID != verifier.KeyID() {
+			w.WriteHeader(401)
+			return
+		}
+		key, err := httpsignature.ParsePublicKeyPEM(signer.PublicKey.PublicKeyPem)
+		if err != nil || verifier.Verify(key) != nil {
+			w.WriteHeader(401)
+			return
+		}
+
+		actor := activitypub.Actor{
+			ID:    server.URL + r.URL.Path,
+			Type:  "Person",
+			Inbox: server.URL + r.URL.Path + "/inbox",
+		}
+		w.Header().Set("Content-Type", "application/activity+json")
+		json.NewEncoder(w).Encode(&actor)
+	}))
+	return server
+}
+
+func TestRetrieveActorAuthorizedFetch(t *testing.T) {
+	relay := httptest.NewServer(tenantHandler((*Tenant).handleActor))
+	defer relay.Close()
+	instance := mockSecureModeServer(relay)
+	defer instance.Close()
+	actorURL := instance.URL + "/users/alice"
+
+	var unsigned activitypub.Actor
+	unsignedCache := actorcache.New(1, nil, time.Minute, time.Minute)
+	unsignedCache.Client = actorCache.Client
+	_, err := unsigned.RetrieveRemoteActor(actorURL, "", unsignedCache, nil)
+	if err == nil || err.Error() != "401 Unauthorized" {
+		t.Fatalf("Failed - Secure mode stand-in serves unsigned fetch")
+	}
+
+	actorCache.Delete(actorURL)
+	var actor activitypub.Actor
+	err = defaultTenant.retrieveActor(&actor, actorURL)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if actor.ID != actorURL {
+		t.Fatalf("Failed - Retrieved actor is invalid")
+	}
+	actorCache.Delete(actorURL)
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// filterActivity : Apply content filter rules to object of activity.
+// Returns false when activity should be dropped, and body to relay.
+func (tenant *Tenant) filterActivity(activity *activitypub.Activity, body []byte) (bool, []byte) {
+	if len(tenant.relayState.Filters) == 0 {
+		return true, body
+	}
+	object, ok := activity.Object.(map[string]interface{})
+	if !ok {
+		return true, body
+	}
+	rule := tenant.relayState.MatchFilter(object)
+	if rule == nil {
+		return true, body
+	}
+	metrics.FilterMatches.WithLabelValues(rule.Action).Inc()
+	err := tenant.relayState.CountFilterMatch(rule.Name)
+	if err != nil {
+		logger.WithError(err).WithField("filter", rule.Name).Error("Failed count filter match")
+	}
+
+	switch rule.Action {
+	case state.FilterDrop:
+		return false, body
+	case state.FilterContentWarning:
+		// Object of activity is rewritten too, so Announce and preferences see content warning.
+		contentWarningObject(object, rule)
+		return true, contentWarningBody(body, rule)
+	default:
+		return true, body
+	}
+}
+
+// contentWarningObject : Mark object as sensitive, with rule pattern as summary when it has none.
+func contentWarningObject(object map[string]interface{}, rule *state.FilterRule) {
+	object["sensitive"] = true
+	if summary, _ := object["summary"].(string); summary == "" {
+		object["summary"] = rule.Pattern
+	}
+}
+
+// contentWarningBody : Mark object of activity as sensitive with summary.
+// Linked data signature of rewritten activity is removed, because it does not match anymore.
+// Receivers verifying authorship by linked data signature, such as Mastodon, refetch object
+// from origin instead, and see it without content warning.
+func contentWarningBody(body []byte, rule *state.FilterRule) []byte {
+	var data map[string]interface{}
+	err := json.Unmarshal(body, &data)
+	if err != nil {
+		return body
+	}
+	object, ok := data["object"].(map[string]interface{})
+	if !ok {
+		return body
+	}
+	contentWarningObject(object, rule)
+	delete(data, "signature")
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return jsonData
+}
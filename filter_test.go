@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestFilterActivityDrop(t *testing.T) {
+	defaultTenant.relayState.AddFilter(state.FilterRule{Name: "japanese", Field: "language", Pattern: "ja", Action: state.FilterDrop})
+	defer defaultTenant.relayState.DelFilter("japanese")
+
+	activity := mockActivity("Create")
+	relay, _ := defaultTenant.filterActivity(&activity, []byte("raw"))
+	if relay {
+		t.Fatalf("Failed - Matched activity is not dropped.")
+	}
+	matches, _ := defaultTenant.relayState.FilterMatches()
+	if matches["japanese"] == 0 {
+		t.Fatalf("Failed - Match is not counted.")
+	}
+
+	activity = mockActivity("Announce")
+	relay, body := defaultTenant.filterActivity(&activity, []byte("raw"))
+	if !relay || string(body) != "raw" {
+		t.Fatalf("Failed - Announce without embedded object should be relayed as is.")
+	}
+}
+
+func TestFilterActivityContentWarning(t *testing.T) {
+	defaultTenant.relayState.AddFilter(state.FilterRule{Name: "test", Field: "content", Pattern: "てすてす", Action: state.FilterContentWarning})
+	defer defaultTenant.relayState.DelFilter("test")
+
+	activity := mockActivity("Create")
+	body, _ := ioutil.ReadFile("./misc/create.json")
+	relay, filteredBody := defaultTenant.filterActivity(&activity, body)
+	if !relay {
+		t.Fatalf("Failed - Activity behind content warning is dropped.")
+	}
+	var data map[string]interface{}
+	json.Unmarshal(filteredBody, &data)
+	object := data["object"].(map[string]interface{})
+	if object["sensitive"] != true || object["summary"] != "てすてす" {
+		t.Fatalf("Failed - Content warning is not applied.")
+	}
+	if _, ok := data["signature"]; ok {
+		t.Fatalf("Failed - Linked data signature remains on rewritten activity.")
+	}
+
+	var announce map[string]interface{}
+	json.Unmarshal(defaultTenant.litePubAnnounce(&activity, filteredBody, defaultTenant.hostURL), &announce)
+	announced, ok := announce["object"].(map[string]interface{})
+	if !ok || announced["sensitive"] != true || announced["summary"] != "てすてす" {
+		t.Fatalf("Failed - Content warning is not applied to Announce.")
+	}
+}
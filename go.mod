@@ -6,9 +6,11 @@ require (
 	github.com/RichardKnop/machinery v1.7.8
 	github.com/Songmu/go-httpdate v1.0.0
 	github.com/go-redis/redis v6.15.7+incompatible
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/piprate/json-gold v0.3.0
+	github.com/prometheus/client_golang v1.7.1
 	github.com/satori/go.uuid v1.2.0
+	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.0.0
 	github.com/spf13/viper v1.7.0
-	github.com/yukimochi/httpsig v0.1.3
+	go.etcd.io/bbolt v1.3.5
 )
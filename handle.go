@@ -3,31 +3,30 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"net/url"
-	"os"
+	"sort"
+	"strconv"
 
 	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/spf13/viper"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
-func handleWebfinger(writer http.ResponseWriter, request *http.Request) {
+func (tenant *Tenant) handleWebfinger(writer http.ResponseWriter, request *http.Request) {
 	resource := request.URL.Query()["resource"]
 	if request.Method != "GET" || len(resource) == 0 {
 		writer.WriteHeader(400)
 		writer.Write(nil)
 	} else {
 		request := resource[0]
-		if request == WebfingerResource.Subject {
-			wfresource, err := json.Marshal(&WebfingerResource)
-			if err != nil {
-				panic(err)
-			}
-			writer.Header().Add("Content-Type", "application/json")
-			writer.WriteHeader(200)
-			writer.Write(wfresource)
+		if request == tenant.WebfingerResource.Subject {
+			writeWebfinger(writer, &tenant.WebfingerResource)
+		} else if topicResource := tenant.topicWebfinger(request); topicResource != nil {
+			writeWebfinger(writer, topicResource)
 		} else {
 			writer.WriteHeader(404)
 			writer.Write(nil)
@@ -35,12 +34,12 @@ func handleWebfinger(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func handleNodeinfoLink(writer http.ResponseWriter, request *http.Request) {
+func (tenant *Tenant) handleNodeinfoLink(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "GET" {
 		writer.WriteHeader(400)
 		writer.Write(nil)
 	} else {
-		linksresource, err := json.Marshal(&Nodeinfo.NodeinfoLinks)
+		linksresource, err := json.Marshal(&tenant.Nodeinfo.NodeinfoLinks)
 		if err != nil {
 			panic(err)
 		}
@@ -50,16 +49,16 @@ func handleNodeinfoLink(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func handleNodeinfo(writer http.ResponseWriter, request *http.Request) {
+func (tenant *Tenant) handleNodeinfo(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "GET" {
 		writer.WriteHeader(400)
 		writer.Write(nil)
 	} else {
-		userCount := len(relayState.Subscriptions)
-		Nodeinfo.Nodeinfo.Usage.Users.Total = userCount
-		Nodeinfo.Nodeinfo.Usage.Users.ActiveMonth = userCount
-		Nodeinfo.Nodeinfo.Usage.Users.ActiveHalfyear = userCount
-		linksresource, err := json.Marshal(&Nodeinfo.Nodeinfo)
+		userCount := len(tenant.relayState.Subscriptions)
+		tenant.Nodeinfo.Nodeinfo.Usage.Users.Total = userCount
+		tenant.Nodeinfo.Nodeinfo.Usage.Users.ActiveMonth = userCount
+		tenant.Nodeinfo.Nodeinfo.Usage.Users.ActiveHalfyear = userCount
+		linksresource, err := json.Marshal(&tenant.Nodeinfo.Nodeinfo)
 		if err != nil {
 			panic(err)
 		}
@@ -69,9 +68,13 @@ func handleNodeinfo(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
-func handleActor(writer http.ResponseWriter, request *http.Request) {
+func (tenant *Tenant) handleActor(writer http.ResponseWriter, request *http.Request) {
+	handleActorResource(writer, request, &tenant.Actor)
+}
+
+func handleActorResource(writer http.ResponseWriter, request *http.Request, relayActor *activitypub.Actor) {
 	if request.Method == "GET" {
-		actor, err := json.Marshal(&Actor)
+		actor, err := json.Marshal(relayActor)
 		if err != nil {
 			panic(err)
 		}
@@ -84,6 +87,67 @@ func handleActor(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+const collectionPageSize = 50
+
+func handleCollection(writer http.ResponseWriter, request *http.Request, id string, items []string) {
+	if request.Method != "GET" {
+		writer.WriteHeader(400)
+		writer.Write(nil)
+		return
+	}
+	sort.Strings(items)
+
+	var resource interface{}
+	pageQuery := request.URL.Query().Get("page")
+	if pageQuery == "" {
+		var collection activitypub.OrderedCollection
+		collection.GenerateFromItems(id, items)
+		resource = &collection
+	} else {
+		page, err := strconv.Atoi(pageQuery)
+		if err != nil || page < 1 {
+			writer.WriteHeader(400)
+			writer.Write(nil)
+			return
+		}
+		var collectionPage activitypub.OrderedCollectionPage
+		collectionPage.GenerateFromItems(id, items, page, collectionPageSize)
+		resource = &collectionPage
+	}
+
+	collection, err := json.Marshal(resource)
+	if err != nil {
+		panic(err)
+	}
+	writer.Header().Add("Content-Type", "application/activity+json")
+	writer.WriteHeader(200)
+	writer.Write(collection)
+}
+
+func (tenant *Tenant) handleFollowers(writer http.ResponseWriter, request *http.Request) {
+	var followers []string
+	for _, subscription := range tenant.relayState.Subscriptions {
+		if subscription.ActorID != "" && len(subscription.Topics) == 0 {
+			followers = append(followers, subscription.ActorID)
+		}
+	}
+	handleCollection(writer, request, tenant.Actor.Followers, followers)
+}
+
+func (tenant *Tenant) handleFollowing(writer http.ResponseWriter, request *http.Request) {
+	var following []string
+	for _, subscription := range tenant.relayState.Subscriptions {
+		if subscription.IsLitePub() && subscription.ActorID != "" && len(subscription.Topics) == 0 {
+			following = append(following, subscription.ActorID)
+		}
+	}
+	handleCollection(writer, request, tenant.Actor.Following, following)
+}
+
+func (tenant *Tenant) handleOutbox(writer http.ResponseWriter, request *http.Request) {
+	handleCollection(writer, request, tenant.Actor.Outbox, nil)
+}
+
 func contains(entries interface{}, finder string) bool {
 	switch entry := entries.(type) {
 	case string:
@@ -106,12 +170,63 @@ func contains(entries interface{}, finder string) bool {
 	return false
 }
 
-func pushRelayJob(sourceInbox string, body []byte) {
-	for _, domain := range relayState.Subscriptions {
-		if sourceInbox != domain.Domain {
+// pushRelayJob : Fan out activity to subscribers, except source and subscribers which do not want it by their preferences.
+func (tenant *Tenant) pushRelayJob(sourceInbox string, activity *activitypub.Activity, body []byte, announceBody []byte) {
+	ttl := viper.GetDuration("payload_ttl")
+	payloadKey, err := state.StorePayload(tenant.relayState.RedisClient, body, ttl)
+	if err != nil {
+		logger.WithError(err).Error("Failed store relay payload")
+		tenant.forgetDelivery(activity)
+		return
+	}
+	announceKey := payloadKey
+	if string(announceBody) != string(body) {
+		announceKey, err = state.StorePayload(tenant.relayState.RedisClient, announceBody, ttl)
+		if err != nil {
+			logger.WithError(err).Error("Failed store relay payload")
+			tenant.forgetDelivery(activity)
+			return
+		}
+	}
+	topicAnnounceKeys := map[string]string{}
+	for _, domain := range tenant.relayState.Subscriptions {
+		if sourceInbox != domain.Domain && domain.Preferences.Accept(activity.Type, activity.Object) {
+			topic, subscribed := tenant.relayState.SubscribedTopic(&domain, activity.Type, activity.Object)
+			if !subscribed {
+				continue
+			}
+			headers := tasks.Headers{state.TenantJobHeader: tenant.hostURL.Host}
+			if tenant.relayState.IsUnreachable(domain.Domain) {
+				// Unreachable subscriber receives one probe delivery per interval, which marks it as reachable when succeeded.
+				if !tenant.relayState.ClaimProbe(domain.Domain, viper.GetDuration("subscriber_probe_interval")) {
+					continue
+				}
+				headers[state.ProbeJobHeader] = true
+			}
+			relayKey := payloadKey
+			if domain.DeliverAnnounce() {
+				relayKey = announceKey
+			}
+			if domain.DeliverAnnounce() && topic != nil {
+				// Topic subscribers receive Announce by topic actor which they follow.
+				if _, ok := topicAnnounceKeys[topic.Name]; !ok {
+					topicAnnounceKeys[topic.Name] = announceKey
+					topicAnnounce := tenant.litePubAnnounce(activity, announceBody, tenant.relayURL(topic.Name))
+					if string(topicAnnounce) != string(announceBody) {
+						topicAnnounceKeys[topic.Name], err = state.StorePayload(tenant.relayState.RedisClient, topicAnnounce, ttl)
+						if err != nil {
+							logger.WithError(err).Error("Failed store relay payload")
+							tenant.forgetDelivery(activity)
+							return
+						}
+					}
+				}
+				relayKey = topicAnnounceKeys[topic.Name]
+			}
 			job := &tasks.Signature{
 				Name:       "relay",
 				RetryCount: 0,
+				Headers:    headers,
 				Args: []tasks.Arg{
 					{
 						Name:  "inboxURL",
@@ -119,24 +234,27 @@ func pushRelayJob(sourceInbox string, body []byte) {
 						Value: domain.InboxURL,
 					},
 					{
-						Name:  "body",
+						Name:  "payloadKey",
 						Type:  "string",
-						Value: string(body),
+						Value: relayKey,
 					},
 				},
 			}
 			_, err := machineryServer.SendTask(job)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
+				logger.WithError(err).WithField(logger.Inbox, domain.InboxURL).Error("Failed enqueue relay job")
+			} else {
+				metrics.JobsEnqueued.WithLabelValues("relay").Inc()
 			}
 		}
 	}
 }
 
-func pushRegistorJob(inboxURL string, body []byte) {
+func (tenant *Tenant) pushRegistorJob(inboxURL string, body []byte) {
 	job := &tasks.Signature{
 		Name:       "registor",
 		RetryCount: 2,
+		Headers:    tasks.Headers{state.TenantJobHeader: tenant.hostURL.Host},
 		Args: []tasks.Arg{
 			{
 				Name:  "inboxURL",
@@ -152,165 +270,347 @@ func pushRegistorJob(inboxURL string, body []byte) {
 	}
 	_, err := machineryServer.SendTask(job)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).WithField(logger.Inbox, inboxURL).Error("Failed enqueue registor job")
+	} else {
+		metrics.JobsEnqueued.WithLabelValues("registor").Inc()
 	}
 }
 
-func followAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
+func (tenant *Tenant) followAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
 	if contains(activity.Object, "https://www.w3.org/ns/activitystreams#Public") {
 		return nil
+	} else if _, ok := tenant.relayActorTopic(activity.Object); ok {
+		return nil
 	} else {
-		return errors.New("Follow only allowed for https://www.w3.org/ns/activitystreams#Public")
+		return errors.New("Follow only allowed for https://www.w3.org/ns/activitystreams#Public or relay actor")
 	}
 }
 
-func unFollowAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
+func (tenant *Tenant) unFollowAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
 	if contains(activity.Object, "https://www.w3.org/ns/activitystreams#Public") {
 		return nil
-	} else {
-		return errors.New("Unfollow only allowed for https://www.w3.org/ns/activitystreams#Public")
 	}
+	domain, _ := url.Parse(activity.Actor)
+	subscription := tenant.relayState.SelectSubscription(domain.Host)
+	if _, ok := tenant.relayActorTopic(activity.Object); ok && subscription != nil && subscription.IsLitePub() {
+		return nil
+	}
+	return errors.New("Unfollow only allowed for https://www.w3.org/ns/activitystreams#Public or relay actor (LitePub)")
 }
 
-func suitableFollow(activity *activitypub.Activity, actor *activitypub.Actor) bool {
+func (tenant *Tenant) followProtocol(activity *activitypub.Activity) string {
+	if _, ok := tenant.relayActorTopic(activity.Object); ok {
+		return state.LitePubProtocol
+	}
+	return state.MastodonProtocol
+}
+
+// litePubAnnounce : Generate Announce of relayed object by relay actor at base URL. Returns body when activity is not announceable.
+// Sensitive object is embedded, so receivers which take embedded object keep content warning applied by filter.
+func (tenant *Tenant) litePubAnnounce(activity *activitypub.Activity, body []byte, base *url.URL) []byte {
+	var announce activitypub.Activity
+	switch activity.Type {
+	case "Create":
+		nestedObject, err := activity.NestedActivity()
+		if err != nil {
+			return body
+		}
+		announce = nestedObject.GenerateAnnounce(base)
+		if object, ok := activity.Object.(map[string]interface{}); ok && object["sensitive"] == true {
+			announce.Object = object
+		}
+	case "Announce":
+		objectID, ok := activity.Object.(string)
+		if !ok {
+			return body
+		}
+		object := activitypub.Activity{ID: objectID}
+		announce = object.GenerateAnnounce(base)
+	default:
+		return body
+	}
+	tenant.markRelayVia(&announce, activity)
+	jsonData, err := json.Marshal(&announce)
+	if err != nil {
+		return body
+	}
+	return jsonData
+}
+
+func (tenant *Tenant) suitableFollow(activity *activitypub.Activity, actor *activitypub.Actor) bool {
 	domain, _ := url.Parse(activity.Actor)
-	if contains(relayState.BlockedDomains, domain.Host) {
+	if contains(tenant.relayState.BlockedDomains, domain.Host) {
 		return false
 	}
 	return true
 }
 
-func relayAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
+func (tenant *Tenant) relayAcceptable(activity *activitypub.Activity, actor *activitypub.Actor) error {
+	domain, _ := url.Parse(activity.Actor)
 	if !contains(activity.To, "https://www.w3.org/ns/activitystreams#Public") && !contains(activity.Cc, "https://www.w3.org/ns/activitystreams#Public") {
 		return errors.New("Activity should contain https://www.w3.org/ns/activitystreams#Public as receiver")
 	}
-	domain, _ := url.Parse(activity.Actor)
-	if contains(relayState.Subscriptions, domain.Host) {
+	if contains(tenant.relayState.Subscriptions, domain.Host) || tenant.relayState.IsAcceptedPeer(domain.Host) {
 		return nil
 	}
 	return errors.New("To use the relay service, Subscribe me in advance")
 }
 
-func suitableRelay(activity *activitypub.Activity, actor *activitypub.Actor) bool {
+// activityObjectID : ID of object which Create or Announce carries
+func activityObjectID(activity *activitypub.Activity) string {
+	switch object := activity.Object.(type) {
+	case string:
+		return object
+	case map[string]interface{}:
+		id, _ := object["id"].(string)
+		return id
+	}
+	return ""
+}
+
+// deliveryIDs : IDs which identify activity within dedupe window. Create and Announce are identified by their object too.
+func deliveryIDs(activity *activitypub.Activity) []string {
+	ids := []string{activity.ID}
+	switch activity.Type {
+	case "Create", "Announce":
+		ids = append(ids, activityObjectID(activity))
+	}
+	return ids
+}
+
+// firstDelivery : Check activity is not relayed within dedupe window. Duplicated activity is counted.
+func (tenant *Tenant) firstDelivery(activity *activitypub.Activity) bool {
+	first, err := tenant.relayState.MarkActivitySeen(deliveryIDs(activity), viper.GetDuration("dedupe_window"))
+	if err != nil {
+		logger.WithError(err).WithField(logger.ActivityID, activity.ID).Error("Failed check duplicated activity")
+		return true
+	}
+	if !first {
+		metrics.DuplicateActivities.WithLabelValues(inboxActivityType(activity.Type)).Inc()
+	}
+	return first
+}
+
+// forgetDelivery : Forget activity marked by firstDelivery, so it is relayed when it arrives again.
+func (tenant *Tenant) forgetDelivery(activity *activitypub.Activity) {
+	err := tenant.relayState.ForgetActivitySeen(deliveryIDs(activity))
+	if err != nil {
+		logger.WithError(err).WithField(logger.ActivityID, activity.ID).Error("Failed forget relayed activity")
+	}
+}
+
+func (tenant *Tenant) suitableRelay(activity *activitypub.Activity, actor *activitypub.Actor) bool {
 	domain, _ := url.Parse(activity.Actor)
-	if contains(relayState.LimitedDomains, domain.Host) {
+	if contains(tenant.relayState.LimitedDomains, domain.Host) {
 		return false
 	}
-	if relayState.RelayConfig.BlockService && actor.Type != "Person" {
+	if tenant.relayState.RelayConfig.BlockService && actor.Type != "Person" {
 		return false
 	}
 	return true
 }
 
-func handleInbox(writer http.ResponseWriter, request *http.Request, activityDecoder func(*http.Request) (*activitypub.Activity, *activitypub.Actor, []byte, error)) {
+// inboxRecorder : Remember status code written by inbox handler
+type inboxRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *inboxRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *inboxRecorder) outcome() string {
+	switch {
+	case recorder.status == 202:
+		return "accepted"
+	case recorder.status/100 == 4:
+		return "rejected"
+	default:
+		return "ignored"
+	}
+}
+
+// inboxActivityType : Metric label of activity type. Unknown types are folded, to keep label set bounded.
+func inboxActivityType(activityType string) string {
+	switch activityType {
+	case "Follow", "Undo", "Accept", "Reject", "Create", "Update", "Delete", "Announce", "Move":
+		return activityType
+	default:
+		return "other"
+	}
+}
+
+func (tenant *Tenant) handleInbox(writer http.ResponseWriter, request *http.Request, activityDecoder func(*http.Request) (*activitypub.Activity, *activitypub.Actor, []byte, error)) {
 	switch request.Method {
 	case "POST":
 		activity, actor, body, err := activityDecoder(request)
 		if err != nil {
+			metrics.InboxRequests.WithLabelValues("unknown", "invalid").Inc()
 			writer.WriteHeader(400)
 			writer.Write(nil)
 		} else {
+			log := logger.WithFields(logger.Fields{
+				logger.ActivityID: activity.ID,
+				logger.Actor:      activity.Actor,
+				"type":            activity.Type,
+			})
+			recorder := &inboxRecorder{writer, 200}
+			writer = recorder
+			defer func() {
+				metrics.InboxRequests.WithLabelValues(inboxActivityType(activity.Type), recorder.outcome()).Inc()
+			}()
 			domain, _ := url.Parse(activity.Actor)
+			if tenant.relayState.IsUnreachable(domain.Host) {
+				tenant.relayState.ResetHealth(domain.Host)
+				log.WithField(logger.Domain, domain.Host).Info("Subscriber is reachable again")
+			}
 			switch activity.Type {
 			case "Follow":
-				err = followAcceptable(activity, actor)
+				topic := tenant.activityTopic(request, activity)
+				if topic != "" {
+					log = log.WithField("topic", topic)
+				}
+				err = tenant.followAcceptable(activity, actor)
 				if err != nil {
-					resp := activity.GenerateResponse(hostURL, "Reject")
+					resp := activity.GenerateResponse(tenant.relayURL(topic), "Reject")
 					jsonData, _ := json.Marshal(&resp)
-					go pushRegistorJob(actor.Inbox, jsonData)
-					fmt.Println("Reject Follow Request : ", err.Error(), activity.Actor)
+					go tenant.pushRegistorJob(actor.Inbox, jsonData)
+					log.WithError(err).Info("Reject Follow Request")
 
 					writer.WriteHeader(202)
 					writer.Write(nil)
 				} else {
-					if suitableFollow(activity, actor) {
-						if relayState.RelayConfig.ManuallyAccept {
-							relayState.RedisClient.HMSet("relay:pending:"+domain.Host, map[string]interface{}{
-								"inbox_url":   actor.Endpoints.SharedInbox,
-								"activity_id": activity.ID,
-								"type":        "Follow",
-								"actor":       actor.ID,
-								"object":      activity.Object.(string),
+					if tenant.suitableFollow(activity, actor) {
+						if tenant.relayState.RelayConfig.ManuallyAccept {
+							tenant.relayState.AddPendingFollow(state.PendingFollow{
+								Domain:     domain.Host,
+								InboxURL:   actor.Endpoints.SharedInbox,
+								ActivityID: activity.ID,
+								Type:       "Follow",
+								Actor:      actor.ID,
+								Object:     activity.Object.(string),
+								Topic:      topic,
 							})
-							fmt.Println("Pending Follow Request : ", activity.Actor)
+							log.Info("Pending Follow Request")
 						} else {
-							resp := activity.GenerateResponse(hostURL, "Accept")
+							protocol := tenant.followProtocol(activity)
+							resp := activity.GenerateResponse(tenant.relayURL(topic), "Accept")
 							jsonData, _ := json.Marshal(&resp)
-							go pushRegistorJob(actor.Inbox, jsonData)
-							relayState.AddSubscription(state.Subscription{
+							if protocol == state.LitePubProtocol {
+								follow := actor.GenerateFollow(tenant.relayURL(topic))
+								followData, _ := json.Marshal(&follow)
+								go func() {
+									tenant.pushRegistorJob(actor.Inbox, jsonData)
+									tenant.pushRegistorJob(actor.Inbox, followData)
+								}()
+							} else {
+								go tenant.pushRegistorJob(actor.Inbox, jsonData)
+							}
+							tenant.relayState.AddTopicSubscription(state.Subscription{
 								Domain:     domain.Host,
 								InboxURL:   actor.Endpoints.SharedInbox,
 								ActivityID: activity.ID,
 								ActorID:    actor.ID,
-							})
-							fmt.Println("Accept Follow Request : ", activity.Actor)
+								Protocol:   protocol,
+							}, topic)
+							log.WithField("protocol", protocol).Info("Accept Follow Request")
 						}
 					} else {
-						resp := activity.GenerateResponse(hostURL, "Reject")
+						resp := activity.GenerateResponse(tenant.relayURL(topic), "Reject")
 						jsonData, _ := json.Marshal(&resp)
-						go pushRegistorJob(actor.Inbox, jsonData)
-						fmt.Println("Reject Follow Request : ", activity.Actor)
+						go tenant.pushRegistorJob(actor.Inbox, jsonData)
+						log.Info("Reject Follow Request")
 					}
 
 					writer.WriteHeader(202)
 					writer.Write(nil)
 				}
 			case "Undo":
-				nestedActivity, _ := activity.NestedActivity()
-				if nestedActivity.Type == "Follow" && nestedActivity.Actor == activity.Actor {
-					err = unFollowAcceptable(nestedActivity, actor)
+				nestedActivity, err := activity.NestedActivity()
+				if err == nil && nestedActivity.Type == "Follow" && nestedActivity.Actor == activity.Actor {
+					err = tenant.unFollowAcceptable(nestedActivity, actor)
 					if err != nil {
-						fmt.Println("Reject Unfollow Request : ", err.Error())
+						log.WithError(err).Info("Reject Unfollow Request")
 						writer.WriteHeader(400)
 						writer.Write([]byte(err.Error()))
 					} else {
-						relayState.DelSubscription(domain.Host)
-						fmt.Println("Accept Unfollow Request : ", activity.Actor)
+						tenant.relayState.DelTopicSubscription(domain.Host, tenant.activityTopic(request, nestedActivity))
+						log.Info("Accept Unfollow Request")
 
 						writer.WriteHeader(202)
 						writer.Write(nil)
 					}
 				} else {
-					err = relayAcceptable(activity, actor)
+					err = tenant.relayAcceptable(activity, actor)
 					if err != nil {
 						writer.WriteHeader(400)
 						writer.Write([]byte(err.Error()))
+					} else if tenant.relayedLoop(activity) {
+						log.Info("Skipping Looped Activity")
+
+						writer.WriteHeader(202)
+						writer.Write(nil)
+					} else if !tenant.firstDelivery(activity) {
+						log.Info("Skipping Duplicated Activity")
+
+						writer.WriteHeader(202)
+						writer.Write(nil)
 					} else {
 						domain, _ := url.Parse(activity.Actor)
-						go pushRelayJob(domain.Host, body)
-						fmt.Println("Accept Relay Status : ", activity.Actor)
+						go tenant.pushRelayJob(domain.Host, activity, body, body)
+						log.Info("Accept Relay Status")
 
 						writer.WriteHeader(202)
 						writer.Write(nil)
 					}
 				}
+			case "Accept", "Reject":
+				if tenant.peerResponse(activity) {
+					log.WithField(logger.Domain, domain.Host).Info("Peer Responded Follow")
+				} else {
+					log.Debug("Skipping Response")
+				}
+
+				writer.WriteHeader(202)
+				writer.Write(nil)
 			case "Create", "Update", "Delete", "Announce", "Move":
-				err = relayAcceptable(activity, actor)
+				err = tenant.relayAcceptable(activity, actor)
 				if err != nil {
 					writer.WriteHeader(400)
 					writer.Write([]byte(err.Error()))
 				} else {
-					if suitableRelay(activity, actor) {
-						if relayState.RelayConfig.CreateAsAnnounce && activity.Type == "Create" {
+					if !tenant.suitableRelay(activity, actor) {
+						log.Info("Skipping Relay Status")
+					} else if tenant.relayedLoop(activity) {
+						log.Info("Skipping Looped Activity")
+					} else if relay, filteredBody := tenant.filterActivity(activity, body); !relay {
+						log.Info("Skipping Filtered Activity")
+					} else if !tenant.firstDelivery(activity) {
+						log.Info("Skipping Duplicated Activity")
+					} else {
+						if tenant.relayState.IsAcceptedPeer(domain.Host) && (activity.Type == "Create" || activity.Type == "Announce") {
+							// Subscribers can not verify activity signed by peer relay, so relay announces it by itself.
+							announceBody := tenant.litePubAnnounce(activity, filteredBody, tenant.hostURL)
+							go tenant.pushRelayJob(domain.Host, activity, announceBody, announceBody)
+							log.Info("Accept Peer Status")
+						} else if tenant.relayState.RelayConfig.CreateAsAnnounce && activity.Type == "Create" {
 							nestedObject, err := activity.NestedActivity()
-							if err != nil {
-								fmt.Println("Fail Assert activity : activity.Actor")
-							}
-							switch nestedObject.Type {
-							case "Note":
-								resp := nestedObject.GenerateAnnounce(hostURL)
-								jsonData, _ := json.Marshal(&resp)
-								go pushRelayJob(domain.Host, jsonData)
-								fmt.Println("Accept Announce Note : ", activity.Actor)
+							switch {
+							case err != nil:
+								log.WithError(err).Warn("Fail Assert activity")
+							case nestedObject.Type == "Note":
+								announceBody := tenant.litePubAnnounce(activity, filteredBody, tenant.hostURL)
+								go tenant.pushRelayJob(domain.Host, activity, announceBody, announceBody)
+								log.Info("Accept Announce Note")
 							default:
-								fmt.Println("Skipping Announce", nestedObject.Type, ": ", activity.Actor)
+								log.WithField("object_type", nestedObject.Type).Info("Skipping Announce")
 							}
 						} else {
-							go pushRelayJob(domain.Host, body)
-							fmt.Println("Accept Relay Status : ", activity.Actor)
+							go tenant.pushRelayJob(domain.Host, activity, filteredBody, tenant.litePubAnnounce(activity, filteredBody, tenant.hostURL))
+							log.Info("Accept Relay Status")
 						}
-					} else {
-						fmt.Println("Skipping Relay Status : ", activity.Actor)
 					}
 
 					writer.WriteHeader(202)
@@ -10,7 +10,10 @@ import (
 	"os"
 	"strconv"
 	"testing"
+	"time"
 
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/spf13/viper"
 	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
@@ -22,12 +25,12 @@ const (
 )
 
 func TestHandleWebfingerGet(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleWebfinger))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleWebfinger))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
 	q := req.URL.Query()
-	q.Add("resource", "acct:relay@"+hostURL.Host)
+	q.Add("resource", "acct:relay@"+defaultTenant.hostURL.Host)
 	req.URL.RawQuery = q.Encode()
 	client := new(http.Client)
 	r, err := client.Do(req)
@@ -50,13 +53,13 @@ func TestHandleWebfingerGet(t *testing.T) {
 	}
 
 	domain, _ := url.Parse(wfresource.Links[0].Href)
-	if domain.Host != hostURL.Host {
+	if domain.Host != defaultTenant.hostURL.Host {
 		t.Fatalf("WebfingerResource's Host not valid.")
 	}
 }
 
 func TestHandleWebfingerGetBadResource(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleWebfinger))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleWebfinger))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
@@ -74,7 +77,7 @@ func TestHandleWebfingerGetBadResource(t *testing.T) {
 }
 
 func TestHandleNodeinfoLinkGet(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleNodeinfoLink))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleNodeinfoLink))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
@@ -100,7 +103,7 @@ func TestHandleNodeinfoLinkGet(t *testing.T) {
 }
 
 func TestHandleNodeinfoLinkInvalidMethod(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleNodeinfoLink))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleNodeinfoLink))
 	defer s.Close()
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
@@ -115,7 +118,7 @@ func TestHandleNodeinfoLinkInvalidMethod(t *testing.T) {
 }
 
 func TestHandleNodeinfoGet(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleNodeinfo))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleNodeinfo))
 	defer s.Close()
 
 	req, _ := http.NewRequest("GET", s.URL, nil)
@@ -141,7 +144,7 @@ func TestHandleNodeinfoGet(t *testing.T) {
 }
 
 func TestHandleNodeinfoInvalidMethod(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleNodeinfo))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleNodeinfo))
 	defer s.Close()
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
@@ -156,7 +159,7 @@ func TestHandleNodeinfoInvalidMethod(t *testing.T) {
 }
 
 func TestHandleWebfingerInvalidMethod(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleWebfinger))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleWebfinger))
 	defer s.Close()
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
@@ -171,7 +174,7 @@ func TestHandleWebfingerInvalidMethod(t *testing.T) {
 }
 
 func TestHandleActorGet(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleActor))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleActor))
 	defer s.Close()
 
 	r, err := http.Get(s.URL)
@@ -194,13 +197,13 @@ func TestHandleActorGet(t *testing.T) {
 	}
 
 	domain, _ := url.Parse(actor.ID)
-	if domain.Host != hostURL.Host {
+	if domain.Host != defaultTenant.hostURL.Host {
 		t.Fatalf("Actor's Host not valid.")
 	}
 }
 
 func TestHandleActorInvalidMethod(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(handleActor))
+	s := httptest.NewServer(tenantHandler((*Tenant).handleActor))
 	defer s.Close()
 
 	r, err := http.Post(s.URL, "text/plain", nil)
@@ -212,6 +215,122 @@ func TestHandleActorInvalidMethod(t *testing.T) {
 	}
 }
 
+func TestHandleFollowersGet(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleFollowers))
+	defer s.Close()
+
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "example.org",
+		InboxURL: "https://example.org/inbox",
+		ActorID:  "https://example.org/actor",
+	})
+
+	r, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 200 {
+		t.Fatalf("Failed - StatusCode is not 200.")
+	}
+	defer r.Body.Close()
+
+	data, _ := ioutil.ReadAll(r.Body)
+	var collection activitypub.OrderedCollection
+	err = json.Unmarshal(data, &collection)
+	if err != nil {
+		t.Fatalf("OrderedCollection response is not valid.")
+	}
+	if collection.ID != defaultTenant.Actor.Followers || collection.TotalItems != 1 || collection.First == "" {
+		t.Fatalf("OrderedCollection is not valid.")
+	}
+
+	r, err = http.Get(s.URL + "?page=1")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	defer r.Body.Close()
+
+	data, _ = ioutil.ReadAll(r.Body)
+	var collectionPage activitypub.OrderedCollectionPage
+	err = json.Unmarshal(data, &collectionPage)
+	if err != nil {
+		t.Fatalf("OrderedCollectionPage response is not valid.")
+	}
+	if len(collectionPage.OrderedItems) != 1 || collectionPage.OrderedItems[0] != "https://example.org/actor" {
+		t.Fatalf("OrderedCollectionPage items are not valid.")
+	}
+	if collectionPage.Next != "" {
+		t.Fatalf("OrderedCollectionPage should not have next page.")
+	}
+
+	defaultTenant.relayState.DelSubscription("example.org")
+}
+
+func TestHandleFollowersInvalidPage(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleFollowers))
+	defer s.Close()
+
+	r, err := http.Get(s.URL + "?page=0")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 400 {
+		t.Fatalf("Failed - StatusCode is not 400.")
+	}
+}
+
+func TestOrderedCollectionPaging(t *testing.T) {
+	var items []string
+	for i := 0; i < collectionPageSize+1; i++ {
+		items = append(items, "https://example.org/actor/"+strconv.Itoa(i))
+	}
+
+	var firstPage activitypub.OrderedCollectionPage
+	firstPage.GenerateFromItems(defaultTenant.Actor.Followers, items, 1, collectionPageSize)
+	if len(firstPage.OrderedItems) != collectionPageSize || firstPage.Next == "" || firstPage.Prev != "" {
+		t.Fatalf("First page is not valid.")
+	}
+
+	var secondPage activitypub.OrderedCollectionPage
+	secondPage.GenerateFromItems(defaultTenant.Actor.Followers, items, 2, collectionPageSize)
+	if len(secondPage.OrderedItems) != 1 || secondPage.Next != "" || secondPage.Prev == "" {
+		t.Fatalf("Second page is not valid.")
+	}
+}
+
+func TestHandleFollowingGet(t *testing.T) {
+	s := httptest.NewServer(tenantHandler((*Tenant).handleFollowing))
+	defer s.Close()
+
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "example.org",
+		InboxURL: "https://example.org/inbox",
+		ActorID:  "https://example.org/actor",
+	})
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "pleroma.example.org",
+		InboxURL: "https://pleroma.example.org/inbox",
+		ActorID:  "https://pleroma.example.org/relay",
+		Protocol: state.LitePubProtocol,
+	})
+
+	r, err := http.Get(s.URL + "?page=1")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	defer r.Body.Close()
+
+	data, _ := ioutil.ReadAll(r.Body)
+	var collectionPage activitypub.OrderedCollectionPage
+	json.Unmarshal(data, &collectionPage)
+	if len(collectionPage.OrderedItems) != 1 || collectionPage.OrderedItems[0] != "https://pleroma.example.org/relay" {
+		t.Fatalf("Following collection is not valid.")
+	}
+
+	defaultTenant.relayState.DelSubscription("example.org")
+	defaultTenant.relayState.DelSubscription("pleroma.example.org")
+}
+
 func TestContains(t *testing.T) {
 	data := "nil"
 	sData := []string{
@@ -282,6 +401,16 @@ func mockActivity(req string) activitypub.Activity {
 		var activity activitypub.Activity
 		json.Unmarshal([]byte(body), &activity)
 		return activity
+	case "LitePub-Follow":
+		body := "{\"@context\":\"https://www.w3.org/ns/activitystreams\",\"id\":\"https://pleroma.test.yukimochi.io/activities/0c8d1b4e-2b1d-4a52-9a3b-2a4ef0b2b6a1\",\"type\":\"Follow\",\"actor\":\"https://pleroma.test.yukimochi.io/relay\",\"object\":\"https://relay.yukimochi.example.org/actor\"}"
+		var activity activitypub.Activity
+		json.Unmarshal([]byte(body), &activity)
+		return activity
+	case "LitePub-Unfollow":
+		body := "{\"@context\":\"https://www.w3.org/ns/activitystreams\",\"id\":\"https://pleroma.test.yukimochi.io/activities/5f0e6c8e-7c43-4f0c-bd5e-8f5a2c1f5d77\",\"type\":\"Undo\",\"actor\":\"https://pleroma.test.yukimochi.io/relay\",\"object\":{\"id\":\"https://pleroma.test.yukimochi.io/activities/0c8d1b4e-2b1d-4a52-9a3b-2a4ef0b2b6a1\",\"type\":\"Follow\",\"actor\":\"https://pleroma.test.yukimochi.io/relay\",\"object\":\"https://relay.yukimochi.example.org/actor\"}}"
+		var activity activitypub.Activity
+		json.Unmarshal([]byte(body), &activity)
+		return activity
 	case "Create":
 		file, _ := os.Open("./misc/create.json")
 		body, _ := ioutil.ReadAll(file)
@@ -341,15 +470,15 @@ func TestSuitableRelayNoBlockService(t *testing.T) {
 	serviceActor := mockActor("Service")
 	applicationActor := mockActor("Application")
 
-	relayState.SetConfig(BlockService, false)
+	defaultTenant.relayState.SetConfig(BlockService, false)
 
-	if suitableRelay(&activity, &personActor) != true {
+	if defaultTenant.suitableRelay(&activity, &personActor) != true {
 		t.Fatalf("Failed - Person status not relay")
 	}
-	if suitableRelay(&activity, &serviceActor) != true {
+	if defaultTenant.suitableRelay(&activity, &serviceActor) != true {
 		t.Fatalf("Failed - Service status not relay")
 	}
-	if suitableRelay(&activity, &applicationActor) != true {
+	if defaultTenant.suitableRelay(&activity, &applicationActor) != true {
 		t.Fatalf("Failed - Service status not relay")
 	}
 }
@@ -360,23 +489,23 @@ func TestSuitableRelayBlockService(t *testing.T) {
 	serviceActor := mockActor("Service")
 	applicationActor := mockActor("Application")
 
-	relayState.SetConfig(BlockService, true)
+	defaultTenant.relayState.SetConfig(BlockService, true)
 
-	if suitableRelay(&activity, &personActor) != true {
+	if defaultTenant.suitableRelay(&activity, &personActor) != true {
 		t.Fatalf("Failed - Person status not relay")
 	}
-	if suitableRelay(&activity, &serviceActor) != false {
+	if defaultTenant.suitableRelay(&activity, &serviceActor) != false {
 		t.Fatalf("Failed - Service status may relay when blocking mode")
 	}
-	if suitableRelay(&activity, &applicationActor) != false {
+	if defaultTenant.suitableRelay(&activity, &applicationActor) != false {
 		t.Fatalf("Failed - Application status may relay when blocking mode")
 	}
-	relayState.SetConfig(BlockService, false)
+	defaultTenant.relayState.SetConfig(BlockService, false)
 }
 
 func TestHandleInboxNoSignature(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, decodeActivity)
+		defaultTenant.handleInbox(w, r, decodeActivity)
 	}))
 	defer s.Close()
 
@@ -393,7 +522,7 @@ func TestHandleInboxNoSignature(t *testing.T) {
 
 func TestHandleInboxInvalidMethod(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, decodeActivity)
+		defaultTenant.handleInbox(w, r, decodeActivity)
 	}))
 	defer s.Close()
 
@@ -413,7 +542,7 @@ func TestHandleInboxValidFollow(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
@@ -426,11 +555,11 @@ func TestHandleInboxValidFollow(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 1 {
 		t.Fatalf("Failed - Subscription not works.")
 	}
-	relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription(domain.Host)
 }
 
 func TestHandleInboxValidManuallyFollow(t *testing.T) {
@@ -438,12 +567,12 @@ func TestHandleInboxValidManuallyFollow(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
 	// Switch Manually
-	relayState.SetConfig(ManuallyAccept, true)
+	defaultTenant.relayState.SetConfig(ManuallyAccept, true)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -454,16 +583,16 @@ func TestHandleInboxValidManuallyFollow(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:pending:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:pending:" + domain.Host).Result()
 	if res != 1 {
 		t.Fatalf("Failed - Pending not works.")
 	}
-	res, _ = relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ = defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 0 {
 		t.Fatalf("Failed - Pending was skipped.")
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.SetConfig(ManuallyAccept, false)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.SetConfig(ManuallyAccept, false)
 }
 
 func TestHandleInboxInvalidFollow(t *testing.T) {
@@ -471,11 +600,11 @@ func TestHandleInboxInvalidFollow(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.SetConfig(ManuallyAccept, false)
+	defaultTenant.relayState.SetConfig(ManuallyAccept, false)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -486,7 +615,7 @@ func TestHandleInboxInvalidFollow(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 0 {
 		t.Fatalf("Failed - Subscription not blocked.")
 	}
@@ -497,11 +626,11 @@ func TestHandleInboxValidFollowBlocked(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.SetBlockedDomain(domain.Host, true)
+	defaultTenant.relayState.SetBlockedDomain(domain.Host, true)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -512,12 +641,91 @@ func TestHandleInboxValidFollowBlocked(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 0 {
 		t.Fatalf("Failed - Subscription not blocked.")
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.SetBlockedDomain(domain.Host, false)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.SetBlockedDomain(domain.Host, false)
+}
+
+func TestHandleInboxValidLitePubFollow(t *testing.T) {
+	activity := mockActivity("LitePub-Follow")
+	actor := mockActor("Service")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	defaultTenant.relayState.SetConfig(ManuallyAccept, false)
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+	res, _ := defaultTenant.relayState.RedisClient.HGet("relay:subscription:"+domain.Host, "protocol").Result()
+	if res != state.LitePubProtocol {
+		t.Fatalf("Failed - LitePub subscription not works.")
+	}
+	defaultTenant.relayState.DelSubscription(domain.Host)
+}
+
+func TestHandleInboxValidLitePubUnfollow(t *testing.T) {
+	activity := mockActivity("LitePub-Unfollow")
+	actor := mockActor("Service")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   domain.Host,
+		InboxURL: "https://pleroma.test.yukimochi.io/inbox",
+		Protocol: state.LitePubProtocol,
+	})
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	if res != 0 {
+		t.Fatalf("Failed - LitePub unsubscription not succeed.")
+	}
+	defaultTenant.relayState.DelSubscription(domain.Host)
+}
+
+func TestLitePubAnnounce(t *testing.T) {
+	activity := mockActivity("Create")
+	body := defaultTenant.litePubAnnounce(&activity, []byte("raw"), defaultTenant.hostURL)
+
+	var announce activitypub.Activity
+	err := json.Unmarshal(body, &announce)
+	if err != nil {
+		t.Fatalf("Failed - Announce not generated.")
+	}
+	nestedObject, _ := activity.NestedActivity()
+	if announce.Type != "Announce" || announce.Object != nestedObject.ID {
+		t.Fatalf("Failed - Announce object is not valid.")
+	}
+
+	activity = mockActivity("Undo")
+	body = defaultTenant.litePubAnnounce(&activity, []byte("raw"), defaultTenant.hostURL)
+	if string(body) != "raw" {
+		t.Fatalf("Failed - Undo should be relayed as is.")
+	}
 }
 
 func TestHandleInboxValidUnfollow(t *testing.T) {
@@ -525,11 +733,11 @@ func TestHandleInboxValidUnfollow(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
@@ -543,11 +751,11 @@ func TestHandleInboxValidUnfollow(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 0 {
 		t.Fatalf("Failed - Subscription not succeed.")
 	}
-	relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription(domain.Host)
 }
 
 func TestHandleInboxInvalidUnfollow(t *testing.T) {
@@ -555,11 +763,11 @@ func TestHandleInboxInvalidUnfollow(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
@@ -573,11 +781,11 @@ func TestHandleInboxInvalidUnfollow(t *testing.T) {
 	if r.StatusCode != 400 {
 		t.Fatalf("Failed - StatusCode is not 400")
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 1 {
 		t.Fatalf("Failed - Block hacked unfollow not succeed.")
 	}
-	relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription(domain.Host)
 }
 
 func TestHandleInboxUnfollowAsActor(t *testing.T) {
@@ -585,11 +793,11 @@ func TestHandleInboxUnfollowAsActor(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
@@ -603,11 +811,11 @@ func TestHandleInboxUnfollowAsActor(t *testing.T) {
 	if r.StatusCode != 400 {
 		t.Fatalf("Failed - StatusCode is not 400")
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 1 {
 		t.Fatalf("Failed - Block actor unfollow not succeed.")
 	}
-	relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription(domain.Host)
 }
 
 func TestHandleInboxValidCreate(t *testing.T) {
@@ -615,15 +823,15 @@ func TestHandleInboxValidCreate(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "example.org",
 		InboxURL: "https://example.org/inbox",
 	})
@@ -637,10 +845,10 @@ func TestHandleInboxValidCreate(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.DelSubscription("example.org")
-	relayState.RedisClient.Del("relay:subscription:" + domain.Host).Result()
-	relayState.RedisClient.Del("relay:subscription:example.org").Result()
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription("example.org")
+	defaultTenant.relayState.RedisClient.Del("relay:subscription:" + domain.Host).Result()
+	defaultTenant.relayState.RedisClient.Del("relay:subscription:example.org").Result()
 }
 
 func TestHandleInboxlimitedCreate(t *testing.T) {
@@ -648,15 +856,15 @@ func TestHandleInboxlimitedCreate(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
-	relayState.SetLimitedDomain(domain.Host, true)
+	defaultTenant.relayState.SetLimitedDomain(domain.Host, true)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -667,8 +875,8 @@ func TestHandleInboxlimitedCreate(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.SetLimitedDomain(domain.Host, false)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.SetLimitedDomain(domain.Host, false)
 }
 
 func TestHandleInboxValidCreateAsAnnounceNote(t *testing.T) {
@@ -676,19 +884,19 @@ func TestHandleInboxValidCreateAsAnnounceNote(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "example.org",
 		InboxURL: "https://example.org/inbox",
 	})
-	relayState.SetConfig(CreateAsAnnounce, true)
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, true)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -699,9 +907,9 @@ func TestHandleInboxValidCreateAsAnnounceNote(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.DelSubscription("example.org")
-	relayState.SetConfig(CreateAsAnnounce, false)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription("example.org")
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, false)
 }
 
 func TestHandleInboxValidCreateAsAnnounceNoNote(t *testing.T) {
@@ -709,19 +917,19 @@ func TestHandleInboxValidCreateAsAnnounceNoNote(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   "example.org",
 		InboxURL: "https://example.org/inbox",
 	})
-	relayState.SetConfig(CreateAsAnnounce, true)
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, true)
 
 	req, _ := http.NewRequest("POST", s.URL, nil)
 	client := new(http.Client)
@@ -732,16 +940,16 @@ func TestHandleInboxValidCreateAsAnnounceNoNote(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	relayState.DelSubscription(domain.Host)
-	relayState.DelSubscription("example.org")
-	relayState.SetConfig(CreateAsAnnounce, false)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription("example.org")
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, false)
 }
 
 func TestHandleInboxUnsubscriptionCreate(t *testing.T) {
 	activity := mockActivity("Create")
 	actor := mockActor("Person")
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
@@ -761,11 +969,11 @@ func TestHandleInboxUndo(t *testing.T) {
 	actor := mockActor("Person")
 	domain, _ := url.Parse(activity.Actor)
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
 	}))
 	defer s.Close()
 
-	relayState.AddSubscription(state.Subscription{
+	defaultTenant.relayState.AddSubscription(state.Subscription{
 		Domain:   domain.Host,
 		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
 	})
@@ -779,9 +987,150 @@ func TestHandleInboxUndo(t *testing.T) {
 	if r.StatusCode != 202 {
 		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
 	}
-	res, _ := relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
+	res, _ := defaultTenant.relayState.RedisClient.Exists("relay:subscription:" + domain.Host).Result()
 	if res != 1 {
 		t.Fatalf("Failed - Missing unsubscribed.")
 	}
-	relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.DelSubscription(domain.Host)
+}
+
+func TestForgetDelivery(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+
+	activity := mockActivity("Create")
+	if !defaultTenant.firstDelivery(&activity) {
+		t.Fatalf("Failed - First delivery detected as duplicated.")
+	}
+	defaultTenant.forgetDelivery(&activity)
+	if !defaultTenant.firstDelivery(&activity) {
+		t.Fatalf("Failed - Forgotten activity detected as duplicated.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+}
+
+func TestPushRelayJobStorePayloadOnce(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		defaultTenant.relayState.AddSubscription(state.Subscription{
+			Domain:   domain,
+			InboxURL: "https://" + domain + "/inbox",
+		})
+	}
+	defaultTenant.relayState.Load()
+
+	activity := mockActivity("Create")
+	defaultTenant.pushRelayJob("a.example.com", &activity, []byte("data"), []byte("data"))
+
+	keys, _ := defaultTenant.relayState.RedisClient.Keys("relay:payload:*").Result()
+	if len(keys) != 1 {
+		t.Fatalf("Failed - Payload not stored once.")
+	}
+	jobs, _ := defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	if len(jobs) != 2 {
+		t.Fatalf("Failed - Relay jobs not enqueued.")
+	}
+	for _, job := range jobs {
+		var signature tasks.Signature
+		json.Unmarshal([]byte(job), &signature)
+		if signature.Args[1].Value != keys[0] {
+			t.Fatalf("Failed - Relay job carries payload.")
+		}
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestPushRelayJobPreferences(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	for _, domain := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		defaultTenant.relayState.AddSubscription(state.Subscription{
+			Domain:   domain,
+			InboxURL: "https://" + domain + "/inbox",
+		})
+	}
+	defaultTenant.relayState.SetPreferences("b.example.com", &state.Preferences{Languages: []string{"en"}})
+	defaultTenant.relayState.SetPreferences("c.example.com", &state.Preferences{Languages: []string{"ja"}, Format: state.DeliverAnnounce})
+
+	activity := mockActivity("Create")
+	defaultTenant.pushRelayJob("a.example.com", &activity, []byte("data"), []byte("announce"))
+
+	jobs, _ := defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	if len(jobs) != 1 {
+		t.Fatalf("Failed - Preferences are not evaluated.")
+	}
+	var signature tasks.Signature
+	json.Unmarshal([]byte(jobs[0]), &signature)
+	payload, _ := state.LoadPayload(defaultTenant.relayState.RedisClient, signature.Args[1].Value.(string))
+	if signature.Args[0].Value != "https://c.example.com/inbox" || string(payload) != "announce" {
+		t.Fatalf("Failed - Announce is not delivered by preferences.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
+
+func TestHandleInboxCreateAsAnnounceObjectID(t *testing.T) {
+	activity := mockActivity("Create")
+	activity.Object = "https://mastodon.test.yukimochi.io/users/yukimochi/statuses/1"
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, true)
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	r, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.SetConfig(CreateAsAnnounce, false)
+}
+
+func TestPushRelayJobProbeUnreachable(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "b.example.com",
+		InboxURL: "https://b.example.com/inbox",
+	})
+	defaultTenant.relayState.RecordDeliveryFailure("b.example.com", 1, 0)
+	defaultTenant.relayState.Load()
+	activity := mockActivity("Create")
+
+	defaultTenant.pushRelayJob("a.example.com", &activity, []byte("data"), []byte("data"))
+	jobs, _ := defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	if len(jobs) != 0 {
+		t.Fatalf("Failed - Delivered to unreachable subscriber before probe interval.")
+	}
+
+	health := defaultTenant.relayState.SelectHealth("b.example.com")
+	health.LastFailure = time.Now().Add(-viper.GetDuration("subscriber_probe_interval"))
+	defaultTenant.relayState.Backend.PutHealth(health)
+	defaultTenant.pushRelayJob("a.example.com", &activity, []byte("data"), []byte("data"))
+	defaultTenant.pushRelayJob("a.example.com", &activity, []byte("data"), []byte("data"))
+	jobs, _ = defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	if len(jobs) != 1 {
+		t.Fatalf("Failed - Probe is not enqueued once per interval.")
+	}
+	var signature tasks.Signature
+	json.Unmarshal([]byte(jobs[0]), &signature)
+	if signature.Headers[state.ProbeJobHeader] != true {
+		t.Fatalf("Failed - Probe is not marked.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
 }
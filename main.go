@@ -1,68 +1,122 @@
 package main
 
 import (
-	"crypto/rsa"
-	"fmt"
 	"net/http"
-	"net/url"
-	"time"
+	"os"
 
 	"github.com/RichardKnop/machinery/v1"
 	"github.com/RichardKnop/machinery/v1/config"
 	"github.com/go-redis/redis"
-	cache "github.com/patrickmn/go-cache"
 	"github.com/spf13/viper"
-	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
-	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	actorcache "github.com/yukimochi/Activity-Relay/ActorCache"
+	delivery "github.com/yukimochi/Activity-Relay/Delivery"
+	httpclient "github.com/yukimochi/Activity-Relay/HTTPClient"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
 	state "github.com/yukimochi/Activity-Relay/State"
 )
 
 var (
 	version string
 
-	// Actor : Relay's Actor
-	Actor activitypub.Actor
-
-	// WebfingerResource : Relay's Webfinger resource
-	WebfingerResource activitypub.WebfingerResource
-
-	// Nodeinfo : Relay's Nodeinfo
-	Nodeinfo activitypub.NodeinfoResources
-
-	hostURL         *url.URL
-	hostPrivatekey  *rsa.PrivateKey
-	relayState      state.RelayState
 	machineryServer *machinery.Server
-	actorCache      *cache.Cache
+	actorCache      *actorcache.Cache
 )
 
 func initConfig() {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
+	viper.SetDefault("payload_ttl", "24h")
+	viper.SetDefault("dedupe_window", "24h")
+	viper.SetDefault("subscriber_probe_interval", "1h")
+	viper.SetDefault("signature_clock_skew", "1h")
+	viper.SetDefault("actor_cache_size", 10000)
+	viper.SetDefault("actor_cache_ttl", "1h")
+	viper.SetDefault("actor_cache_negative_ttl", "1h")
+	viper.SetDefault("http_timeout", "5s")
+	viper.SetDefault("http_max_body_size", 1048576)
+	viper.SetDefault("http_max_redirects", 3)
+	viper.SetDefault("storage_backend", "redis")
+	viper.SetDefault("storage_path", "relay.db")
+	viper.SetDefault("admin_dashboard_path", "/admin/")
+	viper.SetDefault("server_metrics_bind", "0.0.0.0:8082")
+	viper.SetDefault("embedded_worker", false)
+	delivery.SetDefaults()
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "text")
 	err := viper.ReadInConfig()
 	if err != nil {
-		fmt.Println("Config file is not exists. Use environment variables.")
+		logger.Info("Config file is not exists. Use environment variables.")
 		viper.BindEnv("actor_pem")
 		viper.BindEnv("redis_url")
 		viper.BindEnv("relay_bind")
 		viper.BindEnv("relay_domain")
 		viper.BindEnv("relay_servicename")
-	} else {
-		Actor.Summary = viper.GetString("relay_summary")
-		Actor.Icon = activitypub.Image{URL: viper.GetString("relay_icon")}
-		Actor.Image = activitypub.Image{URL: viper.GetString("relay_image")}
+		viper.BindEnv("payload_ttl")
+		viper.BindEnv("dedupe_window")
+		viper.BindEnv("subscriber_probe_interval")
+		viper.BindEnv("signature_clock_skew")
+		viper.BindEnv("actor_cache_size")
+		viper.BindEnv("actor_cache_ttl")
+		viper.BindEnv("actor_cache_negative_ttl")
+		viper.BindEnv("http_timeout")
+		viper.BindEnv("http_max_body_size")
+		viper.BindEnv("http_max_redirects")
+		viper.BindEnv("http_allowed_networks")
+		viper.BindEnv("storage_backend")
+		viper.BindEnv("storage_path")
+		viper.BindEnv("admin_dashboard_path")
+		viper.BindEnv("server_metrics_bind")
+		viper.BindEnv("embedded_worker")
+		delivery.BindEnv()
+		viper.BindEnv("log_level")
+		viper.BindEnv("log_format")
 	}
-	Actor.Name = viper.GetString("relay_servicename")
+	err = logger.Configure(viper.GetString("log_level"), viper.GetString("log_format"))
+	if err != nil {
+		panic(err)
+	}
+	logger.BridgeMachinery()
+
+	dashboardPath = normalizeDashboardPath(viper.GetString("admin_dashboard_path"))
 
-	hostURL, _ = url.Parse("https://" + viper.GetString("relay_domain"))
-	hostPrivatekey, _ = keyloader.ReadPrivateKeyRSAfromPath(viper.GetString("actor_pem"))
+	var tenantConfigs []state.Tenant
+	err = viper.UnmarshalKey("tenants", &tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
+	tenantConfigs, err = state.NormalizeTenants(state.Tenant{
+		Domain:      viper.GetString("relay_domain"),
+		ActorPem:    viper.GetString("actor_pem"),
+		ServiceName: viper.GetString("relay_servicename"),
+		Summary:     viper.GetString("relay_summary"),
+		Icon:        viper.GetString("relay_icon"),
+		Image:       viper.GetString("relay_image"),
+		StoragePath: viper.GetString("storage_path"),
+	}, tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
 	redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
 	if err != nil {
 		panic(err)
 	}
 	redisClient := redis.NewClient(redisOption)
-	relayState = state.NewState(redisClient, true)
-	relayState.ListenNotify(nil)
+	tenants = map[string]*Tenant{}
+	var tenantDomains []string
+	for i, config := range tenantConfigs {
+		tenant, err := newTenant(config, redisClient)
+		if err != nil {
+			panic(err)
+		}
+		tenant.relayState.ListenNotify(nil)
+		metrics.RegisterStateGauges(config.Domain, &tenant.relayState)
+		tenants[config.Domain] = tenant
+		tenantDomains = append(tenantDomains, config.Domain)
+		if i == 0 {
+			defaultTenant = tenant
+		}
+	}
 	machineryConfig := &config.Config{
 		Broker:          viper.GetString("redis_url"),
 		DefaultQueue:    "relay",
@@ -73,40 +127,92 @@ func initConfig() {
 	if err != nil {
 		panic(err)
 	}
-
-	Actor.GenerateSelfKey(hostURL, &hostPrivatekey.PublicKey)
-	actorCache = cache.New(5*time.Minute, 10*time.Minute)
-	WebfingerResource.GenerateFromActor(hostURL, &Actor)
-	Nodeinfo.GenerateFromActor(hostURL, &Actor, version)
-
-	fmt.Println("Welcome to YUKIMOCHI Activity-Relay [Server]", version)
-	fmt.Println(" - Configurations")
-	fmt.Println("RELAY DOMAIN : ", hostURL.Host)
-	fmt.Println("REDIS URL : ", viper.GetString("redis_url"))
-	fmt.Println("BIND ADDRESS : ", viper.GetString("relay_bind"))
-	fmt.Println(" - Blocked Domain")
-	domains, _ := redisClient.HKeys("relay:config:blockedDomain").Result()
-	for _, domain := range domains {
-		fmt.Println(domain)
+	if embeddedWorker() {
+		err = delivery.Setup(version, redisClient, tenantConfigs)
+		if err != nil {
+			panic(err)
+		}
+		err = delivery.RegisterTasks(machineryServer)
+		if err != nil {
+			panic(err)
+		}
+		metrics.RegisterQueueGauges(redisClient, "relay")
 	}
-	fmt.Println(" - Limited Domain")
-	domains, _ = redisClient.HKeys("relay:config:limitedDomain").Result()
-	for _, domain := range domains {
-		fmt.Println(domain)
+
+	actorCache = actorcache.New(viper.GetInt("actor_cache_size"), redisClient, viper.GetDuration("actor_cache_ttl"), viper.GetDuration("actor_cache_negative_ttl"))
+	actorCache.Client, err = httpclient.FromConfig()
+	if err != nil {
+		panic(err)
 	}
+
+	logger.WithFields(logger.Fields{
+		"version":         version,
+		"relay_domain":    defaultTenant.hostURL.Host,
+		"tenants":         tenantDomains,
+		"redis_url":       viper.GetString("redis_url"),
+		"bind_address":    viper.GetString("relay_bind"),
+		"payload_ttl":     viper.GetDuration("payload_ttl").String(),
+		"dedupe_window":   viper.GetDuration("dedupe_window").String(),
+		"clock_skew":      viper.GetDuration("signature_clock_skew").String(),
+		"actor_cache_ttl": viper.GetDuration("actor_cache_ttl").String(),
+		"storage_backend": viper.GetString("storage_backend"),
+		"embedded_worker": embeddedWorker(),
+		"admin_dashboard": dashboardPath,
+		"metrics_bind":    viper.GetString("server_metrics_bind"),
+		"blocked_domains": defaultTenant.relayState.BlockedDomains,
+		"limited_domains": defaultTenant.relayState.LimitedDomains,
+	}).Info("Welcome to YUKIMOCHI Activity-Relay [Server]")
+}
+
+// embeddedWorker : Check server processes jobs by itself. BoltDB file can not be opened by separate worker, so bolt backend always embeds worker.
+func embeddedWorker() bool {
+	return viper.GetBool("embedded_worker") || viper.GetString("storage_backend") == "bolt"
 }
 
 func main() {
 	// Load Config
 	initConfig()
 
-	http.HandleFunc("/.well-known/nodeinfo", handleNodeinfoLink)
-	http.HandleFunc("/.well-known/webfinger", handleWebfinger)
-	http.HandleFunc("/nodeinfo/2.1", handleNodeinfo)
-	http.HandleFunc("/actor", handleActor)
-	http.HandleFunc("/inbox", func(w http.ResponseWriter, r *http.Request) {
-		handleInbox(w, r, decodeActivity)
-	})
+	http.HandleFunc("/.well-known/nodeinfo", tenantHandler((*Tenant).handleNodeinfoLink))
+	http.HandleFunc("/.well-known/webfinger", tenantHandler((*Tenant).handleWebfinger))
+	http.HandleFunc("/nodeinfo/2.1", tenantHandler((*Tenant).handleNodeinfo))
+	http.HandleFunc("/actor", tenantHandler((*Tenant).handleActor))
+	http.HandleFunc("/actor/followers", tenantHandler((*Tenant).handleFollowers))
+	http.HandleFunc("/actor/following", tenantHandler((*Tenant).handleFollowing))
+	http.HandleFunc("/actor/outbox", tenantHandler((*Tenant).handleOutbox))
+	http.HandleFunc(adminAPIPrefix, tenantHandler((*Tenant).handleAdminAPI))
+	http.HandleFunc(dashboardPath, tenantHandler((*Tenant).handleDashboard))
+	http.HandleFunc("/", tenantHandler((*Tenant).handleTopic))
+	http.HandleFunc("/inbox", tenantHandler(func(tenant *Tenant, w http.ResponseWriter, r *http.Request) {
+		tenant.handleInbox(w, r, decodeActivity)
+	}))
+
+	// Metrics are served apart from relay_bind, which is public.
+	if viper.GetString("server_metrics_bind") != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			err := http.ListenAndServe(viper.GetString("server_metrics_bind"), mux)
+			if err != nil {
+				logger.WithError(err).Error("Failed serve metrics")
+			}
+		}()
+	}
 
-	http.ListenAndServe(viper.GetString("relay_bind"), nil)
+	if embeddedWorker() {
+		go func() {
+			// Worker handles SIGINT and SIGTERM, so server exits when worker stops.
+			err := delivery.Launch(machineryServer)
+			if err != nil && err != machinery.ErrWorkerQuitGracefully {
+				logger.WithError(err).Error("Worker stopped")
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}()
+	}
+
+	err := http.ListenAndServe(viper.GetString("relay_bind"), nil)
+	if err != nil {
+		logger.WithError(err).Error("Failed serve HTTP")
+	}
 }
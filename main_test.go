@@ -11,11 +11,29 @@ import (
 func TestMain(m *testing.M) {
 	viper.Set("actor_pem", "misc/testKey.pem")
 	viper.Set("relay_domain", "relay.yukimochi.example.org")
+	// Remote instances are stood in by test servers on loopback.
+	viper.Set("http_allowed_networks", []string{"127.0.0.0/8"})
 	initConfig()
-	relayState = state.NewState(relayState.RedisClient, false)
+	defaultTenant.relayState = state.NewState(defaultTenant.relayState.RedisClient, false)
 
 	// Load Config
 	code := m.Run()
 	os.Exit(code)
-	relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+}
+
+func TestEmbeddedWorker(t *testing.T) {
+	if embeddedWorker() {
+		t.Fatalf("Failed - Worker is embedded by default.")
+	}
+	viper.Set("embedded_worker", true)
+	if !embeddedWorker() {
+		t.Fatalf("Failed - Worker is not embedded by embedded_worker.")
+	}
+	viper.Set("embedded_worker", false)
+	viper.Set("storage_backend", "bolt")
+	if !embeddedWorker() {
+		t.Fatalf("Failed - Worker is not embedded with bolt backend.")
+	}
+	viper.Set("storage_backend", "redis")
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestInboxActivityType(t *testing.T) {
+	for activityType, valid := range map[string]string{
+		"Follow":  "Follow",
+		"Create":  "Create",
+		"Like":    "other",
+		"Unknown": "other",
+	} {
+		if inboxActivityType(activityType) != valid {
+			t.Fatalf("Failed - Label of " + activityType + " is not " + valid)
+		}
+	}
+}
+
+func TestHandleInboxMetrics(t *testing.T) {
+	activity := mockActivity("Follow")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	before := testutil.ToFloat64(metrics.InboxRequests.WithLabelValues("Follow", "accepted"))
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	_, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if testutil.ToFloat64(metrics.InboxRequests.WithLabelValues("Follow", "accepted")) != before+1 {
+		t.Fatalf("Failed - Inbox request not counted.")
+	}
+	defaultTenant.relayState.DelSubscription(domain.Host)
+}
+
+func TestHandleInboxSignatureFailureMetrics(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, decodeActivity)
+	}))
+	defer s.Close()
+
+	beforeInvalid := testutil.ToFloat64(metrics.InboxRequests.WithLabelValues("unknown", "invalid"))
+	beforeFailure := testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("invalid_header"))
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	client := new(http.Client)
+	_, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if testutil.ToFloat64(metrics.InboxRequests.WithLabelValues("unknown", "invalid")) != beforeInvalid+1 {
+		t.Fatalf("Failed - Invalid inbox request not counted.")
+	}
+	if testutil.ToFloat64(metrics.SignatureFailures.WithLabelValues("invalid_header")) != beforeFailure+1 {
+		t.Fatalf("Failed - Signature failure not counted.")
+	}
+}
+
+func TestHandleInboxDuplicateActivity(t *testing.T) {
+	activity := mockActivity("Create")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   domain.Host,
+		InboxURL: "https://mastodon.test.yukimochi.io/inbox",
+	})
+
+	before := testutil.ToFloat64(metrics.DuplicateActivities.WithLabelValues("Create"))
+	client := new(http.Client)
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", s.URL, nil)
+		r, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed - " + err.Error())
+		}
+		if r.StatusCode != 202 {
+			t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+		}
+	}
+	if testutil.ToFloat64(metrics.DuplicateActivities.WithLabelValues("Create")) != before+1 {
+		t.Fatalf("Failed - Duplicated activity not counted.")
+	}
+
+	// Same object forwarded by another activity is also duplicated.
+	announce := activitypub.Activity{
+		ID:     "https://relay.example.org/announce/1",
+		Actor:  activity.Actor,
+		Type:   "Announce",
+		Object: activityObjectID(&activity),
+	}
+	if defaultTenant.firstDelivery(&announce) {
+		t.Fatalf("Failed - Forwarded object not detected as duplicated.")
+	}
+
+	defaultTenant.relayState.DelSubscription(domain.Host)
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// followPeer : Send Follow from relay actor to other relay, and track it as pending peer.
+func (tenant *Tenant) followPeer(actorURL string) (*state.Peer, error) {
+	var peerActor activitypub.Actor
+	err := tenant.retrieveActor(&peerActor, actorURL)
+	if err != nil {
+		return nil, err
+	}
+	domain, err := url.Parse(peerActor.ID)
+	if err != nil || domain.Host == "" {
+		return nil, errors.New("Invalid actor [" + actorURL + "] given")
+	}
+	if domain.Host == tenant.hostURL.Host {
+		return nil, errors.New("Relay can not peer with itself")
+	}
+	inboxURL := peerActor.Inbox
+	if peerActor.Endpoints.SharedInbox != "" {
+		inboxURL = peerActor.Endpoints.SharedInbox
+	}
+
+	follow := peerActor.GenerateFollow(tenant.hostURL)
+	jsonData, err := json.Marshal(&follow)
+	if err != nil {
+		return nil, err
+	}
+	tenant.pushRegistorJob(inboxURL, jsonData)
+	peer := state.Peer{
+		Domain:   domain.Host,
+		InboxURL: inboxURL,
+		ActorID:  peerActor.ID,
+		FollowID: follow.ID,
+		Status:   state.PeerPending,
+	}
+	return &peer, tenant.relayState.AddPeer(peer)
+}
+
+// unfollowPeer : Send Undo of Follow to peer relay, and forget it.
+func (tenant *Tenant) unfollowPeer(domain string) error {
+	peer := tenant.relayState.SelectPeer(domain)
+	if peer == nil {
+		return errors.New("Invalid domain [" + domain + "] given")
+	}
+	follow := activitypub.Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams"},
+		ID:      peer.FollowID,
+		Actor:   tenant.hostURL.String() + "/actor",
+		Type:    "Follow",
+		Object:  peer.ActorID,
+	}
+	undo := follow.GenerateResponse(tenant.hostURL, "Undo")
+	jsonData, err := json.Marshal(&undo)
+	if err != nil {
+		return err
+	}
+	tenant.pushRegistorJob(peer.InboxURL, jsonData)
+	return tenant.relayState.DelPeer(domain)
+}
+
+// peerResponse : Record Accept or Reject sent by peer relay for Follow of relay actor.
+// Returns false when the response is not for peer follow.
+func (tenant *Tenant) peerResponse(activity *activitypub.Activity) bool {
+	domain, err := url.Parse(activity.Actor)
+	if err != nil {
+		return false
+	}
+	peer := tenant.relayState.SelectPeer(domain.Host)
+	if peer == nil || peer.ActorID != activity.Actor {
+		return false
+	}
+	followID, _ := activity.Object.(string)
+	if nestedActivity, err := activity.NestedActivity(); err == nil {
+		followID = nestedActivity.ID
+	}
+	if followID != peer.FollowID {
+		return false
+	}
+
+	status := state.PeerAccepted
+	if activity.Type == "Reject" {
+		status = state.PeerRejected
+	}
+	err = tenant.relayState.SetPeerStatus(domain.Host, status)
+	if err != nil {
+		logger.WithError(err).WithField(logger.Domain, domain.Host).Error("Failed record peer response")
+		return false
+	}
+	return true
+}
+
+// relayedLoop : Check activity is forwarded back to this relay. Looped activity is counted.
+func (tenant *Tenant) relayedLoop(activity *activitypub.Activity) bool {
+	if !activity.RelayedBy(tenant.hostURL.String() + "/actor") {
+		return false
+	}
+	metrics.LoopedActivities.Inc()
+	return true
+}
+
+// markRelayVia : Carry forwarding relays of source activity over to Announce, and append relay actor itself.
+func (tenant *Tenant) markRelayVia(announce *activitypub.Activity, source *activitypub.Activity) {
+	announce.RelayVia = append(append([]string{}, source.RelayVia...), tenant.hostURL.String()+"/actor")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func mockPeer(status string) state.Peer {
+	return state.Peer{
+		Domain:   "relay.peer.example.org",
+		InboxURL: "https://relay.peer.example.org/inbox",
+		ActorID:  "https://relay.peer.example.org/actor",
+		FollowID: defaultTenant.hostURL.String() + "/activities/peer-follow",
+		Status:   status,
+	}
+}
+
+func TestHandleInboxPeerAccept(t *testing.T) {
+	peer := mockPeer(state.PeerPending)
+	defaultTenant.relayState.AddPeer(peer)
+	defer defaultTenant.relayState.DelPeer(peer.Domain)
+
+	follow := activitypub.Activity{
+		Context: []string{"https://www.w3.org/ns/activitystreams"},
+		ID:      peer.FollowID,
+		Actor:   defaultTenant.hostURL.String() + "/actor",
+		Type:    "Follow",
+		Object:  peer.ActorID,
+	}
+	accept := follow.GenerateResponse(defaultTenant.hostURL, "Accept")
+	accept.Actor = peer.ActorID
+	// Decode through JSON, nested Follow is received as map.
+	jsonData, _ := json.Marshal(&accept)
+	var activity activitypub.Activity
+	json.Unmarshal(jsonData, &activity)
+	actor := mockActor("Service")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	r, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+	if !defaultTenant.relayState.IsAcceptedPeer(peer.Domain) {
+		t.Fatalf("Failed - Peer is not accepted.")
+	}
+}
+
+func TestPeerResponseMismatchedFollow(t *testing.T) {
+	peer := mockPeer(state.PeerPending)
+	defaultTenant.relayState.AddPeer(peer)
+	defer defaultTenant.relayState.DelPeer(peer.Domain)
+
+	activity := activitypub.Activity{
+		Actor:  peer.ActorID,
+		Type:   "Reject",
+		Object: defaultTenant.hostURL.String() + "/activities/other-follow",
+	}
+	if defaultTenant.peerResponse(&activity) {
+		t.Fatalf("Failed - Response for other follow is recorded.")
+	}
+	activity.Object = peer.FollowID
+	if !defaultTenant.peerResponse(&activity) || defaultTenant.relayState.SelectPeer(peer.Domain).Status != state.PeerRejected {
+		t.Fatalf("Failed - Peer is not rejected.")
+	}
+}
+
+func TestRelayAcceptablePeerAnnounce(t *testing.T) {
+	activity := activitypub.Activity{
+		Actor:  "https://relay.peer.example.org/actor",
+		Type:   "Announce",
+		To:     []string{"https://relay.peer.example.org/actor/followers"},
+		Object: "https://mastodon.test.yukimochi.io/users/yukimochi/statuses/101075045564444857",
+	}
+	actor := mockActor("Service")
+
+	defaultTenant.relayState.AddPeer(mockPeer(state.PeerPending))
+	defer defaultTenant.relayState.DelPeer("relay.peer.example.org")
+	if defaultTenant.relayAcceptable(&activity, &actor) == nil {
+		t.Fatalf("Failed - Pending peer is accepted.")
+	}
+	defaultTenant.relayState.SetPeerStatus("relay.peer.example.org", state.PeerAccepted)
+	if defaultTenant.relayAcceptable(&activity, &actor) == nil {
+		t.Fatalf("Failed - Not public activity of peer is accepted.")
+	}
+	activity.Cc = []string{"https://www.w3.org/ns/activitystreams#Public"}
+	if defaultTenant.relayAcceptable(&activity, &actor) != nil {
+		t.Fatalf("Failed - Accepted peer is not accepted.")
+	}
+}
+
+func TestRelayedLoop(t *testing.T) {
+	activity := mockActivity("Announce")
+	activity.ID = activity.ID + "/looped"
+	activity.RelayVia = []string{"https://relay.peer.example.org/actor", defaultTenant.hostURL.String() + "/actor"}
+	if !defaultTenant.relayedLoop(&activity) {
+		t.Fatalf("Failed - Looped activity is not detected.")
+	}
+
+	var announce activitypub.Activity
+	activity.RelayVia = []string{"https://relay.peer.example.org/actor"}
+	json.Unmarshal(defaultTenant.litePubAnnounce(&activity, nil, defaultTenant.hostURL), &announce)
+	if len(announce.RelayVia) != 2 || !announce.RelayedBy(defaultTenant.hostURL.String()+"/actor") {
+		t.Fatalf("Failed - Relay actor is not marked on Announce.")
+	}
+	if defaultTenant.relayedLoop(&activity) {
+		t.Fatalf("Failed - Not looped activity is detected.")
+	}
+}
+
+func TestHandleInboxPeerAnnounce(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.AddPeer(mockPeer(state.PeerAccepted))
+	defaultTenant.relayState.AddSubscription(state.Subscription{
+		Domain:   "mastodon.example.com",
+		InboxURL: "https://mastodon.example.com/inbox",
+	})
+	defer func() {
+		defaultTenant.relayState.RedisClient.FlushAll().Result()
+		defaultTenant.relayState.Load()
+	}()
+
+	activity := activitypub.Activity{
+		ID:       "https://relay.peer.example.org/activities/peer-announce",
+		Actor:    "https://relay.peer.example.org/actor",
+		Type:     "Announce",
+		To:       []string{"https://relay.peer.example.org/actor/followers"},
+		Cc:       []string{"https://www.w3.org/ns/activitystreams#Public"},
+		Object:   "https://mastodon.test.yukimochi.io/users/yukimochi/statuses/101075045564444857",
+		RelayVia: []string{"https://relay.peer.example.org/actor"},
+	}
+	actor := mockActor("Service")
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	r, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+
+	// Relay job is enqueued asynchronously.
+	var jobs []string
+	for i := 0; i < 50 && len(jobs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		jobs, _ = defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Failed - Peer activity is not relayed.")
+	}
+	var signature tasks.Signature
+	var announce activitypub.Activity
+	json.Unmarshal([]byte(jobs[0]), &signature)
+	payload, _ := state.LoadPayload(defaultTenant.relayState.RedisClient, signature.Args[1].Value.(string))
+	json.Unmarshal(payload, &announce)
+	if announce.Type != "Announce" || announce.Actor != defaultTenant.hostURL.String()+"/actor" || announce.Object != activity.Object {
+		t.Fatalf("Failed - Peer activity is not announced by relay actor.")
+	}
+	if len(announce.RelayVia) != 2 || !announce.RelayedBy(activity.Actor) {
+		t.Fatalf("Failed - Forwarding relays are not marked.")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+	"github.com/spf13/viper"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// Tenant : Relay hosted on one relay domain. Each tenant has its own actor, key and relay state.
+type Tenant struct {
+	// Actor : Relay's Actor
+	Actor activitypub.Actor
+
+	// WebfingerResource : Relay's Webfinger resource
+	WebfingerResource activitypub.WebfingerResource
+
+	// Nodeinfo : Relay's Nodeinfo
+	Nodeinfo activitypub.NodeinfoResources
+
+	hostURL        *url.URL
+	hostPrivatekey *rsa.PrivateKey
+	relayState     state.RelayState
+
+	topicActorsMutex sync.Mutex
+	topicActors      map[string]*topicActor
+}
+
+var (
+	// defaultTenant : Relay configured by top level keys. It serves requests for unknown hosts.
+	defaultTenant *Tenant
+
+	// tenants : Tenants keyed by relay domain
+	tenants map[string]*Tenant
+)
+
+// newTenant : Load key and relay state of tenant
+func newTenant(config state.Tenant, redisClient *redis.Client) (*Tenant, error) {
+	hostURL, err := url.Parse("https://" + config.Domain)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := keyloader.ReadPrivateKeyRSAfromPath(config.ActorPem)
+	if err != nil {
+		return nil, err
+	}
+	backend, err := state.OpenBackend(viper.GetString("storage_backend"), redisClient, config.StoragePath, config.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := &Tenant{
+		hostURL:        hostURL,
+		hostPrivatekey: privateKey,
+		topicActors:    map[string]*topicActor{},
+	}
+	tenant.relayState = state.NewStateWithBackend(backend, true)
+	tenant.relayState.RedisClient = redisClient
+	tenant.relayState.Namespace = config.Namespace
+
+	tenant.Actor.Name = config.ServiceName
+	tenant.Actor.Summary = config.Summary
+	tenant.Actor.Icon = activitypub.Image{URL: config.Icon}
+	tenant.Actor.Image = activitypub.Image{URL: config.Image}
+	tenant.Actor.GenerateSelfKey(hostURL, &privateKey.PublicKey)
+	tenant.WebfingerResource.GenerateFromActor(hostURL, &tenant.Actor)
+	tenant.Nodeinfo.GenerateFromActor(hostURL, &tenant.Actor, version)
+	return tenant, nil
+}
+
+// tenantFromRequest : Select tenant by Host header of request. Unknown host is served by default tenant.
+func tenantFromRequest(request *http.Request) *Tenant {
+	host := strings.ToLower(request.Host)
+	if tenant, ok := tenants[host]; ok {
+		return tenant
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		if tenant, ok := tenants[hostname]; ok {
+			return tenant
+		}
+	}
+	return defaultTenant
+}
+
+// tenantHandler : Serve request by tenant which Host header points to.
+func tenantHandler(handler func(*Tenant, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		handler(tenantFromRequest(request), writer, request)
+	}
+}
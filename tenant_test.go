@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func mockTenant(t *testing.T, domain string) *Tenant {
+	namespace := state.TenantNamespace(domain)
+	redisClient := defaultTenant.relayState.RedisClient
+	tenant, err := newTenant(state.Tenant{
+		Domain:      domain,
+		ActorPem:    "misc/testKey.pem",
+		ServiceName: "Tenant Relay",
+		Namespace:   namespace,
+	}, redisClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant.relayState = state.NewStateWithBackend(state.NewNamespacedRedisBackend(redisClient, namespace), false)
+	tenant.relayState.RedisClient = redisClient
+	tenant.relayState.Namespace = namespace
+	tenants[domain] = tenant
+	return tenant
+}
+
+func TestTenantFromRequest(t *testing.T) {
+	tenant := mockTenant(t, "relay2.yukimochi.example.org")
+	defer delete(tenants, "relay2.yukimochi.example.org")
+
+	for host, expected := range map[string]*Tenant{
+		"relay2.yukimochi.example.org":     tenant,
+		"RELAY2.yukimochi.example.org:443": tenant,
+		"relay.yukimochi.example.org":      defaultTenant,
+		"unknown.example.org":              defaultTenant,
+	} {
+		req, _ := http.NewRequest("GET", "/actor", nil)
+		req.Host = host
+		if tenantFromRequest(req) != expected {
+			t.Fatalf("Failed - Wrong tenant selected for " + host)
+		}
+	}
+}
+
+func TestHandleActorTenant(t *testing.T) {
+	mockTenant(t, "relay2.yukimochi.example.org")
+	defer delete(tenants, "relay2.yukimochi.example.org")
+
+	s := httptest.NewServer(tenantHandler((*Tenant).handleActor))
+	defer s.Close()
+
+	for host, expected := range map[string]string{
+		"relay2.yukimochi.example.org": "https://relay2.yukimochi.example.org/actor",
+		"unknown.example.org":          defaultTenant.Actor.ID,
+	} {
+		req, _ := http.NewRequest("GET", s.URL, nil)
+		req.Host = host
+		r, err := new(http.Client).Do(req)
+		if err != nil {
+			t.Fatalf("Failed - " + err.Error())
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		var actor activitypub.Actor
+		json.Unmarshal(data, &actor)
+		if actor.ID != expected {
+			t.Fatalf("Failed - Actor of wrong tenant is served for " + host)
+		}
+	}
+}
+
+func TestHandleInboxTenantFollow(t *testing.T) {
+	tenant := mockTenant(t, "relay2.yukimochi.example.org")
+	defer delete(tenants, "relay2.yukimochi.example.org")
+
+	activity := mockActivity("Follow")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(tenantHandler(func(tenant *Tenant, w http.ResponseWriter, r *http.Request) {
+		tenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	req, _ := http.NewRequest("POST", s.URL, nil)
+	req.Host = "relay2.yukimochi.example.org"
+	r, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202")
+	}
+	defaultTenant.relayState.Load()
+	if tenant.relayState.SelectSubscription(domain.Host) == nil {
+		t.Fatalf("Failed - Subscription is not added to tenant.")
+	}
+	if defaultTenant.relayState.SelectSubscription(domain.Host) != nil {
+		t.Fatalf("Failed - Subscription is leaked into default tenant.")
+	}
+	res, _ := tenant.relayState.RedisClient.Exists("tenant:relay2.yukimochi.example.org:relay:subscription:" + domain.Host).Result()
+	if res != 1 {
+		t.Fatalf("Failed - Subscription is not stored in tenant namespace.")
+	}
+	tenant.relayState.DelSubscription(domain.Host)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+// topicActor : Topic actor generated from keypair of topic
+type topicActor struct {
+	privateKey string
+	actor      activitypub.Actor
+}
+
+// relayURL : Base URL of relay actor. Empty topic is relay actor itself, and topic actor is served under /<topic>.
+func (tenant *Tenant) relayURL(topic string) *url.URL {
+	if topic == "" {
+		return tenant.hostURL
+	}
+	base := *tenant.hostURL
+	base.Path = "/" + topic
+	return &base
+}
+
+// selectTopicActor : Actor of topic. Generated actor is kept while keypair of topic is not changed.
+func (tenant *Tenant) selectTopicActor(topic *state.Topic) (*activitypub.Actor, error) {
+	tenant.topicActorsMutex.Lock()
+	defer tenant.topicActorsMutex.Unlock()
+	cached, ok := tenant.topicActors[topic.Name]
+	if ok && cached.privateKey == topic.PrivateKey {
+		return &cached.actor, nil
+	}
+	privateKey, err := keyloader.ReadPrivateKeyRSAfromString(topic.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	cached = &topicActor{privateKey: topic.PrivateKey}
+	cached.actor.GenerateSelfKey(tenant.relayURL(topic.Name), &privateKey.PublicKey)
+	cached.actor.Name = tenant.Actor.Name + " - " + topic.Name
+	cached.actor.Summary = tenant.Actor.Summary
+	cached.actor.Icon = tenant.Actor.Icon
+	cached.actor.Image = tenant.Actor.Image
+	tenant.topicActors[topic.Name] = cached
+	return &cached.actor, nil
+}
+
+// relayActorTopic : Check object is relay actor or topic actor, and select topic of it.
+func (tenant *Tenant) relayActorTopic(object interface{}) (string, bool) {
+	actorID, ok := object.(string)
+	if !ok {
+		return "", false
+	}
+	if actorID == tenant.hostURL.String()+"/actor" {
+		return "", true
+	}
+	for _, topic := range tenant.relayState.Topics {
+		if actorID == tenant.relayURL(topic.Name).String()+"/actor" {
+			return topic.Name, true
+		}
+	}
+	return "", false
+}
+
+// activityTopic : Select topic which Follow is sent to, by inbox path or followed actor.
+// Returns empty string for relay actor itself.
+func (tenant *Tenant) activityTopic(request *http.Request, activity *activitypub.Activity) string {
+	path := strings.Trim(request.URL.Path, "/")
+	if name := strings.TrimSuffix(path, "/inbox"); name != path && tenant.relayState.SelectTopic(name) != nil {
+		return name
+	}
+	topic, _ := tenant.relayActorTopic(activity.Object)
+	return topic
+}
+
+// topicFollowers : Actors which follow topic
+func (tenant *Tenant) topicFollowers(name string, litePubOnly bool) []string {
+	var followers []string
+	for _, subscription := range tenant.relayState.Subscriptions {
+		if subscription.ActorID == "" || (litePubOnly && !subscription.IsLitePub()) {
+			continue
+		}
+		if contains(subscription.Topics, name) {
+			followers = append(followers, subscription.ActorID)
+		}
+	}
+	return followers
+}
+
+// handleTopic : Serve actor, collections and inbox of topic actors under /<topic>.
+func (tenant *Tenant) handleTopic(writer http.ResponseWriter, request *http.Request) {
+	path := strings.Trim(request.URL.Path, "/")
+	for _, suffix := range []string{"actor/followers", "actor/following", "actor/outbox", "actor", "inbox"} {
+		name := strings.TrimSuffix(path, "/"+suffix)
+		if name == path {
+			continue
+		}
+		topic := tenant.relayState.SelectTopic(name)
+		if topic == nil {
+			break
+		}
+		actor, err := tenant.selectTopicActor(topic)
+		if err != nil {
+			break
+		}
+		switch suffix {
+		case "actor":
+			handleActorResource(writer, request, actor)
+		case "actor/followers":
+			handleCollection(writer, request, actor.Followers, tenant.topicFollowers(name, false))
+		case "actor/following":
+			handleCollection(writer, request, actor.Following, tenant.topicFollowers(name, true))
+		case "actor/outbox":
+			handleCollection(writer, request, actor.Outbox, nil)
+		case "inbox":
+			tenant.handleInbox(writer, request, decodeActivity)
+		}
+		return
+	}
+	writer.WriteHeader(404)
+	writer.Write(nil)
+}
+
+// topicWebfinger : Webfinger resource of topic actor which subject is given. Returns nil when not found.
+func (tenant *Tenant) topicWebfinger(subject string) *activitypub.WebfingerResource {
+	for _, topic := range tenant.relayState.Topics {
+		if subject != "acct:"+topic.Username()+"@"+tenant.hostURL.Host {
+			continue
+		}
+		actor, err := tenant.selectTopicActor(&topic)
+		if err != nil {
+			return nil
+		}
+		var resource activitypub.WebfingerResource
+		resource.GenerateFromActor(tenant.hostURL, actor)
+		return &resource
+	}
+	return nil
+}
+
+// writeWebfinger : Write webfinger resource
+func writeWebfinger(writer http.ResponseWriter, resource *activitypub.WebfingerResource) {
+	wfresource, err := json.Marshal(resource)
+	if err != nil {
+		panic(err)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(wfresource)
+}
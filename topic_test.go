@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
+	state "github.com/yukimochi/Activity-Relay/State"
+)
+
+func TestHandleTopicActor(t *testing.T) {
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "tags/art", Criteria: state.Preferences{Hashtags: []string{"art"}}})
+	defer defaultTenant.relayState.DelTopic("tags/art")
+	s := httptest.NewServer(tenantHandler((*Tenant).handleTopic))
+	defer s.Close()
+
+	r, err := http.Get(s.URL + "/tags/art/actor")
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 200 {
+		t.Fatalf("Failed - StatusCode is not 200 - " + strconv.Itoa(r.StatusCode))
+	}
+	defer r.Body.Close()
+	data, _ := ioutil.ReadAll(r.Body)
+	var actor activitypub.Actor
+	json.Unmarshal(data, &actor)
+	if actor.ID != defaultTenant.hostURL.String()+"/tags/art/actor" || actor.Inbox != defaultTenant.hostURL.String()+"/tags/art/inbox" || actor.PreferredUsername != "tags_art" {
+		t.Fatalf("Failed - Topic actor is not valid.")
+	}
+	if actor.PublicKey.PublicKeyPem == "" || actor.PublicKey.PublicKeyPem == defaultTenant.Actor.PublicKey.PublicKeyPem {
+		t.Fatalf("Failed - Topic actor should have own keypair.")
+	}
+
+	r, _ = http.Get(s.URL + "/tags/unknown/actor")
+	if r.StatusCode != 404 {
+		t.Fatalf("Failed - Unknown topic should be not found.")
+	}
+}
+
+func TestHandleWebfingerTopic(t *testing.T) {
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "lang/ja", Criteria: state.Preferences{Languages: []string{"ja"}}})
+	defer defaultTenant.relayState.DelTopic("lang/ja")
+	s := httptest.NewServer(tenantHandler((*Tenant).handleWebfinger))
+	defer s.Close()
+
+	r, err := http.Get(s.URL + "?resource=" + url.QueryEscape("acct:lang_ja@"+defaultTenant.hostURL.Host))
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 200 {
+		t.Fatalf("Failed - StatusCode is not 200 - " + strconv.Itoa(r.StatusCode))
+	}
+	defer r.Body.Close()
+	data, _ := ioutil.ReadAll(r.Body)
+	var wfresource activitypub.WebfingerResource
+	json.Unmarshal(data, &wfresource)
+	if wfresource.Links[0].Href != defaultTenant.hostURL.String()+"/lang/ja/actor" {
+		t.Fatalf("Failed - defaultTenant.WebfingerResource of topic actor is not valid.")
+	}
+}
+
+func TestHandleInboxTopicFollow(t *testing.T) {
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "tags/art", Criteria: state.Preferences{Hashtags: []string{"art"}}})
+	defer defaultTenant.relayState.DelTopic("tags/art")
+	activity := mockActivity("Follow")
+	actor := mockActor("Person")
+	domain, _ := url.Parse(activity.Actor)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defaultTenant.handleInbox(w, r, mockActivityDecoderProvider(&activity, &actor))
+	}))
+	defer s.Close()
+
+	defaultTenant.relayState.RedisClient.Del("relay").Result()
+	req, _ := http.NewRequest("POST", s.URL+"/tags/art/inbox", nil)
+	r, err := new(http.Client).Do(req)
+	if err != nil {
+		t.Fatalf("Failed - " + err.Error())
+	}
+	if r.StatusCode != 202 {
+		t.Fatalf("Failed - StatusCode is not 202 - " + strconv.Itoa(r.StatusCode))
+	}
+	subscription := defaultTenant.relayState.SelectSubscription(domain.Host)
+	if subscription == nil || !reflect.DeepEqual(subscription.Topics, []string{"tags/art"}) {
+		t.Fatalf("Failed - Topic subscription not works.")
+	}
+
+	// Accept is sent by topic actor, and enqueued asynchronously.
+	var jobs []string
+	for i := 0; i < 50 && len(jobs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		jobs, _ = defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("Failed - Accept is not enqueued.")
+	}
+	var signature tasks.Signature
+	var accept activitypub.Activity
+	json.Unmarshal([]byte(jobs[0]), &signature)
+	json.Unmarshal([]byte(signature.Args[1].Value.(string)), &accept)
+	if accept.Type != "Accept" || accept.Actor != defaultTenant.hostURL.String()+"/tags/art/actor" {
+		t.Fatalf("Failed - Accept is not sent by topic actor.")
+	}
+	defaultTenant.relayState.RedisClient.Del("relay").Result()
+	defaultTenant.relayState.DelSubscription(domain.Host)
+}
+
+func TestPushRelayJobTopic(t *testing.T) {
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "lang/ja", Criteria: state.Preferences{Languages: []string{"ja"}}})
+	defaultTenant.relayState.AddTopic(state.Topic{Name: "tags/art", Criteria: state.Preferences{Hashtags: []string{"art"}}})
+	defaultTenant.relayState.AddTopicSubscription(state.Subscription{
+		Domain:   "a.example.com",
+		InboxURL: "https://a.example.com/inbox",
+		Protocol: state.LitePubProtocol,
+	}, "lang/ja")
+	defaultTenant.relayState.AddTopicSubscription(state.Subscription{
+		Domain:   "b.example.com",
+		InboxURL: "https://b.example.com/inbox",
+	}, "tags/art")
+
+	activity := mockActivity("Create")
+	body, _ := json.Marshal(&activity)
+	defaultTenant.pushRelayJob("c.example.com", &activity, body, defaultTenant.litePubAnnounce(&activity, body, defaultTenant.hostURL))
+
+	jobs, _ := defaultTenant.relayState.RedisClient.LRange("relay", 0, -1).Result()
+	if len(jobs) != 1 {
+		t.Fatalf("Failed - Topic criteria are not evaluated.")
+	}
+	var signature tasks.Signature
+	json.Unmarshal([]byte(jobs[0]), &signature)
+	payload, _ := state.LoadPayload(defaultTenant.relayState.RedisClient, signature.Args[1].Value.(string))
+	var announce activitypub.Activity
+	json.Unmarshal(payload, &announce)
+	if signature.Args[0].Value != "https://a.example.com/inbox" || announce.Type != "Announce" || announce.Actor != defaultTenant.hostURL.String()+"/lang/ja/actor" {
+		t.Fatalf("Failed - Announce is not sent by topic actor.")
+	}
+
+	defaultTenant.relayState.RedisClient.FlushAll().Result()
+	defaultTenant.relayState.Load()
+}
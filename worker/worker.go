@@ -1,82 +1,88 @@
 package main
 
 import (
-	"crypto/rsa"
-	"fmt"
+	"errors"
 	"net/http"
-	"net/url"
-	"os"
-	"time"
 
 	"github.com/RichardKnop/machinery/v1"
 	"github.com/RichardKnop/machinery/v1/config"
-	"github.com/RichardKnop/machinery/v1/log"
 	"github.com/go-redis/redis"
-	uuid "github.com/satori/go.uuid"
 	"github.com/spf13/viper"
-	activitypub "github.com/yukimochi/Activity-Relay/ActivityPub"
-	keyloader "github.com/yukimochi/Activity-Relay/KeyLoader"
+	delivery "github.com/yukimochi/Activity-Relay/Delivery"
+	logger "github.com/yukimochi/Activity-Relay/Logger"
+	metrics "github.com/yukimochi/Activity-Relay/Metrics"
+	state "github.com/yukimochi/Activity-Relay/State"
 )
 
 var (
 	version string
 
-	// Actor : Relay's Actor
-	Actor activitypub.Actor
-
-	hostURL         *url.URL
-	hostPrivatekey  *rsa.PrivateKey
 	redisClient     *redis.Client
 	machineryServer *machinery.Server
-	httpClient      *http.Client
 )
 
-func relayActivity(args ...string) error {
-	inboxURL := args[0]
-	body := args[1]
-	err := sendActivity(inboxURL, Actor.ID, []byte(body), hostPrivatekey)
-	if err != nil {
-		domain, _ := url.Parse(inboxURL)
-		mod, _ := redisClient.HSetNX("relay:statistics:"+domain.Host, "last_error", err.Error()).Result()
-		if mod {
-			redisClient.Expire("relay:statistics:"+domain.Host, time.Duration(time.Minute))
-		}
-	}
-	return err
-}
-
-func registorActivity(args ...string) error {
-	inboxURL := args[0]
-	body := args[1]
-	err := sendActivity(inboxURL, Actor.ID, []byte(body), hostPrivatekey)
-	return err
-}
-
 func initConfig() {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
+	viper.SetDefault("storage_backend", "redis")
+	viper.SetDefault("storage_path", "relay.db")
+	delivery.SetDefaults()
+	viper.SetDefault("metrics_bind", "0.0.0.0:8081")
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "text")
 	err := viper.ReadInConfig()
 	if err != nil {
-		fmt.Println("Config file is not exists. Use environment variables.")
+		logger.Info("Config file is not exists. Use environment variables.")
 		viper.BindEnv("actor_pem")
 		viper.BindEnv("redis_url")
 		viper.BindEnv("relay_bind")
 		viper.BindEnv("relay_domain")
 		viper.BindEnv("relay_servicename")
-	} else {
-		Actor.Summary = viper.GetString("relay_summary")
-		Actor.Icon = activitypub.Image{URL: viper.GetString("relay_icon")}
-		Actor.Image = activitypub.Image{URL: viper.GetString("relay_image")}
+		viper.BindEnv("storage_backend")
+		viper.BindEnv("storage_path")
+		delivery.BindEnv()
+		viper.BindEnv("metrics_bind")
+		viper.BindEnv("log_level")
+		viper.BindEnv("log_format")
+	}
+	err = logger.Configure(viper.GetString("log_level"), viper.GetString("log_format"))
+	if err != nil {
+		panic(err)
+	}
+	logger.BridgeMachinery()
+	if viper.GetString("storage_backend") == "bolt" {
+		// BoltDB file is locked by server, so jobs are processed by worker embedded in server.
+		panic(errors.New("Worker can not open bolt storage backend used by server, run server with embedded_worker instead"))
 	}
-	Actor.Name = viper.GetString("relay_servicename")
 
-	hostURL, _ = url.Parse("https://" + viper.GetString("relay_domain"))
-	hostPrivatekey, _ = keyloader.ReadPrivateKeyRSAfromPath(viper.GetString("actor_pem"))
+	var tenantConfigs []state.Tenant
+	err = viper.UnmarshalKey("tenants", &tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
+	tenantConfigs, err = state.NormalizeTenants(state.Tenant{
+		Domain:      viper.GetString("relay_domain"),
+		ActorPem:    viper.GetString("actor_pem"),
+		ServiceName: viper.GetString("relay_servicename"),
+		StoragePath: viper.GetString("storage_path"),
+	}, tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
 	redisOption, err := redis.ParseURL(viper.GetString("redis_url"))
 	if err != nil {
 		panic(err)
 	}
 	redisClient = redis.NewClient(redisOption)
+	var tenantDomains []string
+	for _, config := range tenantConfigs {
+		tenantDomains = append(tenantDomains, config.Domain)
+	}
+	err = delivery.Setup(version, redisClient, tenantConfigs)
+	if err != nil {
+		panic(err)
+	}
+	metrics.RegisterQueueGauges(redisClient, "relay")
 	machineryConfig := &config.Config{
 		Broker:          viper.GetString("redis_url"),
 		DefaultQueue:    "relay",
@@ -87,34 +93,46 @@ func initConfig() {
 	if err != nil {
 		panic(err)
 	}
-	httpClient = &http.Client{Timeout: time.Duration(5) * time.Second}
-
-	Actor.GenerateSelfKey(hostURL, &hostPrivatekey.PublicKey)
-	newNullLogger := NewNullLogger()
-	log.DEBUG = newNullLogger
 
-	fmt.Println("Welcome to YUKIMOCHI Activity-Relay [Worker]", version)
-	fmt.Println(" - Configurations")
-	fmt.Println("RELAY DOMAIN : ", hostURL.Host)
-	fmt.Println("REDIS URL : ", viper.GetString("redis_url"))
+	logger.WithFields(logger.Fields{
+		"version":                         version,
+		"relay_domain":                    tenantDomains[0],
+		"tenants":                         tenantDomains,
+		"redis_url":                       viper.GetString("redis_url"),
+		"job_retry_max":                   viper.GetInt("job_retry_max"),
+		"job_retry_window":                viper.GetDuration("job_retry_window").String(),
+		"job_concurrency":                 viper.GetInt("job_concurrency"),
+		"host_max_inflight":               viper.GetInt("host_max_inflight"),
+		"breaker_failure_threshold":       viper.GetInt("breaker_failure_threshold"),
+		"breaker_open_duration":           viper.GetDuration("breaker_open_duration").String(),
+		"subscriber_unreachable_failures": viper.GetInt("subscriber_unreachable_failures"),
+		"subscriber_unreachable_after":    viper.GetDuration("subscriber_unreachable_after").String(),
+		"subscriber_drop_after":           viper.GetDuration("subscriber_drop_after").String(),
+		"metrics_bind":                    viper.GetString("metrics_bind"),
+	}).Info("Welcome to YUKIMOCHI Activity-Relay [Worker]")
 }
 
 func main() {
 	initConfig()
 
-	err := machineryServer.RegisterTask("registor", registorActivity)
+	err := delivery.RegisterTasks(machineryServer)
 	if err != nil {
 		panic(err.Error())
 	}
-	err = machineryServer.RegisterTask("relay", relayActivity)
-	if err != nil {
-		panic(err.Error())
+
+	if viper.GetString("metrics_bind") != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			err := http.ListenAndServe(viper.GetString("metrics_bind"), mux)
+			if err != nil {
+				logger.WithError(err).Error("Failed serve metrics")
+			}
+		}()
 	}
 
-	workerID := uuid.NewV4()
-	worker := machineryServer.NewWorker(workerID.String(), 200)
-	err = worker.Launch()
+	err = delivery.Launch(machineryServer)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		logger.WithError(err).Error("Worker stopped")
 	}
 }
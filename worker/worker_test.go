@@ -1,13 +1,12 @@
 package main
 
 import (
-	"io/ioutil"
-	"net/http"
-	"net/http/httptest"
-	"net/url"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/spf13/viper"
 )
 
@@ -23,100 +22,31 @@ func TestMain(m *testing.M) {
 	redisClient.FlushAll().Result()
 }
 
-func TestRelayActivity(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		data, _ := ioutil.ReadAll(r.Body)
-		if string(data) != "data" || r.Header.Get("Content-Type") != "application/activity+json" {
-			w.WriteHeader(500)
-			w.Write(nil)
-		} else {
-			w.WriteHeader(202)
-			w.Write(nil)
-		}
-	}))
-	defer s.Close()
+func TestQueueDepthMetrics(t *testing.T) {
+	redisClient.Del("relay", "delayed_tasks").Result()
+	redisClient.RPush("relay", "job1", "job2").Result()
 
-	err := relayActivity(s.URL, "data")
+	expected := strings.NewReader(`# HELP relay_queue_depth Number of jobs waiting in queue.
+# TYPE relay_queue_depth gauge
+relay_queue_depth{queue="delayed"} 0
+relay_queue_depth{queue="relay"} 2
+`)
+	err := testutil.GatherAndCompare(prometheus.DefaultGatherer, expected, "relay_queue_depth")
 	if err != nil {
-		t.Fatal("Failed - Data transfar not collect")
-	}
-}
-
-func TestRelayActivityNoHost(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-	}))
-	defer s.Close()
-
-	err := relayActivity("http://nohost.example.jp", "data")
-	if err == nil {
-		t.Fatal("Failed - Error not reported.")
-	}
-	domain, _ := url.Parse("http://nohost.example.jp")
-	data, err := redisClient.HGet("relay:statistics:"+domain.Host, "last_error").Result()
-	if data == "" {
-		t.Fatal("Failed - Error not cached.")
+		t.Fatalf("Failed - " + err.Error())
 	}
+	redisClient.Del("relay").Result()
 }
 
-func TestRelayActivityResp500(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(500)
-		w.Write(nil)
-	}))
-	defer s.Close()
-
-	err := relayActivity(s.URL, "data")
-	if err == nil {
-		t.Fatal("Failed - Error not reported.")
-	}
-	domain, _ := url.Parse(s.URL)
-	data, err := redisClient.HGet("relay:statistics:"+domain.Host, "last_error").Result()
-	if data == "" {
-		t.Fatal("Failed - Error not cached.")
-	}
-}
+func TestInitConfigBolt(t *testing.T) {
+	viper.Set("storage_backend", "bolt")
+	defer viper.Set("storage_backend", "redis")
 
-func TestRegistorActivity(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		data, _ := ioutil.ReadAll(r.Body)
-		if string(data) != "data" || r.Header.Get("Content-Type") != "application/activity+json" {
-			w.WriteHeader(500)
-			w.Write(nil)
-		} else {
-			w.WriteHeader(202)
-			w.Write(nil)
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil || !strings.Contains(err.Error(), "embedded_worker") {
+			t.Fatalf("Failed - Worker opens bolt storage backend used by server.")
 		}
-	}))
-	defer s.Close()
-
-	err := registorActivity(s.URL, "data")
-	if err != nil {
-		t.Fatal("Failed - Data transfar not collect")
-	}
-}
-
-func TestRegistorActivityNoHost(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-	}))
-	defer s.Close()
-
-	err := registorActivity("http://nohost.example.jp", "data")
-	if err == nil {
-		t.Fatal("Failed - Error not reported.")
-	}
-}
-
-func TestRegistorActivityResp500(t *testing.T) {
-	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(500)
-		w.Write(nil)
-	}))
-	defer s.Close()
-
-	err := registorActivity(s.URL, "data")
-	if err == nil {
-		t.Fatal("Failed - Error not reported.")
-	}
+	}()
+	initConfig()
 }